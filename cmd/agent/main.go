@@ -3,11 +3,13 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -22,6 +24,7 @@ import (
 
 	a "github.com/tony-spark/metrico/internal/agent"
 	"github.com/tony-spark/metrico/internal/agent/config"
+	"github.com/tony-spark/metrico/internal/agent/outbox"
 )
 
 var (
@@ -42,38 +45,30 @@ func main() {
 		log.Fatal().Err(err).Msg("Could not parse config")
 	}
 
-	var t transports.Transport
-
-	if len(config.Config.Address) == 0 && len(config.Config.GrpcAddress) == 0 {
+	urls := config.Config.DestinationURLs()
+	if len(urls) == 0 {
 		log.Fatal().Msg("you should define address (grpc or http)")
 	}
 
-	if len(config.Config.Address) > 0 {
-		baseURL := "http://" + strings.Trim(config.Config.Address, "\"")
-
-		var options []httpTransport.Option
-		if len(config.Config.Key) > 0 {
-			options = append(options, httpTransport.WithHasher(hash.NewSha256Hmac(config.Config.Key)))
+	var t transports.Transport
+	if len(urls) == 1 {
+		t, err = newTransport(urls[0], config.Config.Standby, config.Config.OutboxDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize transport")
 		}
-		if len(config.Config.PublicKeyFile) > 0 {
-			var encryptor crypto.Encryptor
-			encryptor, err = crypto.NewRSAEncryptorFromFile(config.Config.PublicKeyFile, "metrico")
+	} else {
+		ds := make([]transports.Destination, 0, len(urls))
+		for _, u := range urls {
+			var dt transports.Transport
+			dt, err = newTransport(u, "", outboxDir(u))
 			if err != nil {
-				log.Fatal().Err(err).Msg("could not parse public key")
+				log.Fatal().Err(err).Msgf("could not initialize transport to %s", u)
 			}
-			options = append(options, httpTransport.WithEncryptor(encryptor))
+			ds = append(ds, transports.Destination{Name: u, Transport: dt})
 		}
-		t = httpTransport.NewTransport(baseURL, options...)
-	}
-
-	if len(config.Config.GrpcAddress) > 0 {
-		var options []grpcTransport.Option
-		if len(config.Config.Key) > 0 {
-			options = append(options, grpcTransport.WithHasher(hash.NewSha256Hmac(config.Config.Key)))
-		}
-		t, err = grpcTransport.NewTransport(config.Config.GrpcAddress, options...)
+		t, err = transports.NewMulti(config.Config.FanOutMode, ds...)
 		if err != nil {
-			log.Fatal().Err(err).Msg("could not initialize grpc transport")
+			log.Fatal().Err(err).Msg("could not initialize fan-out transport")
 		}
 	}
 
@@ -81,6 +76,8 @@ func main() {
 		a.WithTransport(t),
 		a.WithPollInterval(config.Config.PollInterval),
 		a.WithReportInterval(config.Config.ReportInterval),
+		a.WithLabels(config.Config.Labels),
+		a.WithSendLatency(config.Config.LatencyBuckets),
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -108,3 +105,120 @@ func main() {
 
 	log.Info().Msg("agent shut down")
 }
+
+// newTransport creates transport to a given destination URL, which tracks counter increments, keeps outbox in
+// outboxDir (if set) and fails over to standby URL (if set)
+func newTransport(destination string, standby string, outboxDir string) (transports.Transport, error) {
+	t, prober, err := newClient(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(standby) > 0 {
+		var s transports.Transport
+		s, _, err = newClient(standby)
+		if err != nil {
+			return nil, fmt.Errorf("could not initialize standby: %w", err)
+		}
+		t = transports.NewFailover(t, s, prober, config.Config.Failover)
+	}
+
+	if len(outboxDir) > 0 {
+		o, err := outbox.New(outboxDir, t, outbox.WithMaxSize(config.Config.OutboxSize))
+		if err != nil {
+			return nil, fmt.Errorf("could not initialize outbox: %w", err)
+		}
+		t = o
+	}
+
+	return transports.NewDeltaTracking(t), nil
+}
+
+// newClient creates transport to a given server URL, which retries sending. Prober of server is returned too
+func newClient(destination string) (transports.Transport, transports.Prober, error) {
+	scheme, addr, ok := strings.Cut(destination, "://")
+	if !ok {
+		return nil, nil, fmt.Errorf("destination %s is not an URL", destination)
+	}
+
+	var tlsConfig *tls.Config
+	if config.Config.UseTLS() {
+		var err error
+		tlsConfig, err = crypto.NewClientTLSConfig(config.Config.TLSCAFile, config.Config.TLSCertFile, config.Config.TLSKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not configure TLS: %w", err)
+		}
+	}
+	var encryptor crypto.Encryptor
+	if len(config.Config.PublicKeyFile) > 0 {
+		var err error
+		encryptor, err = crypto.NewHybridEncryptorFromFile(config.Config.PublicKeyFile, "metrico")
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse public key: %w", err)
+		}
+	}
+
+	var t transports.Transport
+	switch scheme {
+	case "http", "https":
+		options := []httpTransport.Option{
+			httpTransport.WithAgentID(config.Config.ID),
+			httpTransport.WithTimeout(config.Config.Timeout),
+		}
+		if len(config.Config.Key) > 0 {
+			options = append(options, httpTransport.WithHasher(hash.NewSha256Hmac(config.Config.Key)))
+		}
+		if tlsConfig != nil {
+			options = append(options, httpTransport.WithTLSConfig(tlsConfig))
+		}
+		if encryptor != nil {
+			options = append(options, httpTransport.WithEncryptor(encryptor))
+		}
+		if config.Config.Protobuf {
+			options = append(options, httpTransport.WithProtobuf())
+		}
+		t = httpTransport.NewTransport(destination, options...)
+	case "grpc":
+		options := []grpcTransport.Option{
+			grpcTransport.WithAgentID(config.Config.ID),
+			grpcTransport.WithTimeout(config.Config.Timeout),
+		}
+		if len(config.Config.Key) > 0 {
+			options = append(options, grpcTransport.WithHasher(hash.NewSha256Hmac(config.Config.Key)))
+		}
+		if tlsConfig != nil {
+			options = append(options, grpcTransport.WithTLSConfig(tlsConfig))
+		}
+		if encryptor != nil {
+			options = append(options, grpcTransport.WithEncryptor(encryptor))
+		}
+		var err error
+		t, err = grpcTransport.NewTransport(addr, options...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not initialize grpc transport: %w", err)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported destination scheme: %s", scheme)
+	}
+	prober, _ := t.(transports.Prober)
+
+	if config.Config.Retry.MaxAttempts > 1 {
+		t = transports.NewRetrying(t, config.Config.Retry)
+	}
+
+	return t, prober, nil
+}
+
+// outboxDir returns outbox directory of one of several destinations (empty if outbox is not kept)
+func outboxDir(destination string) string {
+	if len(config.Config.OutboxDir) == 0 {
+		return ""
+	}
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, destination)
+	return filepath.Join(config.Config.OutboxDir, name)
+}
@@ -3,8 +3,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -14,6 +14,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/tony-spark/metrico/internal/crypto"
 	"github.com/tony-spark/metrico/internal/hash"
+	"github.com/tony-spark/metrico/internal/server/alerting"
 	grpcController "github.com/tony-spark/metrico/internal/server/grpc"
 	httpController "github.com/tony-spark/metrico/internal/server/http"
 	"github.com/tony-spark/metrico/internal/server/models"
@@ -65,7 +66,11 @@ func main() {
 		grpcCtrlOpts = append(grpcCtrlOpts, grpcController.WithDBManager(dbm))
 	} else {
 		var p models.RepositoryPersistence
-		r = storage.NewSingleValueRepository()
+		if config.Config.KeepHistory {
+			r = storage.NewHistoryRepository()
+		} else {
+			r = storage.NewSingleValueRepository()
+		}
 		serverOpts = append(serverOpts, server.WithMetricRepository(r))
 		p, err = storage.NewJSONFilePersistence(config.Config.StoreFilename)
 		if err != nil {
@@ -84,24 +89,66 @@ func main() {
 
 	if len(config.Config.PrivateKeyFile) > 0 {
 		var d crypto.Decryptor
-		d, err = crypto.NewRSADecryptorFromFile(config.Config.PrivateKeyFile, "metrico")
+		d, err = crypto.NewHybridDecryptorFromFile(config.Config.PrivateKeyFile, "metrico")
 		if err != nil {
 			log.Fatal().Err(err).Msg("could not initialize decryptor")
 		}
 		httpCtrlOpts = append(httpCtrlOpts, httpController.WithDecryptor(d))
+		grpcCtrlOpts = append(grpcCtrlOpts, grpcController.WithDecryptor(d))
 	}
 
-	if len(config.Config.TrustedSubnet) > 0 {
-		var subnet *net.IPNet
-		_, subnet, err = net.ParseCIDR(config.Config.TrustedSubnet)
+	if len(config.Config.TLSCertFile) > 0 {
+		var tlsConfig *tls.Config
+		tlsConfig, err = crypto.NewServerTLSConfig(config.Config.TLSCertFile, config.Config.TLSKeyFile, config.Config.TLSClientCAFile)
 		if err != nil {
-			log.Fatal().Err(err).Msg("could not parse subnet")
+			log.Fatal().Err(err).Msg("could not configure TLS")
+		}
+		httpCtrlOpts = append(httpCtrlOpts, httpController.WithTLSConfig(tlsConfig))
+		grpcCtrlOpts = append(grpcCtrlOpts, grpcController.WithTLSConfig(tlsConfig))
+	}
+
+	policy, err := config.Config.AccessPolicy()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not configure access policy")
+	}
+	httpCtrlOpts = append(httpCtrlOpts, httpController.WithAccessPolicy(policy))
+	grpcCtrlOpts = append(grpcCtrlOpts, grpcController.WithAccessPolicy(policy))
+
+	metricService := services.NewMetricService(r, postUpdateFn,
+		services.WithStaleAfter(config.Config.StaleAfter()),
+		services.WithDedupWindow(config.Config.DedupWindow),
+	)
+
+	if !config.Config.Retention.IsZero() {
+		h, ok := r.(models.MetricHistoryRepository)
+		if !ok {
+			log.Fatal().Msg("retention policy is set, but metrics history is not kept")
 		}
-		httpCtrlOpts = append(httpCtrlOpts, httpController.WithTrustedSubNet(subnet))
-		grpcCtrlOpts = append(grpcCtrlOpts, grpcController.WithTrustedSubNet(subnet))
+		serverOpts = append(serverOpts, server.WithRetention(services.NewRetentionService(h, config.Config.Retention, config.Config.RetentionInterval)))
 	}
 
-	metricService := services.NewMetricService(r, postUpdateFn)
+	if config.Config.EvictStale && config.Config.StaleAfter() > 0 {
+		serverOpts = append(serverOpts, server.WithExpiry(services.NewExpiryService(r, config.Config.StaleAfter(), config.Config.ReportInterval)))
+	}
+
+	if len(config.Config.Alerting.Rules) > 0 {
+		notifiers := []alerting.Notifier{alerting.NewLogNotifier()}
+		if len(config.Config.Alerting.WebhookURL) > 0 {
+			notifiers = append(notifiers, alerting.NewWebhookNotifier(config.Config.Alerting.WebhookURL))
+		}
+		var e *alerting.Engine
+		e, err = alerting.NewEngine(config.Config.Alerting.Rules,
+			alerting.WithNotifiers(notifiers...),
+			alerting.WithEvaluationInterval(config.Config.Alerting.EvaluationInterval),
+			alerting.WithStaleAfter(config.Config.StaleAfter()),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not configure alerting")
+		}
+		metricService.AddListener(e.Observe)
+		serverOpts = append(serverOpts, server.WithAlerting(e))
+		httpCtrlOpts = append(httpCtrlOpts, httpController.WithAlerting(e))
+	}
 
 	serverOpts = append(serverOpts, server.AddController(httpController.NewController(metricService, httpCtrlOpts...)))
 
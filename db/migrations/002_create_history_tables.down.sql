@@ -0,0 +1,2 @@
+DROP TABLE gauge_history;
+DROP TABLE counter_history;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE gauge_history (
+    name VARCHAR NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    ts TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX gauge_history_name_ts_idx ON gauge_history (name, ts);
+
+CREATE TABLE counter_history (
+    name VARCHAR NOT NULL,
+    delta BIGINT NOT NULL,
+    value BIGINT NOT NULL,
+    ts TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX counter_history_name_ts_idx ON counter_history (name, ts);
\ No newline at end of file
@@ -0,0 +1,19 @@
+DROP INDEX counter_history_name_labels_ts_idx;
+DELETE FROM counter_history WHERE labels <> '{}';
+ALTER TABLE counter_history DROP COLUMN labels;
+CREATE INDEX counter_history_name_ts_idx ON counter_history (name, ts);
+
+DROP INDEX gauge_history_name_labels_ts_idx;
+DELETE FROM gauge_history WHERE labels <> '{}';
+ALTER TABLE gauge_history DROP COLUMN labels;
+CREATE INDEX gauge_history_name_ts_idx ON gauge_history (name, ts);
+
+DELETE FROM counters WHERE labels <> '{}';
+ALTER TABLE counters DROP CONSTRAINT counters_pkey;
+ALTER TABLE counters DROP COLUMN labels;
+ALTER TABLE counters ADD PRIMARY KEY (name);
+
+DELETE FROM gauges WHERE labels <> '{}';
+ALTER TABLE gauges DROP CONSTRAINT gauges_pkey;
+ALTER TABLE gauges DROP COLUMN labels;
+ALTER TABLE gauges ADD PRIMARY KEY (name);
\ No newline at end of file
@@ -0,0 +1,15 @@
+ALTER TABLE gauges ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE gauges DROP CONSTRAINT gauges_pkey;
+ALTER TABLE gauges ADD PRIMARY KEY (name, labels);
+
+ALTER TABLE counters ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE counters DROP CONSTRAINT counters_pkey;
+ALTER TABLE counters ADD PRIMARY KEY (name, labels);
+
+ALTER TABLE gauge_history ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+DROP INDEX gauge_history_name_ts_idx;
+CREATE INDEX gauge_history_name_labels_ts_idx ON gauge_history (name, labels, ts);
+
+ALTER TABLE counter_history ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+DROP INDEX counter_history_name_ts_idx;
+CREATE INDEX counter_history_name_labels_ts_idx ON counter_history (name, labels, ts);
\ No newline at end of file
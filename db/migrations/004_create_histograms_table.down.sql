@@ -0,0 +1 @@
+DROP TABLE histograms;
\ No newline at end of file
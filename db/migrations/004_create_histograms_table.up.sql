@@ -0,0 +1,6 @@
+CREATE TABLE histograms (
+    name VARCHAR NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    value JSONB NOT NULL,
+    PRIMARY KEY (name, labels)
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE gauges DROP COLUMN updated_at;
+ALTER TABLE counters DROP COLUMN updated_at;
+ALTER TABLE histograms DROP COLUMN updated_at;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE batches;
\ No newline at end of file
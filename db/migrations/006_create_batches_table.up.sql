@@ -0,0 +1,6 @@
+CREATE TABLE batches (
+    id VARCHAR NOT NULL PRIMARY KEY,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX batches_applied_at_idx ON batches (applied_at);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE gauge_history DROP COLUMN samples;
\ No newline at end of file
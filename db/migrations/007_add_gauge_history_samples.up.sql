@@ -0,0 +1 @@
+ALTER TABLE gauge_history ADD COLUMN samples INTEGER NOT NULL DEFAULT 1;
\ No newline at end of file
@@ -23,6 +23,7 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetricServiceClient interface {
 	Update(ctx context.Context, opts ...grpc.CallOption) (MetricService_UpdateClient, error)
+	UpdateStream(ctx context.Context, opts ...grpc.CallOption) (MetricService_UpdateStreamClient, error)
 	DBStatus(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Response, error)
 }
 
@@ -68,6 +69,37 @@ func (x *metricServiceUpdateClient) CloseAndRecv() (*Response, error) {
 	return m, nil
 }
 
+func (c *metricServiceClient) UpdateStream(ctx context.Context, opts ...grpc.CallOption) (MetricService_UpdateStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetricService_ServiceDesc.Streams[1], "/com.github.tony_spark.metrico.MetricService/UpdateStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricServiceUpdateStreamClient{stream}
+	return x, nil
+}
+
+type MetricService_UpdateStreamClient interface {
+	Send(*MetricUpdate) error
+	Recv() (*Ack, error)
+	grpc.ClientStream
+}
+
+type metricServiceUpdateStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricServiceUpdateStreamClient) Send(m *MetricUpdate) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *metricServiceUpdateStreamClient) Recv() (*Ack, error) {
+	m := new(Ack)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *metricServiceClient) DBStatus(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Response, error) {
 	out := new(Response)
 	err := c.cc.Invoke(ctx, "/com.github.tony_spark.metrico.MetricService/DBStatus", in, out, opts...)
@@ -82,6 +114,7 @@ func (c *metricServiceClient) DBStatus(ctx context.Context, in *Empty, opts ...g
 // for forward compatibility
 type MetricServiceServer interface {
 	Update(MetricService_UpdateServer) error
+	UpdateStream(MetricService_UpdateStreamServer) error
 	DBStatus(context.Context, *Empty) (*Response, error)
 	mustEmbedUnimplementedMetricServiceServer()
 }
@@ -93,6 +126,9 @@ type UnimplementedMetricServiceServer struct {
 func (UnimplementedMetricServiceServer) Update(MetricService_UpdateServer) error {
 	return status.Errorf(codes.Unimplemented, "method Update not implemented")
 }
+func (UnimplementedMetricServiceServer) UpdateStream(MetricService_UpdateStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method UpdateStream not implemented")
+}
 func (UnimplementedMetricServiceServer) DBStatus(context.Context, *Empty) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DBStatus not implemented")
 }
@@ -135,6 +171,32 @@ func (x *metricServiceUpdateServer) Recv() (*Metric, error) {
 	return m, nil
 }
 
+func _MetricService_UpdateStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricServiceServer).UpdateStream(&metricServiceUpdateStreamServer{stream})
+}
+
+type MetricService_UpdateStreamServer interface {
+	Send(*Ack) error
+	Recv() (*MetricUpdate, error)
+	grpc.ServerStream
+}
+
+type metricServiceUpdateStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricServiceUpdateStreamServer) Send(m *Ack) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *metricServiceUpdateStreamServer) Recv() (*MetricUpdate, error) {
+	m := new(MetricUpdate)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _MetricService_DBStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Empty)
 	if err := dec(in); err != nil {
@@ -171,6 +233,12 @@ var MetricService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _MetricService_Update_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "UpdateStream",
+			Handler:       _MetricService_UpdateStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "proto/metrico.proto",
 }
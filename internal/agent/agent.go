@@ -13,6 +13,7 @@ import (
 
 	"github.com/tony-spark/metrico/internal/agent/metrics"
 	"github.com/tony-spark/metrico/internal/agent/transports"
+	"github.com/tony-spark/metrico/internal/model"
 )
 
 // MetricsAgent represents agent application
@@ -21,6 +22,8 @@ type MetricsAgent struct {
 	reportInterval time.Duration
 	collectors     []metrics.MetricCollector
 	transport      transports.Transport
+	labels         model.Labels
+	sendLatency    *metrics.HistogramMetric
 	mu             *sync.Mutex
 	cond           *sync.Cond
 	sending        bool
@@ -39,7 +42,7 @@ func New(options ...Option) MetricsAgent {
 			metrics.NewRandomMetricCollector(),
 			metrics.NewPsUtilMetricsCollector(),
 		},
-		transport: http.NewTransport("http://127.0.0.1:8080"),
+		transport: transports.NewDeltaTracking(http.NewTransport("http://127.0.0.1:8080")),
 	}
 	a.mu = new(sync.Mutex)
 	a.cond = sync.NewCond(a.mu)
@@ -48,10 +51,15 @@ func New(options ...Option) MetricsAgent {
 		opt(&a)
 	}
 
+	if a.sendLatency != nil {
+		a.collectors = append(a.collectors, metrics.NewHistogramMetricCollector(a.sendLatency))
+	}
+
 	return a
 }
 
-// WithTransport configures agent to use given transport to send metrics
+// WithTransport configures agent to use given transport to send metrics.
+// Counters and histograms are passed to transport as cumulative values, see transports.DeltaTracking
 func WithTransport(transport transports.Transport) Option {
 	return func(a *MetricsAgent) {
 		a.transport = transport
@@ -79,6 +87,20 @@ func WithCollectors(cs ...metrics.MetricCollector) Option {
 	}
 }
 
+// WithLabels configures agent to attach given labels to every metric sent
+func WithLabels(labels map[string]string) Option {
+	return func(a *MetricsAgent) {
+		a.labels = model.Labels(labels).Copy()
+	}
+}
+
+// WithSendLatency configures agent to report histogram of metrics sending latency (in seconds) with given bucket bounds
+func WithSendLatency(bounds []float64) Option {
+	return func(a *MetricsAgent) {
+		a.sendLatency = metrics.NewHistogramMetric("SendLatency", bounds)
+	}
+}
+
 func (a MetricsAgent) poll() {
 	log.Trace().Msg("poll")
 	for _, collector := range a.collectors {
@@ -113,7 +135,12 @@ func (a MetricsAgent) report() {
 			default:
 			}
 
-			err := a.transport.SendMetricsWithContext(timeoutCtx, c.Metrics())
+			start := time.Now()
+			ctx := transports.WithBatchID(timeoutCtx, transports.NewBatchID())
+			err := a.transport.SendMetricsWithContext(ctx, a.labeled(c.Metrics()))
+			if a.sendLatency != nil {
+				a.sendLatency.Observe(time.Since(start).Seconds())
+			}
 			if err != nil {
 				log.Error().Err(err).Msg("could not send metrics")
 				var ne net.Error
@@ -131,6 +158,18 @@ func (a MetricsAgent) report() {
 	a.cond.Broadcast()
 }
 
+// labeled attaches agent labels to metrics
+func (a MetricsAgent) labeled(ms []model.Metric) []model.Metric {
+	if len(a.labels) == 0 {
+		return ms
+	}
+	lms := make([]model.Metric, 0, len(ms))
+	for _, m := range ms {
+		lms = append(lms, metrics.NewLabeledMetric(m, a.labels))
+	}
+	return lms
+}
+
 // Run starts to collect metrics and send it via transport
 //
 // Note that Run blocks until given context is cancelled or Stop called
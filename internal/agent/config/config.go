@@ -6,11 +6,15 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/rs/zerolog/log"
+	"github.com/tony-spark/metrico/internal/agent/transports"
 	configUtil "github.com/tony-spark/metrico/internal/config"
+	"github.com/tony-spark/metrico/internal/model"
 )
 
 var (
@@ -18,17 +22,38 @@ var (
 		Address:        "127.0.0.1:8080",
 		ReportInterval: 10 * time.Second,
 		PollInterval:   2 * time.Second,
+		LatencyBuckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		OutboxSize:     10 << 20,
+		Timeout:        5 * time.Second,
+		Retry:          transports.DefaultRetryPolicy,
+		FanOutMode:     transports.ModeAll,
+		Failover:       transports.DefaultFailoverPolicy,
 	}
 )
 
 type config struct {
-	Address        string        `env:"ADDRESS" json:"address,omitempty"`
-	GrpcAddress    string        `env:"GRPC_ADRESS" json:"grpc_address,omitempty"`
-	ReportInterval time.Duration `env:"REPORT_INTERVAL" json:"report_interval,omitempty"`
-	PollInterval   time.Duration `env:"POLL_INTERVAL" json:"poll_interval,omitempty"`
-	Key            string        `env:"KEY" json:"key,omitempty"`
-	Profile        bool          `env:"PROFILING" json:"profile,omitempty"`
-	PublicKeyFile  string        `env:"CRYPTO_KEY" json:"crypto_key,omitempty"`
+	Address        string                    `env:"ADDRESS" json:"address,omitempty"`
+	GrpcAddress    string                    `env:"GRPC_ADRESS" json:"grpc_address,omitempty"`
+	ReportInterval time.Duration             `env:"REPORT_INTERVAL" json:"report_interval,omitempty"`
+	PollInterval   time.Duration             `env:"POLL_INTERVAL" json:"poll_interval,omitempty"`
+	Key            string                    `env:"KEY" json:"key,omitempty"`
+	Profile        bool                      `env:"PROFILING" json:"profile,omitempty"`
+	PublicKeyFile  string                    `env:"CRYPTO_KEY" json:"crypto_key,omitempty"`
+	Labels         map[string]string         `env:"LABELS" json:"labels,omitempty"`
+	ID             string                    `env:"AGENT_ID" json:"agent_id,omitempty"`
+	LatencyBuckets []float64                 `env:"LATENCY_BUCKETS" json:"latency_buckets,omitempty"`
+	OutboxDir      string                    `env:"OUTBOX_DIR" json:"outbox_dir,omitempty"`   // directory to keep unsent metrics in (not kept if empty)
+	OutboxSize     int64                     `env:"OUTBOX_SIZE" json:"outbox_size,omitempty"` // max size of unsent metrics on disk (in bytes)
+	Timeout        time.Duration             `env:"TIMEOUT" json:"timeout,omitempty"`         // timeout of a single sending attempt
+	Retry          transports.RetryPolicy    `json:"retry,omitempty"`
+	Destinations   []string                  `env:"DESTINATIONS" json:"destinations,omitempty"` // servers to send metrics to, e.g. http://host:8080 or grpc://host:3200
+	FanOutMode     string                    `env:"FANOUT_MODE" json:"fanout_mode,omitempty"`   // whether sending to every destination must succeed ("all") or any ("best-effort")
+	Standby        string                    `env:"STANDBY" json:"standby,omitempty"`           // server to send metrics to while destination is down, e.g. http://host:8080
+	Failover       transports.FailoverPolicy `json:"failover,omitempty"`
+	TLSCAFile      string                    `env:"TLS_CA" json:"tls_ca,omitempty"`     // CA bundle to verify server certificate (PEM), system CAs are used if empty
+	TLSCertFile    string                    `env:"TLS_CERT" json:"tls_cert,omitempty"` // client certificate for mutual TLS (PEM)
+	TLSKeyFile     string                    `env:"TLS_KEY" json:"tls_key,omitempty"`   // private key of client certificate (PEM)
+	Protobuf       bool                      `env:"PROTOBUF" json:"protobuf,omitempty"` // whether to send batches to HTTP servers in protobuf instead of JSON
 }
 
 func Parse() error {
@@ -57,6 +82,42 @@ func Parse() error {
 	flag.DurationVar(&Config.PollInterval, "p", Config.PollInterval, "poll interval")
 	flag.StringVar(&Config.Key, "k", Config.Key, "hash key")
 	flag.BoolVar(&Config.Profile, "prof", Config.Profile, "turn on profiling")
+	flag.StringVar(&Config.ID, "id", Config.ID, "agent identifier reported to server (host name by default)")
+	flag.Func("lb", "comma separated bucket bounds of send latency histogram (in seconds)", func(s string) error {
+		bounds, err := parseBounds(s)
+		if err != nil {
+			return err
+		}
+		Config.LatencyBuckets = bounds
+		return nil
+	})
+	flag.Func("l", "label attached to every metric in key=value form (may be repeated)", func(s string) error {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || len(key) == 0 {
+			return fmt.Errorf("label %s is not in key=value form", s)
+		}
+		if Config.Labels == nil {
+			Config.Labels = make(map[string]string)
+		}
+		Config.Labels[key] = value
+		return nil
+	})
+	flag.StringVar(&Config.OutboxDir, "outbox", Config.OutboxDir, "directory to keep metrics which could not be sent in")
+	flag.Int64Var(&Config.OutboxSize, "outbox-size", Config.OutboxSize, "max size of outbox directory (in bytes)")
+	flag.DurationVar(&Config.Timeout, "timeout", Config.Timeout, "timeout of a single sending attempt")
+	flag.IntVar(&Config.Retry.MaxAttempts, "retries", Config.Retry.MaxAttempts, "max sending attempts (1 to disable retries)")
+	flag.Func("dest", "server to send metrics to, e.g. http://host:8080 or grpc://host:3200 (may be repeated, overrides -a and -g)", func(s string) error {
+		Config.Destinations = append(Config.Destinations, s)
+		return nil
+	})
+	flag.StringVar(&Config.FanOutMode, "fanout", Config.FanOutMode, "fan-out mode for several destinations: all or best-effort")
+	flag.StringVar(&Config.Standby, "standby", Config.Standby, "standby server to send metrics to while destination is down, e.g. http://host:8080")
+	flag.IntVar(&Config.Failover.Threshold, "failover-threshold", Config.Failover.Threshold, "consecutive failures of destination to switch to standby")
+	flag.DurationVar(&Config.Failover.ProbeInterval, "probe-interval", Config.Failover.ProbeInterval, "how often destination is probed while standby is used")
+	flag.StringVar(&Config.TLSCAFile, "tls-ca", Config.TLSCAFile, "CA bundle to verify server certificate (PEM)")
+	flag.StringVar(&Config.TLSCertFile, "tls-cert", Config.TLSCertFile, "client certificate for mutual TLS (PEM)")
+	flag.StringVar(&Config.TLSKeyFile, "tls-key", Config.TLSKeyFile, "private key of client certificate (PEM)")
+	flag.BoolVar(&Config.Protobuf, "protobuf", Config.Protobuf, "send batches to HTTP servers in protobuf instead of JSON")
 	flag.StringVar(&configFile, "config", "", "config file")
 	flag.StringVar(&configFile, "c", "", "shortcut to --config")
 	flag.Parse()
@@ -66,10 +127,68 @@ func Parse() error {
 		return fmt.Errorf("could not parse config: %w", err)
 	}
 
+	if len(Config.Standby) > 0 && len(Config.DestinationURLs()) > 1 {
+		return fmt.Errorf("standby is supported for a single destination only")
+	}
+
+	if err = Config.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid retry policy: %w", err)
+	}
+
+	if err = model.NewHistogram(Config.LatencyBuckets).Validate(); err != nil {
+		return fmt.Errorf("invalid latency buckets: %w", err)
+	}
+
+	if len(Config.ID) == 0 {
+		Config.ID, err = os.Hostname()
+		if err != nil {
+			return fmt.Errorf("could not get host name for agent identifier: %w", err)
+		}
+	}
+
 	log.Info().Msgf("Agent config parsed: %+v", Config)
 	return nil
 }
 
+// DestinationURLs returns servers to send metrics to. If destinations are not set, it is either gRPC address
+// (if set) or HTTP address
+func (c config) DestinationURLs() []string {
+	if len(c.Destinations) > 0 {
+		return c.Destinations
+	}
+	if len(c.GrpcAddress) > 0 {
+		if len(c.Address) > 0 {
+			log.Warn().Msgf("grpc address is set, metrics are not sent to %s (set destinations to send to both)", c.Address)
+		}
+		return []string{"grpc://" + c.GrpcAddress}
+	}
+	if len(c.Address) > 0 {
+		scheme := "http://"
+		if c.UseTLS() {
+			scheme = "https://"
+		}
+		return []string{scheme + strings.Trim(c.Address, "\"")}
+	}
+	return nil
+}
+
+// UseTLS returns whether TLS is configured for connections to servers
+func (c config) UseTLS() bool {
+	return len(c.TLSCAFile) > 0 || len(c.TLSCertFile) > 0
+}
+
+func parseBounds(s string) ([]float64, error) {
+	var bounds []float64
+	for _, b := range strings.Split(s, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse bucket bound: %w", err)
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
 func (c *config) UnmarshalJSON(b []byte) error {
 	type configAlias config
 
@@ -77,6 +196,7 @@ func (c *config) UnmarshalJSON(b []byte) error {
 		*configAlias
 		PollInterval   string `json:"poll_interval,omitempty"`
 		ReportInterval string `json:"report_interval,omitempty"`
+		Timeout        string `json:"timeout,omitempty"`
 	}{
 		configAlias: (*configAlias)(c),
 	}
@@ -100,5 +220,12 @@ func (c *config) UnmarshalJSON(b []byte) error {
 		}
 	}
 
+	if len(aliasValue.Timeout) > 0 {
+		c.Timeout, err = time.ParseDuration(aliasValue.Timeout)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
 	return nil
 }
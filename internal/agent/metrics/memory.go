@@ -132,6 +132,10 @@ func (m MemoryMetric) Val() interface{} {
 	return m.valueFunction(m.collector.memStats)
 }
 
+func (m MemoryMetric) Labels() model.Labels {
+	return nil
+}
+
 func (p PollMetric) String() string {
 	return fmt.Sprint(p.collector.refreshCount)
 }
@@ -148,6 +152,10 @@ func (p PollMetric) Val() interface{} {
 	return p.collector.refreshCount
 }
 
+func (p PollMetric) Labels() model.Labels {
+	return nil
+}
+
 func (c *MemoryMetricCollector) Update() {
 	log.Trace().Msg("Reading memory statistics")
 	runtime.ReadMemStats(c.memStats)
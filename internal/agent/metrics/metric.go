@@ -3,6 +3,7 @@ package metrics
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/tony-spark/metrico/internal/model"
 )
@@ -38,6 +39,10 @@ func (g GaugeMetric) Val() interface{} {
 	return g.value
 }
 
+func (g GaugeMetric) Labels() model.Labels {
+	return nil
+}
+
 func (c CounterMetric) String() string {
 	return fmt.Sprint(c.value)
 }
@@ -54,6 +59,10 @@ func (c CounterMetric) Val() interface{} {
 	return c.value
 }
 
+func (c CounterMetric) Labels() model.Labels {
+	return nil
+}
+
 func NewGaugeMetric(name string, value float64) *GaugeMetric {
 	return &GaugeMetric{name, value}
 }
@@ -61,3 +70,98 @@ func NewGaugeMetric(name string, value float64) *GaugeMetric {
 func NewCounterMetric(name string, value int64) *CounterMetric {
 	return &CounterMetric{name, value}
 }
+
+// LabeledMetric is a metric with labels added
+type LabeledMetric struct {
+	model.Metric
+	labels model.Labels
+}
+
+// NewLabeledMetric returns metric with given labels added to metric's own labels (given labels take precedence)
+func NewLabeledMetric(m model.Metric, labels model.Labels) LabeledMetric {
+	ls := m.Labels().Copy()
+	if ls == nil {
+		ls = make(model.Labels, len(labels))
+	}
+	for k, v := range labels {
+		ls[k] = v
+	}
+	return LabeledMetric{
+		Metric: m,
+		labels: ls,
+	}
+}
+
+func (l LabeledMetric) Labels() model.Labels {
+	return l.labels
+}
+
+// HistogramMetric is a histogram accumulating observations, safe for concurrent use
+type HistogramMetric struct {
+	name  string
+	mu    *sync.Mutex
+	value *model.Histogram
+}
+
+// NewHistogramMetric creates empty histogram with given bucket bounds (must be ascending)
+func NewHistogramMetric(name string, bounds []float64) *HistogramMetric {
+	h := model.NewHistogram(bounds)
+	return &HistogramMetric{
+		name:  name,
+		mu:    new(sync.Mutex),
+		value: &h,
+	}
+}
+
+// Observe adds observation to histogram
+func (h HistogramMetric) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.value.Observe(v)
+}
+
+func (h HistogramMetric) String() string {
+	return h.Val().(model.Histogram).String()
+}
+
+func (h HistogramMetric) ID() string {
+	return h.name
+}
+
+func (h HistogramMetric) Type() string {
+	return model.HISTOGRAM
+}
+
+// Val returns snapshot of histogram
+func (h HistogramMetric) Val() interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.value.Copy()
+}
+
+func (h HistogramMetric) Labels() model.Labels {
+	return nil
+}
+
+// HistogramMetricCollector provides histograms observed elsewhere (e.g. by agent itself)
+type HistogramMetricCollector struct {
+	metrics []model.Metric
+}
+
+func NewHistogramMetricCollector(hs ...*HistogramMetric) *HistogramMetricCollector {
+	c := &HistogramMetricCollector{}
+	for _, h := range hs {
+		c.metrics = append(c.metrics, *h)
+	}
+	return c
+}
+
+func (c *HistogramMetricCollector) Metrics() []model.Metric {
+	return c.metrics
+}
+
+// Update does nothing, histograms are updated on observation
+func (c *HistogramMetricCollector) Update() {
+}
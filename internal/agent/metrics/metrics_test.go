@@ -4,6 +4,9 @@ import (
 	"testing"
 
 	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tony-spark/metrico/internal/model"
 )
 
 func BenchmarkAllCollectors(b *testing.B) {
@@ -28,3 +31,22 @@ func BenchmarkAllCollectors(b *testing.B) {
 		}
 	})
 }
+
+func TestLabeledMetric(t *testing.T) {
+	m := NewLabeledMetric(NewLabeledMetric(NewGaugeMetric("test", 1.5), model.Labels{"host": "a", "dc": "x"}), model.Labels{"host": "b"})
+	assert.Equal(t, "test", m.ID())
+	assert.Equal(t, model.GAUGE, m.Type())
+	assert.Equal(t, 1.5, m.Val())
+	assert.Equal(t, model.Labels{"host": "b", "dc": "x"}, m.Labels())
+}
+
+func TestHistogramMetric(t *testing.T) {
+	h := NewHistogramMetric("test", []float64{1})
+	c := NewHistogramMetricCollector(h)
+	h.Observe(0.5)
+	h.Observe(3)
+	ms := c.Metrics()
+	assert.Len(t, ms, 1)
+	assert.Equal(t, model.HISTOGRAM, ms[0].Type())
+	assert.Equal(t, model.Histogram{Bounds: []float64{1}, Counts: []int64{1, 1}, Count: 2, Sum: 3.5}, ms[0].Val())
+}
@@ -100,3 +100,7 @@ func (p PsUtilMetric) Type() string {
 func (p PsUtilMetric) Val() interface{} {
 	return p.valueFn()
 }
+
+func (p PsUtilMetric) Labels() model.Labels {
+	return nil
+}
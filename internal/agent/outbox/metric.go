@@ -0,0 +1,91 @@
+package outbox
+
+import (
+	"fmt"
+
+	"github.com/tony-spark/metrico/internal/dto"
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// metric is a model.Metric read from outbox
+type metric struct {
+	d dto.Metric
+}
+
+func (m metric) String() string {
+	return fmt.Sprint(m.Val())
+}
+
+func (m metric) ID() string {
+	return m.d.ID
+}
+
+func (m metric) Type() string {
+	return m.d.MType
+}
+
+func (m metric) Val() interface{} {
+	switch m.d.MType {
+	case model.GAUGE:
+		return *m.d.Value
+	case model.COUNTER:
+		return *m.d.Delta
+	case model.HISTOGRAM:
+		return m.d.Histogram.Copy()
+	}
+	return nil
+}
+
+func (m metric) Labels() model.Labels {
+	return m.d.Labels
+}
+
+func toDTOs(mx []model.Metric) []dto.Metric {
+	ds := make([]dto.Metric, 0, len(mx))
+	for _, m := range mx {
+		ds = append(ds, *dto.NewMetric(m))
+	}
+	return ds
+}
+
+func fromDTOs(ds []dto.Metric) []model.Metric {
+	mx := make([]model.Metric, 0, len(ds))
+	for _, d := range ds {
+		if !d.HasValue() {
+			continue
+		}
+		mx = append(mx, metric{d: d})
+	}
+	return mx
+}
+
+// merge combines metrics of batches: counter increments and histogram observations are summed up, the last gauge value
+// is kept
+func merge(batches []dto.Batch) []dto.Metric {
+	var merged []dto.Metric
+	index := make(map[string]int)
+	for _, b := range batches {
+		for _, d := range b.Metrics {
+			if !d.HasValue() {
+				continue
+			}
+			key := d.MType + ":" + d.ID + d.Labels.String()
+			i, ok := index[key]
+			if !ok {
+				index[key] = len(merged)
+				merged = append(merged, d)
+				continue
+			}
+			switch d.MType {
+			case model.COUNTER:
+				sum := *merged[i].Delta + *d.Delta
+				d.Delta = &sum
+			case model.HISTOGRAM:
+				sum := merged[i].Histogram.Add(*d.Histogram)
+				d.Histogram = &sum
+			}
+			merged[i] = d
+		}
+	}
+	return merged
+}
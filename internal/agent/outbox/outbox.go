@@ -0,0 +1,381 @@
+// Package outbox contains write-ahead outbox of agent, which keeps batches of metrics that could not be sent on disk
+// and replays them once server is reachable again
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tony-spark/metrico/internal/agent/transports"
+	"github.com/tony-spark/metrico/internal/dto"
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+const (
+	defaultMaxSize = 10 << 20
+	batchExt       = ".json"
+	rejectedExt    = ".rejected"
+)
+
+var ErrFull = errors.New("outbox is full")
+
+// Outbox is a transport which saves batches that could not be sent by underlying transport (with retryable error, see
+// transports.IsRetryable) to a directory. Saved batches are replayed in order (with their batch identifiers) before
+// sending new ones. Saved batch rejected by server on replay is moved aside to a file with .rejected extension, so that
+// it does not block replay of later ones.
+//
+// When size of saved batches exceeds limit, batches which were never sent are compacted into a single batch with a new
+// identifier: counter increments and histogram observations are summed up, only the last gauge values are kept.
+// Batches which server may have applied (sending them failed with unknown outcome) are kept with their identifiers, so
+// that server does not apply them twice, ErrFull is returned if they take too much space
+type Outbox struct {
+	dir     string
+	next    transports.Transport
+	maxSize int64
+	mu      *sync.Mutex
+	size    int64
+	seq     uint64
+}
+
+// entry is a batch saved to file
+type entry struct {
+	dto.Batch
+	Unsent bool `json:"unsent,omitempty"` // batch was never sent, so that it may be merged with others
+}
+
+// Option represents option function for outbox configuration
+type Option func(o *Outbox)
+
+// WithMaxSize configures outbox to keep at most given number of bytes on disk
+func WithMaxSize(size int64) Option {
+	return func(o *Outbox) {
+		if size > 0 {
+			o.maxSize = size
+		}
+	}
+}
+
+// New creates outbox keeping batches in dir (created if not exists) and sending metrics via next transport.
+// Batches left in dir by previous run are replayed too
+func New(dir string, next transports.Transport, options ...Option) (*Outbox, error) {
+	o := &Outbox{
+		dir:     dir,
+		next:    next,
+		maxSize: defaultMaxSize,
+		mu:      new(sync.Mutex),
+	}
+	for _, opt := range options {
+		opt(o)
+	}
+
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("could not create outbox directory: %w", err)
+	}
+	files, err := o.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		o.size += f.size
+		o.seq = f.seq
+	}
+	if len(files) > 0 {
+		log.Info().Msgf("outbox has %d unsent batches", len(files))
+	}
+
+	return o, nil
+}
+
+func (o *Outbox) SendMetric(metric model.Metric) error {
+	return o.SendMetrics([]model.Metric{metric})
+}
+
+func (o *Outbox) SendMetrics(mx []model.Metric) error {
+	return o.SendMetricsWithContext(context.Background(), mx)
+}
+
+// SendMetricsWithContext replays saved batches and sends metrics. If any of it fails with retryable error, metrics are
+// saved to be sent later, and error is returned only if they could not be saved. If only some of metrics are not sent
+// (see transports.PartialError), only these are saved, with a new batch identifier. If server may have saved some of
+// them (they are not acknowledged), the whole batch is saved with its identifier instead, so that server recognizes
+// metrics it has applied. Metrics rejected by server are not saved, error is returned
+func (o *Outbox) SendMetricsWithContext(ctx context.Context, mx []model.Metric) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	id := transports.BatchID(ctx)
+	if len(id) == 0 {
+		id = transports.NewBatchID()
+		ctx = transports.WithBatchID(ctx, id)
+	}
+
+	e := entry{Unsent: true}
+	err := o.flush(ctx)
+	if err == nil {
+		e.Unsent = false
+		err = o.next.SendMetricsWithContext(ctx, mx)
+		if err == nil {
+			return nil
+		}
+		if !transports.IsRetryable(err) {
+			return err
+		}
+		var pe *transports.PartialError
+		if errors.As(err, &pe) && len(pe.Unknown) == 0 {
+			// metrics sent are not saved, failed ones are saved as a new batch, as server may have applied part of
+			// the batch under its identifier
+			mx = failedMetrics(mx, pe.Failed)
+			id = transports.NewBatchID()
+		}
+	}
+	log.Warn().Err(err).Msgf("could not send metrics, saving %d metrics to outbox", len(mx))
+
+	e.Batch = dto.Batch{ID: id, Metrics: toDTOs(mx)}
+	err = o.save(e)
+	if err != nil {
+		return fmt.Errorf("could not save metrics to outbox: %w", err)
+	}
+	return nil
+}
+
+// failedMetrics returns metrics with given indexes
+func failedMetrics(mx []model.Metric, failed []int) []model.Metric {
+	fx := make([]model.Metric, 0, len(failed))
+	for _, i := range failed {
+		if i >= 0 && i < len(mx) {
+			fx = append(fx, mx[i])
+		}
+	}
+	return fx
+}
+
+// Pending returns number of saved batches
+func (o *Outbox) Pending() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := o.files()
+	return len(files), err
+}
+
+// flush sends saved batches in order until first failure
+func (o *Outbox) flush(ctx context.Context) error {
+	files, err := o.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		e, err := readEntry(f.path)
+		if err != nil {
+			log.Error().Err(err).Msgf("dropping unreadable outbox batch %s", f.path)
+			o.remove(f)
+			continue
+		}
+		err = o.next.SendMetricsWithContext(transports.WithBatchID(ctx, e.ID), fromDTOs(e.Metrics))
+		if err != nil && !transports.IsRetryable(err) {
+			log.Error().Err(err).Msgf("outbox batch %s is rejected, moving it aside", e.ID)
+			o.reject(f)
+			continue
+		}
+		if err != nil {
+			if e.Unsent {
+				// server may have applied the batch, so that it must not be merged with others anymore
+				e.Unsent = false
+				if rewriteErr := o.rewrite(f, e); rewriteErr != nil {
+					log.Error().Err(rewriteErr).Msgf("could not mark outbox batch %s as sent", e.ID)
+				}
+			}
+			return fmt.Errorf("could not replay outbox batch %s: %w", e.ID, err)
+		}
+		log.Info().Msgf("replayed outbox batch %s", e.ID)
+		o.remove(f)
+	}
+	return nil
+}
+
+// save writes batch to a new file, saved batches are compacted first if size limit would be exceeded
+func (o *Outbox) save(e entry) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not marshal batch: %w", err)
+	}
+	if o.size+int64(len(bs)) > o.maxSize {
+		return o.compact(e)
+	}
+	return o.write(bs)
+}
+
+// compact replaces saved batches which were never sent (and given one, if it was not sent) with a single merged batch,
+// batches which may have been applied by server are kept as is
+func (o *Outbox) compact(e entry) error {
+	files, err := o.files()
+	if err != nil {
+		return err
+	}
+	var (
+		batches []dto.Batch
+		unsent  []file
+		freed   int64
+	)
+	for _, f := range files {
+		saved, err := readEntry(f.path)
+		if err != nil {
+			log.Error().Err(err).Msgf("dropping unreadable outbox batch %s", f.path)
+			o.remove(f)
+			continue
+		}
+		if saved.Unsent {
+			batches = append(batches, saved.Batch)
+			unsent = append(unsent, f)
+			freed += f.size
+		}
+	}
+
+	var sent []byte
+	if e.Unsent {
+		batches = append(batches, e.Batch)
+	} else if sent, err = json.Marshal(e); err != nil {
+		return fmt.Errorf("could not marshal batch: %w", err)
+	}
+	var merged []byte
+	if len(batches) > 0 {
+		merged, err = json.Marshal(entry{
+			Batch:  dto.Batch{ID: transports.NewBatchID(), Metrics: merge(batches)},
+			Unsent: true,
+		})
+		if err != nil {
+			return fmt.Errorf("could not marshal batch: %w", err)
+		}
+	}
+	if size := o.size - freed + int64(len(merged)+len(sent)); size > o.maxSize {
+		return fmt.Errorf("%w: %d bytes needed, batches which may have been applied by server are not compacted", ErrFull, size)
+	}
+
+	// merged batch is written before removing the old ones, so that metrics are not lost on crash
+	if len(merged) > 0 {
+		if err = o.write(merged); err != nil {
+			return err
+		}
+	}
+	for _, f := range unsent {
+		o.remove(f)
+	}
+	if len(sent) > 0 {
+		if err = o.write(sent); err != nil {
+			return err
+		}
+	}
+	log.Warn().Msgf("outbox size limit reached, %d batches compacted into one", len(batches))
+	return nil
+}
+
+func (o *Outbox) write(bs []byte) error {
+	o.seq++
+	path := filepath.Join(o.dir, fmt.Sprintf("%020d%s", o.seq, batchExt))
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, bs, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not write outbox batch: %w", err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("could not write outbox batch: %w", err)
+	}
+	o.size += int64(len(bs))
+	return nil
+}
+
+// rewrite replaces saved batch file
+func (o *Outbox) rewrite(f file, e entry) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not marshal batch: %w", err)
+	}
+	tmp := f.path + ".tmp"
+	if err = os.WriteFile(tmp, bs, 0o600); err != nil {
+		return fmt.Errorf("could not write outbox batch: %w", err)
+	}
+	if err = os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("could not write outbox batch: %w", err)
+	}
+	o.size += int64(len(bs)) - f.size
+	return nil
+}
+
+func (o *Outbox) remove(f file) {
+	err := os.Remove(f.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Msgf("could not remove outbox batch %s", f.path)
+		return
+	}
+	o.size -= f.size
+}
+
+// reject moves batch file aside to be inspected manually
+func (o *Outbox) reject(f file) {
+	err := os.Rename(f.path, strings.TrimSuffix(f.path, batchExt)+rejectedExt)
+	if err != nil {
+		log.Error().Err(err).Msgf("could not move rejected outbox batch %s aside", f.path)
+		o.remove(f)
+		return
+	}
+	o.size -= f.size
+}
+
+type file struct {
+	path string
+	seq  uint64
+	size int64
+}
+
+// files returns saved batches files in order they were saved
+func (o *Outbox) files() ([]file, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read outbox directory: %w", err)
+	}
+	var files []file
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, batchExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, batchExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("could not read outbox directory: %w", err)
+		}
+		files = append(files, file{
+			path: filepath.Join(o.dir, name),
+			seq:  seq,
+			size: info.Size(),
+		})
+	}
+	return files, nil
+}
+
+func readEntry(path string) (entry, error) {
+	var e entry
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return e, fmt.Errorf("could not read outbox batch: %w", err)
+	}
+	err = json.Unmarshal(bs, &e)
+	if err != nil {
+		return e, fmt.Errorf("could not parse outbox batch: %w", err)
+	}
+	return e, nil
+}
@@ -0,0 +1,238 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tony-spark/metrico/internal/agent/metrics"
+	"github.com/tony-spark/metrico/internal/agent/transports"
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+type sent struct {
+	id string
+	mx []model.Metric
+}
+
+// fakeTransport records sent batches, fails while down, times out after recording batch on timeout, rejects metrics
+// with given name
+type fakeTransport struct {
+	down    bool
+	timeout bool
+	reject  string
+	partial []int // indexes of metrics failing with retryable error once
+	unknown []int // indexes of partially failed metrics which may have been saved
+	sent    []sent
+}
+
+func (f *fakeTransport) SendMetric(metric model.Metric) error {
+	return f.SendMetrics([]model.Metric{metric})
+}
+
+func (f *fakeTransport) SendMetrics(mx []model.Metric) error {
+	return f.SendMetricsWithContext(context.Background(), mx)
+}
+
+func (f *fakeTransport) SendMetricsWithContext(ctx context.Context, mx []model.Metric) error {
+	if f.down {
+		return fmt.Errorf("%w: server is down", transports.ErrTemporary)
+	}
+	if len(f.partial) > 0 {
+		failed, unknown := f.partial, f.unknown
+		f.partial, f.unknown = nil, nil
+		return &transports.PartialError{Failed: failed, Unknown: unknown, Err: fmt.Errorf("%w: metrics not saved", transports.ErrTemporary)}
+	}
+	for _, m := range mx {
+		if m.ID() == f.reject {
+			return errors.New("bad request")
+		}
+	}
+	f.sent = append(f.sent, sent{id: transports.BatchID(ctx), mx: mx})
+	if f.timeout {
+		return fmt.Errorf("%w: response timeout", transports.ErrTemporary)
+	}
+	return nil
+}
+
+func send(t *testing.T, o *Outbox, id string, mx ...model.Metric) {
+	err := o.SendMetricsWithContext(transports.WithBatchID(context.Background(), id), mx)
+	require.NoError(t, err)
+}
+
+func TestOutbox(t *testing.T) {
+	t.Run("replay in order", func(t *testing.T) {
+		dir := t.TempDir()
+		next := &fakeTransport{down: true}
+		o, err := New(dir, next)
+		require.NoError(t, err)
+
+		send(t, o, "b1", metrics.NewCounterMetric("PollCount", 1))
+		send(t, o, "b2", metrics.NewGaugeMetric("RandomValue", 0.5))
+		pending, err := o.Pending()
+		require.NoError(t, err)
+		assert.Equal(t, 2, pending)
+
+		// batches are kept across restarts
+		o, err = New(dir, next)
+		require.NoError(t, err)
+		next.down = false
+		send(t, o, "b3", metrics.NewCounterMetric("PollCount", 1))
+
+		require.Len(t, next.sent, 3)
+		for i, id := range []string{"b1", "b2", "b3"} {
+			assert.Equal(t, id, next.sent[i].id)
+		}
+		require.Len(t, next.sent[1].mx, 1)
+		assert.Equal(t, "RandomValue", next.sent[1].mx[0].ID())
+		assert.Equal(t, 0.5, next.sent[1].mx[0].Val())
+		pending, err = o.Pending()
+		require.NoError(t, err)
+		assert.Equal(t, 0, pending)
+	})
+	t.Run("compaction", func(t *testing.T) {
+		next := &fakeTransport{down: true}
+		o, err := New(t.TempDir(), next, WithMaxSize(300))
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			send(t, o, "b", metrics.NewCounterMetric("PollCount", 2), metrics.NewGaugeMetric("RandomValue", float64(i)))
+		}
+		pending, err := o.Pending()
+		require.NoError(t, err)
+		assert.Less(t, pending, 5)
+		assert.LessOrEqual(t, o.size, int64(300))
+
+		next.down = false
+		require.NoError(t, o.SendMetrics(nil))
+		var counter int64
+		var gauge float64
+		for _, s := range next.sent {
+			for _, m := range s.mx {
+				switch m.Type() {
+				case model.COUNTER:
+					counter += m.Val().(int64)
+				case model.GAUGE:
+					gauge = m.Val().(float64)
+				}
+			}
+		}
+		assert.Equal(t, int64(10), counter, "counter increments are not lost")
+		assert.Equal(t, 4.0, gauge, "the last gauge value is sent last")
+	})
+	t.Run("batches applied by server are not compacted", func(t *testing.T) {
+		next := &fakeTransport{timeout: true}
+		o, err := New(t.TempDir(), next, WithMaxSize(300))
+		require.NoError(t, err)
+
+		send(t, o, "b1", metrics.NewCounterMetric("PollCount", 2))
+		next.timeout, next.down = false, true
+		for _, id := range []string{"b2", "b3", "b4", "b5"} {
+			send(t, o, id, metrics.NewCounterMetric("PollCount", 2))
+		}
+		pending, err := o.Pending()
+		require.NoError(t, err)
+		assert.Equal(t, 3, pending, "batches which were not sent should be compacted")
+		assert.LessOrEqual(t, o.size, int64(300))
+
+		next.down = false
+		require.NoError(t, o.SendMetrics(nil))
+		// server applies batch with the same identifier once
+		applied := make(map[string]bool)
+		var counter int64
+		for _, s := range next.sent {
+			if applied[s.id] {
+				continue
+			}
+			applied[s.id] = true
+			for _, m := range s.mx {
+				counter += m.Val().(int64)
+			}
+		}
+		assert.Equal(t, int64(10), counter)
+		assert.Equal(t, "b1", next.sent[1].id, "batch applied by server should be replayed with its identifier")
+	})
+	t.Run("batches applied by server take too much space", func(t *testing.T) {
+		next := &fakeTransport{timeout: true}
+		o, err := New(t.TempDir(), next, WithMaxSize(100))
+		require.NoError(t, err)
+
+		send(t, o, "b1", metrics.NewCounterMetric("PollCount", 2))
+		next.timeout, next.down = false, true
+		err = o.SendMetricsWithContext(transports.WithBatchID(context.Background(), "b2"),
+			[]model.Metric{metrics.NewCounterMetric("PollCount", 2)})
+		assert.ErrorIs(t, err, ErrFull)
+	})
+	t.Run("full", func(t *testing.T) {
+		o, err := New(t.TempDir(), &fakeTransport{down: true}, WithMaxSize(10))
+		require.NoError(t, err)
+		err = o.SendMetrics([]model.Metric{metrics.NewCounterMetric("PollCount", 1)})
+		assert.ErrorIs(t, err, ErrFull)
+	})
+	t.Run("rejected metrics are not saved", func(t *testing.T) {
+		next := &fakeTransport{reject: "Bad"}
+		o, err := New(t.TempDir(), next)
+		require.NoError(t, err)
+
+		err = o.SendMetrics([]model.Metric{metrics.NewGaugeMetric("Bad", 1)})
+		assert.Error(t, err)
+		pending, err := o.Pending()
+		require.NoError(t, err)
+		assert.Equal(t, 0, pending)
+	})
+	t.Run("rejected batch does not block replay", func(t *testing.T) {
+		dir := t.TempDir()
+		next := &fakeTransport{down: true, reject: "Bad"}
+		o, err := New(dir, next)
+		require.NoError(t, err)
+
+		send(t, o, "b1", metrics.NewGaugeMetric("Bad", 1))
+		send(t, o, "b2", metrics.NewCounterMetric("PollCount", 1))
+		next.down = false
+		send(t, o, "b3", metrics.NewCounterMetric("PollCount", 1))
+
+		require.Len(t, next.sent, 2)
+		assert.Equal(t, "b2", next.sent[0].id)
+		assert.Equal(t, "b3", next.sent[1].id)
+		pending, err := o.Pending()
+		require.NoError(t, err)
+		assert.Equal(t, 0, pending)
+		rejected, err := filepath.Glob(filepath.Join(dir, "*"+rejectedExt))
+		require.NoError(t, err)
+		assert.Len(t, rejected, 1)
+	})
+	t.Run("only failed metrics of partially sent batch are saved", func(t *testing.T) {
+		next := &fakeTransport{partial: []int{1}}
+		o, err := New(t.TempDir(), next)
+		require.NoError(t, err)
+
+		send(t, o, "b1", metrics.NewGaugeMetric("Alloc", 1), metrics.NewCounterMetric("PollCount", 1))
+		pending, err := o.Pending()
+		require.NoError(t, err)
+		assert.Equal(t, 1, pending)
+
+		send(t, o, "b2", metrics.NewGaugeMetric("Alloc", 2))
+		require.Len(t, next.sent, 2)
+		assert.NotEqual(t, "b1", next.sent[0].id, "failed metrics should be sent as a new batch")
+		require.Len(t, next.sent[0].mx, 1)
+		assert.Equal(t, "PollCount", next.sent[0].mx[0].ID())
+		assert.Equal(t, "b2", next.sent[1].id)
+	})
+	t.Run("partially sent batch with unacknowledged metrics is saved with its identifier", func(t *testing.T) {
+		next := &fakeTransport{partial: []int{0, 1}, unknown: []int{1}}
+		o, err := New(t.TempDir(), next)
+		require.NoError(t, err)
+
+		send(t, o, "b1", metrics.NewGaugeMetric("Alloc", 1), metrics.NewCounterMetric("PollCount", 1))
+		send(t, o, "b2", metrics.NewGaugeMetric("Alloc", 2))
+		require.Len(t, next.sent, 2)
+		assert.Equal(t, "b1", next.sent[0].id, "server may have applied metrics under batch identifier")
+		assert.Len(t, next.sent[0].mx, 2)
+		assert.Equal(t, "b2", next.sent[1].id)
+	})
+}
@@ -0,0 +1,30 @@
+package transports
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type batchIDKey struct{}
+
+// WithBatchID returns context carrying identifier of batch of metrics. Transports send it along with metrics,
+// so that server does not apply batch twice when it is resent with the same identifier
+func WithBatchID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, batchIDKey{}, id)
+}
+
+// BatchID returns batch identifier carried by context (empty if not set)
+func BatchID(ctx context.Context) string {
+	id, _ := ctx.Value(batchIDKey{}).(string)
+	return id
+}
+
+// NewBatchID returns random batch identifier
+func NewBatchID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,180 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// DeltaTracking is a transport which converts cumulative counter and histogram values collected by agent into
+// increments since values sent successfully via underlying transport, as server adds up received values.
+//
+// Sent values are considered acknowledged once sending starts, and are reverted if it fails (only values which are not
+// sent, if underlying transport returns PartialError), so that increments of failed sends are included into the next
+// ones. If it is unknown whether server applied the batch (e.g. response was not received in time, or some of metrics
+// are not acknowledged), it is kept and sent again with the same batch identifier before the next values, so that
+// server applies it once. Gauges are sent as is
+type DeltaTracking struct {
+	t          Transport
+	mu         *sync.Mutex
+	counters   map[string]int64
+	histograms map[string]model.Histogram
+	pending    *[]pendingBatch
+}
+
+// pendingBatch is a batch which outcome is unknown
+type pendingBatch struct {
+	id string
+	mx []model.Metric
+}
+
+func NewDeltaTracking(t Transport) DeltaTracking {
+	return DeltaTracking{
+		t:          t,
+		mu:         new(sync.Mutex),
+		counters:   make(map[string]int64),
+		histograms: make(map[string]model.Histogram),
+		pending:    new([]pendingBatch),
+	}
+}
+
+func (d DeltaTracking) SendMetric(metric model.Metric) error {
+	return d.SendMetricsWithContext(context.Background(), []model.Metric{metric})
+}
+
+func (d DeltaTracking) SendMetrics(mx []model.Metric) error {
+	return d.SendMetricsWithContext(context.Background(), mx)
+}
+
+func (d DeltaTracking) SendMetricsWithContext(ctx context.Context, mx []model.Metric) error {
+	if err := d.resend(ctx); err != nil {
+		// values are not acknowledged, so that they are sent next time
+		return err
+	}
+	id := BatchID(ctx)
+	if len(id) == 0 {
+		id = NewBatchID()
+		ctx = WithBatchID(ctx, id)
+	}
+	deltas := d.acknowledge(mx)
+	err := d.t.SendMetricsWithContext(ctx, deltas)
+	d.settle(id, deltas, err)
+	return err
+}
+
+// resend sends pending batches again with their identifiers until one of them fails
+func (d DeltaTracking) resend(ctx context.Context) error {
+	d.mu.Lock()
+	pending := *d.pending
+	*d.pending = nil
+	d.mu.Unlock()
+
+	for i, b := range pending {
+		err := d.t.SendMetricsWithContext(WithBatchID(ctx, b.id), b.mx)
+		d.settle(b.id, b.mx, err)
+		if err != nil {
+			d.mu.Lock()
+			*d.pending = append(*d.pending, pending[i+1:]...)
+			d.mu.Unlock()
+			return fmt.Errorf("could not resend batch %s: %w", b.id, err)
+		}
+	}
+	return nil
+}
+
+// settle reverts increments which are not sent, batch is kept pending if it is unknown whether it is sent
+func (d DeltaTracking) settle(id string, deltas []model.Metric, err error) {
+	var pe *PartialError
+	switch {
+	case err == nil:
+	case errors.As(err, &pe) && len(pe.Unknown) == 0:
+		failed := make([]model.Metric, 0, len(pe.Failed))
+		for _, i := range pe.Failed {
+			if i >= 0 && i < len(deltas) {
+				failed = append(failed, deltas[i])
+			}
+		}
+		d.revert(failed)
+	case outcomeUnknown(err):
+		d.mu.Lock()
+		*d.pending = append(*d.pending, pendingBatch{id: id, mx: deltas})
+		d.mu.Unlock()
+	default:
+		d.revert(deltas)
+	}
+}
+
+// outcomeUnknown returns whether server may have applied batch although sending failed with given error
+func outcomeUnknown(err error) bool {
+	return IsRetryable(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// deltaMetric is a metric with increment instead of cumulative value
+type deltaMetric struct {
+	model.Metric
+	key   string
+	value interface{}
+}
+
+func (m deltaMetric) Val() interface{} {
+	return m.value
+}
+
+func (m deltaMetric) String() string {
+	return fmt.Sprint(m.value)
+}
+
+// acknowledge returns metrics with increments since acknowledged values, which are replaced with current ones.
+// Counter or histogram which value decreased is considered reset and sent as is
+func (d DeltaTracking) acknowledge(mx []model.Metric) []model.Metric {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	deltas := make([]model.Metric, 0, len(mx))
+	for _, m := range mx {
+		key := m.Type() + ":" + m.ID() + m.Labels().String()
+		switch m.Type() {
+		case model.COUNTER:
+			v := m.Val().(int64)
+			delta := v
+			if acked := d.counters[key]; v >= acked {
+				delta = v - acked
+			}
+			d.counters[key] = v
+			deltas = append(deltas, deltaMetric{Metric: m, key: key, value: delta})
+		case model.HISTOGRAM:
+			v := m.Val().(model.Histogram)
+			delta := v.Copy()
+			if acked, ok := d.histograms[key]; ok {
+				delta = v.Sub(acked)
+			}
+			d.histograms[key] = v.Copy()
+			deltas = append(deltas, deltaMetric{Metric: m, key: key, value: delta})
+		default:
+			deltas = append(deltas, m)
+		}
+	}
+	return deltas
+}
+
+// revert takes back increments which were not sent
+func (d DeltaTracking) revert(deltas []model.Metric) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, m := range deltas {
+		dm, ok := m.(deltaMetric)
+		if !ok {
+			continue
+		}
+		switch delta := dm.value.(type) {
+		case int64:
+			d.counters[dm.key] -= delta
+		case model.Histogram:
+			d.histograms[dm.key] = d.histograms[dm.key].Sub(delta)
+		}
+	}
+}
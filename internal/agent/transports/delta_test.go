@@ -0,0 +1,218 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// recording is a transport recording sent values, fails while down
+type recording struct {
+	down bool
+	sent []model.Metric
+}
+
+func (r *recording) SendMetric(metric model.Metric) error {
+	return r.SendMetrics([]model.Metric{metric})
+}
+
+func (r *recording) SendMetrics(mx []model.Metric) error {
+	return r.SendMetricsWithContext(context.Background(), mx)
+}
+
+func (r *recording) SendMetricsWithContext(_ context.Context, mx []model.Metric) error {
+	if r.down {
+		return errors.New("server is down")
+	}
+	r.sent = append(r.sent, mx...)
+	return nil
+}
+
+// transportFunc is a transport sending metrics with a function
+type transportFunc func(ctx context.Context, mx []model.Metric) error
+
+func (f transportFunc) SendMetric(metric model.Metric) error {
+	return f(context.Background(), []model.Metric{metric})
+}
+
+func (f transportFunc) SendMetrics(mx []model.Metric) error {
+	return f(context.Background(), mx)
+}
+
+func (f transportFunc) SendMetricsWithContext(ctx context.Context, mx []model.Metric) error {
+	return f(ctx, mx)
+}
+
+// cumulative is a metric with cumulative value
+type cumulative struct {
+	mType string
+	value interface{}
+}
+
+func (c cumulative) String() string       { return "" }
+func (c cumulative) ID() string           { return "m" }
+func (c cumulative) Type() string         { return c.mType }
+func (c cumulative) Val() interface{}     { return c.value }
+func (c cumulative) Labels() model.Labels { return nil }
+
+func TestDeltaTracking(t *testing.T) {
+	t.Run("counter", func(t *testing.T) {
+		r := &recording{}
+		d := NewDeltaTracking(r)
+		send := func(v int64) error {
+			return d.SendMetrics([]model.Metric{cumulative{mType: model.COUNTER, value: v}})
+		}
+
+		require.NoError(t, send(5))
+		require.NoError(t, send(8))
+		r.down = true
+		assert.Error(t, send(10))
+		r.down = false
+		require.NoError(t, send(12))
+		require.NoError(t, send(2))
+
+		var deltas []int64
+		for _, m := range r.sent {
+			deltas = append(deltas, m.Val().(int64))
+		}
+		assert.Equal(t, []int64{5, 3, 4, 2}, deltas, "failed increment is sent with the next one, reset counter is sent as is")
+	})
+	t.Run("histogram", func(t *testing.T) {
+		r := &recording{}
+		d := NewDeltaTracking(r)
+		h := model.NewHistogram([]float64{1})
+		send := func() error {
+			return d.SendMetrics([]model.Metric{cumulative{mType: model.HISTOGRAM, value: h.Copy()}})
+		}
+
+		h.Observe(0.5)
+		require.NoError(t, send())
+		h.Observe(2)
+		r.down = true
+		assert.Error(t, send())
+		r.down = false
+		h.Observe(3)
+		require.NoError(t, send())
+
+		require.Len(t, r.sent, 2)
+		delta := r.sent[1].Val().(model.Histogram)
+		assert.Equal(t, []int64{0, 2}, delta.Counts)
+		assert.Equal(t, int64(2), delta.Count)
+		assert.Equal(t, 5.0, delta.Sum)
+	})
+	t.Run("partially sent", func(t *testing.T) {
+		r := &recording{}
+		failed := []int{1}
+		d := NewDeltaTracking(transportFunc(func(ctx context.Context, mx []model.Metric) error {
+			if err := r.SendMetricsWithContext(ctx, mx); err != nil || failed == nil {
+				return err
+			}
+			defer func() { failed = nil }()
+			return &PartialError{Failed: failed, Err: ErrTemporary}
+		}))
+		h := model.NewHistogram([]float64{1})
+		send := func(v int64) error {
+			return d.SendMetrics([]model.Metric{
+				cumulative{mType: model.COUNTER, value: v},
+				cumulative{mType: model.HISTOGRAM, value: h.Copy()},
+			})
+		}
+
+		h.Observe(0.5)
+		assert.Error(t, send(5))
+		h.Observe(2)
+		require.NoError(t, send(8))
+
+		require.Len(t, r.sent, 4)
+		assert.Equal(t, int64(3), r.sent[2].Val(), "sent counter increment should not be sent again")
+		assert.Equal(t, int64(2), r.sent[3].Val().(model.Histogram).Count, "not sent histogram observations should be sent again")
+	})
+	t.Run("partially sent with unacknowledged metrics", func(t *testing.T) {
+		r := &recording{}
+		failed := []int{0}
+		d := NewDeltaTracking(transportFunc(func(ctx context.Context, mx []model.Metric) error {
+			if err := r.SendMetricsWithContext(ctx, mx); err != nil || failed == nil {
+				return err
+			}
+			defer func() { failed = nil }()
+			return &PartialError{Failed: failed, Unknown: failed, Err: ErrTemporary}
+		}))
+
+		assert.Error(t, d.SendMetrics([]model.Metric{cumulative{mType: model.COUNTER, value: int64(5)}}))
+		require.NoError(t, d.SendMetrics([]model.Metric{cumulative{mType: model.COUNTER, value: int64(8)}}))
+
+		require.Len(t, r.sent, 3)
+		assert.Equal(t, int64(5), r.sent[1].Val(), "batch should be sent again as is, as server may have applied it")
+		assert.Equal(t, int64(3), r.sent[2].Val(), "increment should not include value which may have been applied")
+	})
+	t.Run("batch applied by server but timed out", func(t *testing.T) {
+		var (
+			total   int64
+			applied = make(map[string]bool)
+			timeout = true
+		)
+		// server deduplicating batches by identifier, response to the first batch is not received in time
+		d := NewDeltaTracking(transportFunc(func(ctx context.Context, mx []model.Metric) error {
+			id := BatchID(ctx)
+			require.NotEmpty(t, id)
+			if !applied[id] {
+				applied[id] = true
+				for _, m := range mx {
+					total += m.Val().(int64)
+				}
+			}
+			if timeout {
+				timeout = false
+				return context.DeadlineExceeded
+			}
+			return nil
+		}))
+		send := func(v int64) error {
+			ctx := WithBatchID(context.Background(), NewBatchID())
+			return d.SendMetricsWithContext(ctx, []model.Metric{cumulative{mType: model.COUNTER, value: v}})
+		}
+
+		assert.Error(t, send(5))
+		require.NoError(t, send(8))
+		require.NoError(t, send(10))
+		assert.Equal(t, int64(10), total, "increments should be applied once")
+	})
+	t.Run("batch not applied by server and timed out", func(t *testing.T) {
+		r := &recording{}
+		down := true
+		d := NewDeltaTracking(transportFunc(func(ctx context.Context, mx []model.Metric) error {
+			if down {
+				return context.DeadlineExceeded
+			}
+			return r.SendMetricsWithContext(ctx, mx)
+		}))
+		send := func(v int64) error {
+			return d.SendMetrics([]model.Metric{cumulative{mType: model.COUNTER, value: v}})
+		}
+
+		assert.Error(t, send(5))
+		assert.Error(t, send(8), "pending batch should be sent first")
+		down = false
+		require.NoError(t, send(10))
+
+		var deltas []int64
+		for _, m := range r.sent {
+			deltas = append(deltas, m.Val().(int64))
+		}
+		assert.Equal(t, []int64{5, 5}, deltas)
+	})
+	t.Run("gauge is sent as is", func(t *testing.T) {
+		r := &recording{}
+		d := NewDeltaTracking(r)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, d.SendMetrics([]model.Metric{cumulative{mType: model.GAUGE, value: 1.5}}))
+		}
+		require.Len(t, r.sent, 2)
+		assert.Equal(t, 1.5, r.sent[1].Val())
+	})
+}
@@ -0,0 +1,188 @@
+package transports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// Prober is implemented by transports which can check whether server is healthy
+type Prober interface {
+	Ping(ctx context.Context) error
+}
+
+// FailoverPolicy describes when failover transport switches between primary and standby
+type FailoverPolicy struct {
+	Threshold     int           `env:"FAILOVER_THRESHOLD" json:"threshold,omitempty"`           // consecutive failures of primary to switch to standby
+	ProbeInterval time.Duration `env:"FAILOVER_PROBE_INTERVAL" json:"probe_interval,omitempty"` // how often primary is probed while standby is used
+}
+
+// DefaultFailoverPolicy switches to standby after 3 failures and probes primary every 30 seconds
+var DefaultFailoverPolicy = FailoverPolicy{
+	Threshold:     3,
+	ProbeInterval: 30 * time.Second,
+}
+
+func (p *FailoverPolicy) UnmarshalJSON(b []byte) error {
+	type policyAlias FailoverPolicy
+
+	aliasValue := &struct {
+		*policyAlias
+		ProbeInterval string `json:"probe_interval,omitempty"`
+	}{
+		policyAlias: (*policyAlias)(p),
+	}
+
+	err := json.Unmarshal(b, aliasValue)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal json: %w", err)
+	}
+
+	if len(aliasValue.ProbeInterval) > 0 {
+		p.ProbeInterval, err = time.ParseDuration(aliasValue.ProbeInterval)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Failover is a transport which sends metrics to primary transport until it fails (with retryable error) policy's
+// threshold times in a row, then to standby one. While standby is used, primary is probed at policy's interval
+// (before sending) and used again once it is healthy.
+//
+// Primary is probed by prober if it is set (e.g. primary transport itself, see Prober), otherwise sending to primary
+// is just tried again. Probes check server liveness, which does not depend on its database connection
+type Failover struct {
+	primary Transport
+	standby Transport
+	prober  Prober
+	policy  FailoverPolicy
+	now     func() time.Time
+	state   *failoverState
+}
+
+type failoverState struct {
+	mu        sync.Mutex
+	onStandby bool
+	failures  int
+	probedAt  time.Time
+}
+
+func NewFailover(primary Transport, standby Transport, prober Prober, policy FailoverPolicy) Failover {
+	if policy.Threshold < 1 {
+		policy.Threshold = 1
+	}
+	return Failover{
+		primary: primary,
+		standby: standby,
+		prober:  prober,
+		policy:  policy,
+		now:     time.Now,
+		state:   new(failoverState),
+	}
+}
+
+func (f Failover) SendMetric(metric model.Metric) error {
+	return f.send(context.Background(), func(t Transport) error {
+		return t.SendMetric(metric)
+	})
+}
+
+func (f Failover) SendMetrics(mx []model.Metric) error {
+	return f.SendMetricsWithContext(context.Background(), mx)
+}
+
+func (f Failover) SendMetricsWithContext(ctx context.Context, mx []model.Metric) error {
+	return f.send(ctx, func(t Transport) error {
+		return t.SendMetricsWithContext(ctx, mx)
+	})
+}
+
+// OnStandby returns whether standby transport is used
+func (f Failover) OnStandby() bool {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	return f.state.onStandby
+}
+
+func (f Failover) send(ctx context.Context, send func(t Transport) error) error {
+	if f.OnStandby() && f.probe(ctx) {
+		return f.sendStandby(send)
+	}
+	err := send(f.primary)
+
+	f.state.mu.Lock()
+	if err == nil {
+		f.state.failures = 0
+		f.state.mu.Unlock()
+		return nil
+	}
+	if !IsRetryable(err) {
+		f.state.mu.Unlock()
+		return err
+	}
+	f.state.failures++
+	switched := f.state.failures >= f.policy.Threshold && !f.state.onStandby
+	if switched {
+		f.state.onStandby = true
+		f.state.probedAt = f.now()
+	}
+	f.state.mu.Unlock()
+
+	if !switched {
+		return err
+	}
+	log.Warn().Err(err).Msgf("primary failed %d times in a row, switching to standby", f.policy.Threshold)
+	return f.sendStandby(send)
+}
+
+func (f Failover) sendStandby(send func(t Transport) error) error {
+	err := send(f.standby)
+	if err != nil {
+		return fmt.Errorf("standby failed: %w", err)
+	}
+	return nil
+}
+
+// probe returns whether standby should still be used: primary is probed if probe interval passed, and if it is
+// healthy (or there is no prober), primary is used again. Sending is not blocked while primary is probed, standby is
+// used by concurrent sends
+func (f Failover) probe(ctx context.Context) bool {
+	f.state.mu.Lock()
+	now := f.now()
+	if now.Sub(f.state.probedAt) < f.policy.ProbeInterval {
+		f.state.mu.Unlock()
+		return true
+	}
+	f.state.probedAt = now
+	if f.prober == nil {
+		// primary is tried once, the next failure switches back to standby
+		f.state.failures = f.policy.Threshold - 1
+		f.state.onStandby = false
+		f.state.mu.Unlock()
+		log.Info().Msg("switching back to primary")
+		return false
+	}
+	f.state.mu.Unlock()
+
+	if err := f.prober.Ping(ctx); err != nil {
+		log.Debug().Err(err).Msg("primary is still unhealthy")
+		return true
+	}
+
+	f.state.mu.Lock()
+	f.state.failures = 0
+	f.state.onStandby = false
+	f.state.mu.Unlock()
+	log.Info().Msg("switching back to primary")
+	return false
+}
@@ -0,0 +1,145 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// prober is a prober reporting primary unhealthy while down
+type prober struct {
+	down  bool
+	calls int
+}
+
+func (p *prober) Ping(_ context.Context) error {
+	p.calls++
+	if p.down {
+		return errors.New("primary is down")
+	}
+	return nil
+}
+
+// proberFunc is a prober checking primary with a function
+type proberFunc func(ctx context.Context) error
+
+func (f proberFunc) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestFailover(t *testing.T) {
+	policy := FailoverPolicy{
+		Threshold:     2,
+		ProbeInterval: time.Minute,
+	}
+	m := []model.Metric{cumulative{mType: model.GAUGE, value: 1.0}}
+
+	t.Run("switch to standby and back", func(t *testing.T) {
+		primary := &failing{errs: []error{ErrTemporary, ErrTemporary}}
+		standby := &recording{}
+		p := &prober{down: true}
+		f := NewFailover(primary, standby, p, policy)
+		now := time.Now()
+		f.now = func() time.Time { return now }
+
+		assert.Error(t, f.SendMetrics(m))
+		assert.False(t, f.OnStandby())
+		assert.NoError(t, f.SendMetrics(m), "batch should be sent to standby after threshold is reached")
+		assert.True(t, f.OnStandby())
+		assert.Len(t, standby.sent, 1)
+
+		require.NoError(t, f.SendMetrics(m))
+		assert.Equal(t, 0, p.calls, "primary should not be probed before interval passes")
+		assert.Len(t, primary.batchIDs, 2)
+
+		now = now.Add(time.Minute)
+		require.NoError(t, f.SendMetrics(m))
+		assert.Equal(t, 1, p.calls)
+		assert.True(t, f.OnStandby(), "standby should be used while primary is unhealthy")
+		assert.Len(t, standby.sent, 3)
+
+		now = now.Add(time.Minute)
+		p.down = false
+		require.NoError(t, f.SendMetrics(m))
+		assert.False(t, f.OnStandby())
+		assert.Len(t, primary.batchIDs, 3)
+		assert.Len(t, standby.sent, 3)
+	})
+
+	t.Run("no prober", func(t *testing.T) {
+		primary := &failing{errs: []error{ErrTemporary, ErrTemporary, ErrTemporary}}
+		standby := &recording{}
+		f := NewFailover(primary, standby, nil, policy)
+		now := time.Now()
+		f.now = func() time.Time { return now }
+
+		assert.Error(t, f.SendMetrics(m))
+		require.NoError(t, f.SendMetrics(m))
+		require.True(t, f.OnStandby())
+
+		now = now.Add(time.Minute)
+		require.NoError(t, f.SendMetrics(m))
+		assert.True(t, f.OnStandby(), "single failure of primary should switch back to standby")
+		assert.Len(t, primary.batchIDs, 3)
+
+		now = now.Add(time.Minute)
+		require.NoError(t, f.SendMetrics(m))
+		assert.False(t, f.OnStandby())
+		assert.Len(t, primary.batchIDs, 4)
+	})
+
+	t.Run("non-retryable error", func(t *testing.T) {
+		primary := &failing{errs: []error{errors.New("bad request"), errors.New("bad request")}}
+		standby := &recording{}
+		f := NewFailover(primary, standby, nil, policy)
+
+		assert.Error(t, f.SendMetrics(m))
+		assert.Error(t, f.SendMetrics(m))
+		assert.False(t, f.OnStandby())
+		assert.Empty(t, standby.sent)
+	})
+
+	t.Run("standby fails", func(t *testing.T) {
+		primary := &failing{errs: []error{ErrTemporary}}
+		standby := &recording{down: true}
+		f := NewFailover(primary, standby, nil, FailoverPolicy{Threshold: 1, ProbeInterval: time.Minute})
+
+		assert.Error(t, f.SendMetrics(m))
+		assert.True(t, f.OnStandby())
+	})
+
+	t.Run("sending is not blocked while primary is probed", func(t *testing.T) {
+		primary := &failing{errs: []error{ErrTemporary}}
+		standby := &recording{}
+		probing, release := make(chan struct{}), make(chan struct{})
+		p := proberFunc(func(_ context.Context) error {
+			close(probing)
+			<-release
+			return nil
+		})
+		f := NewFailover(primary, standby, p, FailoverPolicy{Threshold: 1, ProbeInterval: time.Minute})
+		now := time.Now()
+		f.now = func() time.Time { return now }
+		require.NoError(t, f.SendMetrics(m))
+		require.True(t, f.OnStandby())
+
+		now = now.Add(time.Minute)
+		probed := make(chan error)
+		go func() {
+			probed <- f.SendMetrics(m)
+		}()
+		<-probing
+		require.NoError(t, f.SendMetrics(m))
+		assert.Len(t, standby.sent, 2, "standby should be used while primary is probed")
+		close(release)
+		require.NoError(t, <-probed)
+		assert.False(t, f.OnStandby())
+		assert.Len(t, primary.batchIDs, 2)
+	})
+}
@@ -0,0 +1,70 @@
+package grpc
+
+import (
+	"sync"
+)
+
+// maxTrackedBatches is a number of partially sent batches which settled metrics are kept for
+const maxTrackedBatches = 64
+
+// ackLog keeps sequence numbers of settled metrics (acknowledged by server or rejected by it for good) of partially
+// sent batches, so that only unsettled metrics are sent when batch is sent again
+type ackLog struct {
+	mu      sync.Mutex
+	batches map[string]map[uint64]bool
+	order   []string
+}
+
+func newAckLog() *ackLog {
+	return &ackLog{
+		batches: make(map[string]map[uint64]bool),
+	}
+}
+
+// settled returns whether metric of batch is settled
+func (l *ackLog) settled(id string, seq uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.batches[id][seq]
+}
+
+// settle marks metrics of batch as settled, the oldest batch is forgotten if too many batches are tracked
+func (l *ackLog) settle(id string, seqs []uint64) {
+	if len(id) == 0 || len(seqs) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	settled, ok := l.batches[id]
+	if !ok {
+		if len(l.order) >= maxTrackedBatches {
+			delete(l.batches, l.order[0])
+			l.order = l.order[1:]
+		}
+		settled = make(map[uint64]bool)
+		l.batches[id] = settled
+		l.order = append(l.order, id)
+	}
+	for _, seq := range seqs {
+		settled[seq] = true
+	}
+}
+
+// forget removes batch which is sent completely
+func (l *ackLog) forget(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.batches[id]; !ok {
+		return
+	}
+	delete(l.batches, id)
+	for i, o := range l.order {
+		if o == id {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
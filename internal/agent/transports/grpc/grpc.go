@@ -2,21 +2,46 @@ package grpc
 
 import (
 	"context"
-	"encoding/hex"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	pb "github.com/tony-spark/metrico/gen/pb/api"
 	"github.com/tony-spark/metrico/internal/agent/transports"
+	"github.com/tony-spark/metrico/internal/crypto"
 	"github.com/tony-spark/metrico/internal/dto"
 	"github.com/tony-spark/metrico/internal/model"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+const (
+	agentIDKey = "x-agent-id"
+	batchIDKey = "x-batch-id"
+)
+
+var errStreamUnsupported = errors.New("update stream is not supported by server")
+
 type Transport struct {
-	client pb.MetricServiceClient
-	hasher dto.Hasher
+	client    pb.MetricServiceClient
+	health    healthpb.HealthClient
+	hasher    dto.Hasher
+	agentID   string
+	timeout   time.Duration
+	tls       *tls.Config
+	encryptor crypto.Encryptor
+	acks      *ackLog
 }
 
 type Option func(t *Transport)
@@ -27,20 +52,53 @@ func WithHasher(h dto.Hasher) Option {
 	}
 }
 
-func NewTransport(addr string, opts ...Option) (transports.Transport, error) {
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return nil, fmt.Errorf("could not dial grpc: %w", err)
+// WithEncryptor configures transport to encrypt every metric sent
+func WithEncryptor(e crypto.Encryptor) Option {
+	return func(t *Transport) {
+		t.encryptor = e
 	}
+}
 
-	t := Transport{
-		client: pb.NewMetricServiceClient(conn),
+// WithAgentID configures transport to send agent identifier with metrics
+func WithAgentID(id string) Option {
+	return func(t *Transport) {
+		t.agentID = id
+	}
+}
+
+// WithTimeout configures transport to fail calls which take longer than given timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(t *Transport) {
+		t.timeout = timeout
+	}
+}
+
+// WithTLSConfig configures transport to connect to server via TLS
+func WithTLSConfig(config *tls.Config) Option {
+	return func(t *Transport) {
+		t.tls = config
 	}
+}
 
+func NewTransport(addr string, opts ...Option) (transports.Transport, error) {
+	t := Transport{
+		acks: newAckLog(),
+	}
 	for _, opt := range opts {
 		opt(&t)
 	}
 
+	creds := insecure.NewCredentials()
+	if t.tls != nil {
+		creds = credentials.NewTLS(t.tls)
+	}
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("could not dial grpc: %w", err)
+	}
+	t.client = pb.NewMetricServiceClient(conn)
+	t.health = healthpb.NewHealthClient(conn)
+
 	return t, nil
 }
 
@@ -52,10 +110,152 @@ func (t Transport) SendMetrics(mx []model.Metric) error {
 	return t.SendMetricsWithContext(context.Background(), mx)
 }
 
+// SendMetricsWithContext sends metrics in update stream, so that every metric is acknowledged by server. If some of
+// metrics are not saved, PartialError is returned, and only these metrics are sent when batch is sent again.
+// Metrics are sent in a single update if server does not support update stream
 func (t Transport) SendMetricsWithContext(ctx context.Context, mx []model.Metric) error {
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	if len(t.agentID) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, agentIDKey, t.agentID)
+	}
+	if id := transports.BatchID(ctx); len(id) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, batchIDKey, id)
+	}
+	err := t.updateStream(ctx, mx)
+	if errors.Is(err, errStreamUnsupported) {
+		log.Warn().Msg("server does not support update stream, sending metrics in a single update")
+		return t.update(ctx, mx)
+	}
+	return err
+}
+
+func (t Transport) updateStream(ctx context.Context, mx []model.Metric) error {
+	id := transports.BatchID(ctx)
+	var updates []*pb.MetricUpdate
+	for i, m := range mx {
+		if t.acks.settled(id, uint64(i)) {
+			continue
+		}
+		mt, err := t.createDTO(m)
+		if err != nil {
+			log.Error().Err(err).Msg("could not create dto")
+			continue
+		}
+		updates = append(updates, &pb.MetricUpdate{Seq: uint64(i), Metric: mt})
+	}
+	if len(updates) == 0 {
+		t.acks.forget(id)
+		return nil
+	}
+
+	us, err := t.client.UpdateStream(ctx)
+	if err != nil {
+		return callError("could not init grpc stream", err)
+	}
+	var acks []*pb.Ack
+	received := make(chan error, 1)
+	go func() {
+		for {
+			ack, err := us.Recv()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				received <- err
+				return
+			}
+			acks = append(acks, ack)
+		}
+	}()
+	for _, u := range updates {
+		// stream error is returned by Recv
+		if err = us.Send(u); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = us.CloseSend()
+	}
+	if recvErr := <-received; recvErr != nil {
+		if status.Code(recvErr) == codes.Unimplemented {
+			return errStreamUnsupported
+		}
+		return callError("could not receive acknowledgements", recvErr)
+	}
+	if err != nil && err != io.EOF {
+		return callError("could not send metrics", err)
+	}
+
+	return t.settle(id, mx, updates, acks)
+}
+
+// settle records acknowledgements of metrics sent, returning PartialError if some of them are not saved
+func (t Transport) settle(id string, mx []model.Metric, updates []*pb.MetricUpdate, acks []*pb.Ack) error {
+	unacked := make(map[uint64]bool, len(updates))
+	for _, u := range updates {
+		unacked[u.Seq] = true
+	}
+	var (
+		settled  []uint64
+		failed   []int
+		rejected []string
+	)
+	for _, ack := range acks {
+		if !unacked[ack.Seq] {
+			continue
+		}
+		delete(unacked, ack.Seq)
+		m := mx[ack.Seq]
+		switch {
+		case ack.Status == pb.Status_OK:
+			settled = append(settled, ack.Seq)
+			log.Info().Msgf("sent %v (%v) = %v", m.ID(), m.Type(), m.String())
+		case ack.Reason == pb.Reason_UNAVAILABLE:
+			failed = append(failed, int(ack.Seq))
+		default:
+			// sending metric again would not help
+			settled = append(settled, ack.Seq)
+			failed = append(failed, int(ack.Seq))
+			rejected = append(rejected, describeRejection(m.ID(), ack.Reason, ack.GetError(), ack.GetRepr()))
+		}
+	}
+	unsaved := len(failed) - len(rejected) + len(unacked)
+	var unknown []int
+	for seq := range unacked {
+		unknown = append(unknown, int(seq))
+	}
+	failed = append(failed, unknown...)
+	if len(failed) == 0 {
+		t.acks.forget(id)
+		return nil
+	}
+	t.acks.settle(id, settled)
+	sort.Ints(failed)
+	sort.Ints(unknown)
+
+	var err error
+	if len(rejected) > 0 {
+		err = fmt.Errorf("metrics rejected: %s", strings.Join(rejected, ", "))
+	}
+	if unsaved > 0 {
+		if err != nil {
+			err = fmt.Errorf("%w: %d metrics not saved, %v", transports.ErrTemporary, unsaved, err)
+		} else {
+			err = fmt.Errorf("%w: %d metrics not saved", transports.ErrTemporary, unsaved)
+		}
+	}
+	return &transports.PartialError{Failed: failed, Unknown: unknown, Err: err}
+}
+
+// update sends metrics in a single update, which server applies atomically
+func (t Transport) update(ctx context.Context, mx []model.Metric) error {
 	uc, err := t.client.Update(ctx)
 	if err != nil {
-		return fmt.Errorf("could not init grpc stream: %w", err)
+		return callError("could not init grpc stream", err)
 	}
 	for _, m := range mx {
 		var mt *pb.Metric
@@ -74,40 +274,89 @@ func (t Transport) SendMetricsWithContext(ctx context.Context, mx []model.Metric
 	var r *pb.Response
 	r, err = uc.CloseAndRecv()
 	if err != nil {
-		return fmt.Errorf("could not close stream: %w", err)
+		return callError("could not close stream", err)
 	}
 	if r.Status == pb.Status_ERROR {
+		details := make([]string, 0, len(r.GetRejections()))
+		for _, rejection := range r.GetRejections() {
+			details = append(details, describeRejection(rejection.GetId(), rejection.GetReason(), rejection.GetError(), rejection.GetRepr()))
+		}
+		if len(details) > 0 {
+			return fmt.Errorf("error sending metrics: %s: %s", r.GetError(), strings.Join(details, ", "))
+		}
 		return fmt.Errorf("error sending metrics: %s", r.GetError())
 	}
 	return nil
 }
 
+// describeRejection returns description of metric rejected by server. Representation of metric hashed by server is
+// included on hash mismatch, so that it can be compared with agent's one
+func describeRejection(id string, reason pb.Reason, errTxt string, repr string) string {
+	if len(repr) > 0 {
+		return fmt.Sprintf("%s (%s: %s, server hashed %q)", id, reason, errTxt, repr)
+	}
+	return fmt.Sprintf("%s (%s: %s)", id, reason, errTxt)
+}
+
+// Ping checks whether server is alive (via standard health service), regardless of its database connection. Servers
+// without health service are alive if they respond to database status call, whatever status is
+func (t Transport) Ping(ctx context.Context) error {
+	r, err := t.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		if _, err = t.client.DBStatus(ctx, &pb.Empty{}); err != nil {
+			return callError("could not check server status", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return callError("could not check server health", err)
+	}
+	if r.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("server is not healthy: %s", r.Status)
+	}
+	return nil
+}
+
+// callError wraps error of grpc call: unavailability of server and deadline exceeded are temporary, cancelled call
+// matches context.Canceled, so that outcome of call is known to be unknown
+func callError(msg string, err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return fmt.Errorf("%w: %s: %v", transports.ErrTemporary, msg, err)
+	case codes.Canceled:
+		return fmt.Errorf("%w: %s: %v", context.Canceled, msg, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
 func (t Transport) createDTO(metric model.Metric) (*pb.Metric, error) {
 	d := dto.NewMetric(metric)
-	var hash []byte
 	if t.hasher != nil {
 		var err error
 		d.Hash, err = t.hasher.Hash(*d)
 		if err != nil {
 			return nil, fmt.Errorf("could not hash: %w", err)
 		}
-		hash, err = hex.DecodeString(d.Hash)
-		if err != nil {
-			return nil, fmt.Errorf("could not decode hash: %w", err)
-		}
 	}
-	var mt pb.MetricType
-	switch metric.Type() {
-	case model.GAUGE:
-		mt = pb.MetricType_GAUGE
-	case model.COUNTER:
-		mt = pb.MetricType_COUNTER
-	}
-	return &pb.Metric{
-		Id:    d.ID,
-		Type:  mt,
-		Delta: d.Delta,
-		Value: d.Value,
-		Hash:  hash,
-	}, nil
+	m, err := d.Proto()
+	if err != nil {
+		return nil, err
+	}
+	if t.encryptor != nil {
+		return t.encrypt(m)
+	}
+	return m, nil
+}
+
+// encrypt returns metric which contains only encrypted given one
+func (t Transport) encrypt(m *pb.Metric) (*pb.Metric, error) {
+	bs, err := proto.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal metric: %w", err)
+	}
+	encrypted, err := t.encryptor.Encrypt(bs)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt metric: %w", err)
+	}
+	return &pb.Metric{Encrypted: encrypted}, nil
 }
@@ -0,0 +1,285 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/tony-spark/metrico/gen/pb/api"
+	"github.com/tony-spark/metrico/internal/agent/metrics"
+	"github.com/tony-spark/metrico/internal/agent/transports"
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// streamServer acknowledges metrics received in update stream, nacking ones with given reasons once and not
+// acknowledging unacked ones once
+type streamServer struct {
+	pb.UnimplementedMetricServiceServer
+
+	nacks    map[uint64]pb.Reason
+	unacked  map[uint64]bool
+	received [][]uint64
+}
+
+func (s *streamServer) UpdateStream(stream pb.MetricService_UpdateStreamServer) error {
+	var seqs []uint64
+	defer func() {
+		s.received = append(s.received, seqs)
+	}()
+	for {
+		u, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		seqs = append(seqs, u.Seq)
+		if s.unacked[u.Seq] {
+			delete(s.unacked, u.Seq)
+			continue
+		}
+		ack := &pb.Ack{Seq: u.Seq, Status: pb.Status_OK}
+		if reason, ok := s.nacks[u.Seq]; ok {
+			delete(s.nacks, u.Seq)
+			ack.Status = pb.Status_ERROR
+			ack.Reason = reason
+		}
+		if err = stream.Send(ack); err != nil {
+			return err
+		}
+	}
+}
+
+// updateServer supports single update only
+type updateServer struct {
+	pb.UnimplementedMetricServiceServer
+
+	received int
+}
+
+func (s *updateServer) Update(stream pb.MetricService_UpdateServer) error {
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.Response{Status: pb.Status_OK})
+		}
+		if err != nil {
+			return err
+		}
+		s.received++
+	}
+}
+
+// stallingServer applies metrics received in update stream once per batch and sequence number, the first stream stalls
+// after stallAfter metrics are applied
+type stallingServer struct {
+	pb.UnimplementedMetricServiceServer
+
+	mu         sync.Mutex
+	stallAfter int
+	stalled    bool
+	applied    map[string]bool
+	total      int64
+}
+
+func (s *stallingServer) UpdateStream(stream pb.MetricService_UpdateStreamServer) error {
+	var id string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok && len(md.Get(batchIDKey)) > 0 {
+		id = md.Get(batchIDKey)[0]
+	}
+	for n := 1; ; n++ {
+		u, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s.apply(fmt.Sprintf("%s/%d", id, u.Seq), u.Metric.GetDelta(), n) {
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		}
+		if err = stream.Send(&pb.Ack{Seq: u.Seq, Status: pb.Status_OK}); err != nil {
+			return err
+		}
+	}
+}
+
+// apply applies n-th metric of stream, returning whether stream should stall
+func (s *stallingServer) apply(key string, delta int64, n int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.applied[key] {
+		s.applied[key] = true
+		s.total += delta
+	}
+	if !s.stalled && n == s.stallAfter {
+		s.stalled = true
+		return true
+	}
+	return false
+}
+
+func (s *stallingServer) appliedTotal() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.total
+}
+
+// dbStatusServer reports database status only, like servers without health service
+type dbStatusServer struct {
+	pb.UnimplementedMetricServiceServer
+}
+
+func (s dbStatusServer) DBStatus(_ context.Context, _ *pb.Empty) (*pb.Response, error) {
+	return &pb.Response{Status: pb.Status_ERROR}, nil
+}
+
+func newTestTransport(t *testing.T, srv pb.MetricServiceServer) Transport {
+	return newTestTransportWith(t, func(server *grpc.Server) {
+		pb.RegisterMetricServiceServer(server, srv)
+		healthpb.RegisterHealthServer(server, health.NewServer())
+	})
+}
+
+// newTestTransportWith returns transport connected to test server with services registered by register
+func newTestTransportWith(t *testing.T, register func(server *grpc.Server)) Transport {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	register(server)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return Transport{
+		client: pb.NewMetricServiceClient(conn),
+		health: healthpb.NewHealthClient(conn),
+		acks:   newAckLog(),
+	}
+}
+
+func TestTransportPing(t *testing.T) {
+	// liveness does not depend on database status (DBStatus is not implemented by test server)
+	transport := newTestTransport(t, &streamServer{})
+	assert.NoError(t, transport.Ping(context.Background()))
+
+	t.Run("server without health service", func(t *testing.T) {
+		transport := newTestTransportWith(t, func(server *grpc.Server) {
+			pb.RegisterMetricServiceServer(server, dbStatusServer{})
+		})
+		assert.NoError(t, transport.Ping(context.Background()), "server without database should be alive")
+	})
+}
+
+func TestTransportUpdateStream(t *testing.T) {
+	mx := []model.Metric{
+		metrics.NewGaugeMetric("Alloc", 1.0),
+		metrics.NewCounterMetric("PollCount", 5),
+		metrics.NewGaugeMetric("Frees", 2.0),
+	}
+
+	t.Run("all acknowledged", func(t *testing.T) {
+		srv := &streamServer{}
+		tr := newTestTransport(t, srv)
+		ctx := transports.WithBatchID(context.Background(), "batch1")
+
+		require.NoError(t, tr.SendMetricsWithContext(ctx, mx))
+		assert.Equal(t, [][]uint64{{0, 1, 2}}, srv.received)
+	})
+
+	t.Run("only unsaved metrics are sent again", func(t *testing.T) {
+		srv := &streamServer{nacks: map[uint64]pb.Reason{1: pb.Reason_UNAVAILABLE}}
+		tr := newTestTransport(t, srv)
+		ctx := transports.WithBatchID(context.Background(), "batch1")
+
+		err := tr.SendMetricsWithContext(ctx, mx)
+		var pe *transports.PartialError
+		require.ErrorAs(t, err, &pe)
+		assert.Equal(t, []int{1}, pe.Failed)
+		assert.Empty(t, pe.Unknown, "explicitly nacked metric is known to be not saved")
+		assert.True(t, transports.IsRetryable(err))
+
+		require.NoError(t, tr.SendMetricsWithContext(ctx, mx))
+		assert.Equal(t, [][]uint64{{0, 1, 2}, {1}}, srv.received)
+	})
+
+	t.Run("unacknowledged metrics are sent again", func(t *testing.T) {
+		srv := &streamServer{unacked: map[uint64]bool{2: true}}
+		tr := newTestTransport(t, srv)
+		ctx := transports.WithBatchID(context.Background(), "batch1")
+
+		err := tr.SendMetricsWithContext(ctx, mx)
+		var pe *transports.PartialError
+		require.ErrorAs(t, err, &pe)
+		assert.Equal(t, []int{2}, pe.Failed)
+		assert.Equal(t, []int{2}, pe.Unknown, "server may have saved unacknowledged metric")
+		assert.True(t, transports.IsRetryable(err))
+
+		require.NoError(t, tr.SendMetricsWithContext(ctx, mx))
+		assert.Equal(t, [][]uint64{{0, 1, 2}, {2}}, srv.received)
+	})
+
+	t.Run("rejected metrics are not sent again", func(t *testing.T) {
+		srv := &streamServer{nacks: map[uint64]pb.Reason{0: pb.Reason_BAD_HASH}}
+		tr := newTestTransport(t, srv)
+		ctx := transports.WithBatchID(context.Background(), "batch1")
+
+		err := tr.SendMetricsWithContext(ctx, mx)
+		var pe *transports.PartialError
+		require.ErrorAs(t, err, &pe)
+		assert.Equal(t, []int{0}, pe.Failed)
+		assert.False(t, transports.IsRetryable(err))
+
+		require.NoError(t, tr.SendMetricsWithContext(ctx, mx))
+		assert.Len(t, srv.received, 1)
+	})
+
+	t.Run("stream timed out after metrics are applied", func(t *testing.T) {
+		srv := &stallingServer{stallAfter: 1, applied: make(map[string]bool)}
+		tr := newTestTransport(t, srv)
+		tr.timeout = 100 * time.Millisecond
+		d := transports.NewDeltaTracking(tr)
+
+		err := d.SendMetrics([]model.Metric{metrics.NewCounterMetric("PollCount", 5), metrics.NewCounterMetric("Frees", 1)})
+		require.Error(t, err)
+		assert.True(t, transports.IsRetryable(err))
+		assert.Equal(t, int64(5), srv.appliedTotal())
+
+		require.NoError(t, d.SendMetrics([]model.Metric{metrics.NewCounterMetric("PollCount", 7), metrics.NewCounterMetric("Frees", 1)}))
+		assert.Equal(t, int64(8), srv.appliedTotal(), "metrics applied before timeout should not be applied again")
+	})
+
+	t.Run("update stream not supported", func(t *testing.T) {
+		srv := &updateServer{}
+		tr := newTestTransport(t, srv)
+
+		require.NoError(t, tr.SendMetrics(mx))
+		assert.Equal(t, len(mx), srv.received)
+	})
+}
@@ -2,18 +2,17 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
-	"net/url"
-	"strings"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog/log"
 	"github.com/tony-spark/metrico/internal/agent/transports"
 	"github.com/tony-spark/metrico/internal/crypto"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/tony-spark/metrico/internal/dto"
 	"github.com/tony-spark/metrico/internal/model"
@@ -23,32 +22,50 @@ const (
 	endpointSend          = "/update/{type}/{name}/{value}"
 	endpointSendJSON      = "/update/"
 	endpointSendJSONBatch = "/updates/"
+	endpointHealth        = "/health"
+	endpointPing          = "/ping" // database status, the only probe of servers without endpointHealth
+
+	agentIDHeader = "X-Agent-ID"
+
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+
+	defaultTimeout = 5 * time.Second
 )
 
 type Transport struct {
 	client    *resty.Client
 	hasher    dto.Hasher
 	encryptor crypto.Encryptor
-	clientIP  string
+	agentID   string
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	protobuf  bool
 }
 
 type Option func(t *Transport)
 
 func NewTransport(baseURL string, options ...Option) transports.Transport {
-	var t Transport
+	t := Transport{
+		timeout: defaultTimeout,
+	}
+
+	for _, opt := range options {
+		opt(&t)
+	}
 
 	client := resty.New()
 	client.SetBaseURL(baseURL)
-	// TODO think about better timeout value
-	client.SetTimeout(1 * time.Second)
+	client.SetTimeout(t.timeout)
+	if t.tlsConfig != nil {
+		client.SetTLSClientConfig(t.tlsConfig)
+	}
 	t.client = client
 
-	for _, opt := range options {
-		opt(&t)
+	if len(t.agentID) > 0 {
+		client.SetHeader(agentIDHeader, t.agentID)
 	}
 
-	t.clientIP = getClientIP(baseURL)
-
 	return t
 }
 
@@ -64,6 +81,36 @@ func WithEncryptor(e crypto.Encryptor) Option {
 	}
 }
 
+// WithTimeout configures transport to fail requests which take longer than given timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(t *Transport) {
+		if timeout > 0 {
+			t.timeout = timeout
+		}
+	}
+}
+
+// WithTLSConfig configures transport to use given TLS configuration for HTTPS connections
+func WithTLSConfig(config *tls.Config) Option {
+	return func(t *Transport) {
+		t.tlsConfig = config
+	}
+}
+
+// WithProtobuf configures transport to send batches in protobuf instead of JSON
+func WithProtobuf() Option {
+	return func(t *Transport) {
+		t.protobuf = true
+	}
+}
+
+// WithAgentID configures transport to send agent identifier with metrics
+func WithAgentID(id string) Option {
+	return func(t *Transport) {
+		t.agentID = id
+	}
+}
+
 func (h Transport) SendMetric(metric model.Metric) error {
 	return h.sendJSON(metric)
 }
@@ -76,6 +123,35 @@ func (h Transport) SendMetricsWithContext(ctx context.Context, mx []model.Metric
 	return h.sendJSONBatch(ctx, mx)
 }
 
+// Ping checks whether server is alive, regardless of its database connection. Servers without health endpoint are
+// pinged at database status endpoint, which response (whatever status is) means server is alive
+func (h Transport) Ping(ctx context.Context) error {
+	resp, err := h.client.R().SetContext(ctx).Get(endpointHealth)
+	if err != nil {
+		return fmt.Errorf("could not ping server: %w", err)
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return h.pingDBStatus(ctx)
+	}
+	if err = checkStatus(resp); err != nil {
+		return fmt.Errorf("server is not healthy: %w", err)
+	}
+	return nil
+}
+
+// pingDBStatus checks whether server without health endpoint is alive, gateway errors mean it is not reachable
+func (h Transport) pingDBStatus(ctx context.Context) error {
+	resp, err := h.client.R().SetContext(ctx).Get(endpointPing)
+	if err != nil {
+		return fmt.Errorf("could not ping server: %w", err)
+	}
+	switch resp.StatusCode() {
+	case http.StatusNotFound, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return fmt.Errorf("server is not healthy: response code: %v", resp.StatusCode())
+	}
+	return nil
+}
+
 func (h Transport) send(metric model.Metric) error {
 	req := h.client.R().
 		SetPathParam("type", metric.Type()).
@@ -83,13 +159,12 @@ func (h Transport) send(metric model.Metric) error {
 		SetPathParam("value", metric.String()).
 		SetHeader("Content-Type", "text/plain")
 
-	req.SetHeader("X-Real-IP", h.clientIP)
 	resp, err := req.Post(endpointSend)
 	if err != nil {
 		return fmt.Errorf("could not send metric: %w", err)
 	}
-	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("send error: value not accepted %v response code: %v", req.URL, resp.StatusCode())
+	if err = checkStatus(resp); err != nil {
+		return fmt.Errorf("send error: value not accepted %v: %w", req.URL, err)
 	}
 	log.Info().Msgf("sent %v (%v) = %v", metric.ID(), metric.Type(), metric.String())
 	return nil
@@ -112,15 +187,20 @@ func (h Transport) sendJSON(metric model.Metric) error {
 	if err != nil {
 		return err
 	}
-	req := h.client.R().
-		SetBody(d)
-	req.SetHeader("X-Real-IP", h.clientIP)
+	bs, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("could not marshal json: %w", err)
+	}
+	req := h.client.R()
+	if err = h.encodeInRequest(bs, contentTypeJSON, req); err != nil {
+		return err
+	}
 	resp, err := req.Post(endpointSendJSON)
 	if err != nil {
 		return fmt.Errorf("could not send json: %w", err)
 	}
-	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("send error: value not accepted %v response code: %v", req.URL, resp.StatusCode())
+	if err = checkStatus(resp); err != nil {
+		return fmt.Errorf("send error: value not accepted %v: %w", req.URL, err)
 	}
 	log.Info().Msgf("sent %v (%v) = %v", metric.ID(), metric.Type(), metric.String())
 	return nil
@@ -135,20 +215,23 @@ func (h Transport) sendJSONBatch(ctx context.Context, mx []model.Metric) error {
 		}
 		dtos = append(dtos, *mdto)
 	}
+	bs, contentType, err := h.marshalBatch(dto.Batch{ID: transports.BatchID(ctx), Metrics: dtos})
+	if err != nil {
+		return err
+	}
 	req := h.client.R().
 		SetContext(ctx)
-	err := h.encodeInRequest(dtos, req)
+	err = h.encodeInRequest(bs, contentType, req)
 	if err != nil {
 		return err
 	}
 
-	req.SetHeader("X-Real-IP", h.clientIP)
 	resp, err := req.Post(endpointSendJSONBatch)
 	if err != nil {
 		return fmt.Errorf("could not send batch json: %w", err)
 	}
-	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("send error: metrics not accepted %v response code: %v", req.URL, resp.StatusCode())
+	if err = checkStatus(resp); err != nil {
+		return fmt.Errorf("send error: metrics not accepted %v: %w", req.URL, err)
 	}
 	for _, metric := range mx {
 		log.Info().Msgf("sent in batch %v (%v) = %v", metric.ID(), metric.Type(), metric.String())
@@ -156,12 +239,33 @@ func (h Transport) sendJSONBatch(ctx context.Context, mx []model.Metric) error {
 	return nil
 }
 
-func (h Transport) encodeInRequest(obj interface{}, r *resty.Request) error {
-	bs, err := json.Marshal(obj)
+// marshalBatch returns batch body and its content type: pb.Batch if transport sends protobuf, JSON otherwise (array
+// of metrics if batch has no ID)
+func (h Transport) marshalBatch(batch dto.Batch) ([]byte, string, error) {
+	if h.protobuf {
+		pbatch, err := batch.Proto()
+		if err != nil {
+			return nil, "", err
+		}
+		bs, err := proto.Marshal(pbatch)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not marshal protobuf: %w", err)
+		}
+		return bs, contentTypeProtobuf, nil
+	}
+	var body interface{} = batch.Metrics
+	if len(batch.ID) > 0 {
+		body = batch
+	}
+	bs, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("could not marshal json: %w", err)
+		return nil, "", fmt.Errorf("could not marshal json: %w", err)
 	}
-	r.SetHeader("Content-Type", "application/json")
+	return bs, contentTypeJSON, nil
+}
+
+func (h Transport) encodeInRequest(bs []byte, contentType string, r *resty.Request) error {
+	r.SetHeader("Content-Type", contentType)
 	if h.encryptor != nil {
 		encrypted, err := h.encryptor.Encrypt(bs)
 		if err != nil {
@@ -175,28 +279,13 @@ func (h Transport) encodeInRequest(obj interface{}, r *resty.Request) error {
 	return nil
 }
 
-func getClientIP(URL string) string {
-	u, err := url.Parse(URL)
-	if err != nil {
-		log.Error().Err(err).Msg("could not parse URL")
+// checkStatus returns error if response is not OK, server errors are temporary
+func checkStatus(resp *resty.Response) error {
+	if resp.StatusCode() == http.StatusOK {
+		return nil
 	}
-	hostname := strings.TrimPrefix(u.Hostname(), "www.")
-	conn, err := net.Dial("udp", hostname+":80")
-	if err != nil {
-		log.Error().Err(err).Msg("could not dial address to discover own IP")
-	}
-	defer func() {
-		if conn != nil {
-			err := conn.Close()
-			if err != nil {
-				log.Error().Err(err).Msg("error closing connection")
-			}
-		}
-	}()
-
-	if conn == nil {
-		return ""
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: response code: %v", transports.ErrTemporary, resp.StatusCode())
 	}
-
-	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+	return fmt.Errorf("response code: %v", resp.StatusCode())
 }
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -9,8 +10,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 
+	pb "github.com/tony-spark/metrico/gen/pb/api"
 	"github.com/tony-spark/metrico/internal/agent/metrics"
+	"github.com/tony-spark/metrico/internal/agent/transports"
 	"github.com/tony-spark/metrico/internal/dto"
 	"github.com/tony-spark/metrico/internal/hash"
 	"github.com/tony-spark/metrico/internal/model"
@@ -151,3 +155,131 @@ func TestHTTPTransport_SendMetrics(t *testing.T) {
 		assert.Nil(t, err)
 	})
 }
+
+func TestHTTPTransportAgentID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Run("agent id header present", func(t *testing.T) {
+			assert.Equal(t, "agent1", r.Header.Get("X-Agent-ID"))
+		})
+	}))
+	defer server.Close()
+
+	transport := NewTransport(server.URL, WithAgentID("agent1"))
+	err := transport.SendMetrics([]model.Metric{metrics.NewGaugeMetric("TestGauge", 1.0)})
+	t.Run("send metrics with agent id no error", func(t *testing.T) {
+		assert.Nil(t, err)
+	})
+}
+
+func TestHTTPTransportBatchID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Run("batch with id", func(t *testing.T) {
+			bs, err := io.ReadAll(r.Body)
+			require.Nil(t, err)
+			var batch dto.Batch
+			err = json.Unmarshal(bs, &batch)
+			assert.Nil(t, err)
+			assert.Equal(t, "batch1", batch.ID)
+			assert.Len(t, batch.Metrics, 1)
+		})
+	}))
+	defer server.Close()
+
+	transport := NewTransport(server.URL)
+	ctx := transports.WithBatchID(context.Background(), "batch1")
+	err := transport.SendMetricsWithContext(ctx, []model.Metric{metrics.NewGaugeMetric("TestGauge", 1.0)})
+	t.Run("send batch no error", func(t *testing.T) {
+		assert.Nil(t, err)
+	})
+}
+
+func TestHTTPTransportServerError(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	transport := NewTransport(server.URL)
+	mx := []model.Metric{metrics.NewGaugeMetric("TestGauge", 1.0)}
+	t.Run("server error is retryable", func(t *testing.T) {
+		err := transport.SendMetrics(mx)
+		assert.ErrorIs(t, err, transports.ErrTemporary)
+		assert.True(t, transports.IsRetryable(err))
+	})
+	t.Run("client error is not retryable", func(t *testing.T) {
+		status = http.StatusBadRequest
+		err := transport.SendMetrics(mx)
+		assert.Error(t, err)
+		assert.False(t, transports.IsRetryable(err))
+	})
+}
+
+func TestHTTPTransportPing(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/health", r.URL.Path)
+		if !healthy {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	transport := NewTransport(server.URL).(transports.Prober)
+	t.Run("healthy", func(t *testing.T) {
+		assert.NoError(t, transport.Ping(context.Background()))
+	})
+	t.Run("unhealthy", func(t *testing.T) {
+		healthy = false
+		assert.Error(t, transport.Ping(context.Background()))
+	})
+}
+
+func TestHTTPTransportPingWithoutHealthEndpoint(t *testing.T) {
+	reachable := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !reachable:
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		case r.URL.Path == "/ping":
+			http.Error(w, "DB connection is not configured", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	transport := NewTransport(server.URL).(transports.Prober)
+	assert.NoError(t, transport.Ping(context.Background()), "server without database should be alive")
+	reachable = false
+	assert.Error(t, transport.Ping(context.Background()))
+}
+
+func TestHTTPTransportProtobuf(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Run("protobuf batch", func(t *testing.T) {
+			assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+			bs, err := io.ReadAll(r.Body)
+			require.Nil(t, err)
+			var batch pb.Batch
+			err = proto.Unmarshal(bs, &batch)
+			require.Nil(t, err)
+			assert.Equal(t, "batch1", batch.Id)
+			require.Len(t, batch.Metrics, 2)
+			assert.Equal(t, "TestGauge", batch.Metrics[0].Id)
+			assert.Equal(t, 1.0, batch.Metrics[0].GetValue())
+			assert.Equal(t, int64(3), batch.Metrics[1].GetDelta())
+		})
+	}))
+	defer server.Close()
+
+	transport := NewTransport(server.URL, WithProtobuf())
+	ctx := transports.WithBatchID(context.Background(), "batch1")
+	err := transport.SendMetricsWithContext(ctx, []model.Metric{
+		metrics.NewGaugeMetric("TestGauge", 1.0),
+		metrics.NewCounterMetric("TestCounter", 3),
+	})
+	t.Run("send batch no error", func(t *testing.T) {
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,129 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// Fan-out modes. Every destination handles its errors itself (see Multi), mode defines which errors are returned, so
+// that agent reacts to them (e.g. interrupts report on network error)
+const (
+	ModeAll        = "all"         // errors of every failed destination are returned
+	ModeBestEffort = "best-effort" // errors are returned only if every destination fails, otherwise they are logged
+)
+
+// Destination is a named transport
+type Destination struct {
+	Name      string
+	Transport Transport
+}
+
+// DestinationErrors contains errors of failed destinations by their names. It matches (see errors.Is and errors.As)
+// any of them, e.g. it is retryable if error of any destination is
+type DestinationErrors map[string]error
+
+func (e DestinationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, name := range e.names() {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", name, e[name]))
+	}
+	return "could not send to " + strings.Join(msgs, "; ")
+}
+
+func (e DestinationErrors) Is(target error) bool {
+	for _, name := range e.names() {
+		if errors.Is(e[name], target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e DestinationErrors) As(target interface{}) bool {
+	for _, name := range e.names() {
+		if errors.As(e[name], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns sorted names of failed destinations
+func (e DestinationErrors) names() []string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Multi is a transport which sends metrics to every destination concurrently. Destinations fail independently, so
+// every one of them should handle its errors (e.g. track its own counter increments, retry or keep an outbox)
+type Multi struct {
+	ds   []Destination
+	mode string
+}
+
+// NewMulti creates fan-out transport with given mode (ModeAll or ModeBestEffort)
+func NewMulti(mode string, ds ...Destination) (Multi, error) {
+	if mode != ModeAll && mode != ModeBestEffort {
+		return Multi{}, fmt.Errorf("unknown fan-out mode: %s", mode)
+	}
+	return Multi{
+		ds:   ds,
+		mode: mode,
+	}, nil
+}
+
+func (m Multi) SendMetric(metric model.Metric) error {
+	return m.send(func(t Transport) error {
+		return t.SendMetric(metric)
+	})
+}
+
+func (m Multi) SendMetrics(mx []model.Metric) error {
+	return m.SendMetricsWithContext(context.Background(), mx)
+}
+
+func (m Multi) SendMetricsWithContext(ctx context.Context, mx []model.Metric) error {
+	return m.send(func(t Transport) error {
+		return t.SendMetricsWithContext(ctx, mx)
+	})
+}
+
+func (m Multi) send(send func(t Transport) error) error {
+	errs := make([]error, len(m.ds))
+	var wg sync.WaitGroup
+	for i, d := range m.ds {
+		wg.Add(1)
+		go func(i int, t Transport) {
+			defer wg.Done()
+			errs[i] = send(t)
+		}(i, d.Transport)
+	}
+	wg.Wait()
+
+	failed := make(DestinationErrors)
+	for i, err := range errs {
+		if err != nil {
+			failed[m.ds[i].Name] = err
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	if m.mode == ModeBestEffort && len(failed) < len(m.ds) {
+		log.Warn().Err(failed).Msg("metrics are not sent to some destinations")
+		return nil
+	}
+	return failed
+}
@@ -0,0 +1,64 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+func TestMulti(t *testing.T) {
+	mx := []model.Metric{cumulative{mType: model.GAUGE, value: 1.0}}
+	newMulti := func(t *testing.T, mode string) (Multi, *recording, *recording) {
+		staging, production := &recording{}, &recording{}
+		m, err := NewMulti(mode, Destination{Name: "staging", Transport: staging}, Destination{Name: "production", Transport: production})
+		require.NoError(t, err)
+		return m, staging, production
+	}
+
+	t.Run("sent to every destination", func(t *testing.T) {
+		m, staging, production := newMulti(t, ModeAll)
+		require.NoError(t, m.SendMetrics(mx))
+		assert.Len(t, staging.sent, 1)
+		assert.Len(t, production.sent, 1)
+	})
+	t.Run("all must succeed", func(t *testing.T) {
+		m, staging, production := newMulti(t, ModeAll)
+		staging.down = true
+		err := m.SendMetrics(mx)
+		var errs DestinationErrors
+		require.ErrorAs(t, err, &errs)
+		assert.Len(t, errs, 1)
+		assert.Contains(t, errs, "staging")
+		assert.Len(t, production.sent, 1, "other destinations are not affected")
+	})
+	t.Run("errors of destinations are matched", func(t *testing.T) {
+		m, err := NewMulti(ModeAll,
+			Destination{Name: "staging", Transport: transportFunc(func(_ context.Context, _ []model.Metric) error {
+				return &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+			})},
+			Destination{Name: "production", Transport: &recording{}},
+		)
+		require.NoError(t, err)
+		err = m.SendMetrics(mx)
+		var ne net.Error
+		assert.ErrorAs(t, err, &ne, "agent should interrupt report on network error")
+		assert.True(t, IsRetryable(err))
+	})
+	t.Run("best effort", func(t *testing.T) {
+		m, staging, production := newMulti(t, ModeBestEffort)
+		staging.down = true
+		assert.NoError(t, m.SendMetrics(mx))
+		production.down = true
+		assert.Error(t, m.SendMetrics(mx))
+	})
+	t.Run("unknown mode", func(t *testing.T) {
+		_, err := NewMulti("some")
+		assert.Error(t, err)
+	})
+}
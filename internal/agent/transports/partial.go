@@ -0,0 +1,20 @@
+package transports
+
+import (
+	"fmt"
+)
+
+// PartialError is returned by transports when only some of metrics are sent
+type PartialError struct {
+	Failed  []int // indexes of metrics which are not sent
+	Unknown []int // indexes of failed metrics which server may have saved (not acknowledged)
+	Err     error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d metrics are not sent: %v", len(e.Failed), e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
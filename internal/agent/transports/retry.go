@@ -0,0 +1,160 @@
+package transports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// ErrTemporary marks errors after which sending may succeed if retried (e.g. server responded with 5xx)
+var ErrTemporary = errors.New("temporary error")
+
+// IsRetryable returns whether sending failed with given error may be retried: on network errors and temporary errors
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrTemporary) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// RetryPolicy describes how many times and how often sending is retried
+type RetryPolicy struct {
+	MaxAttempts    int           `env:"RETRY_MAX_ATTEMPTS" json:"max_attempts,omitempty"`       // attempts including the first one (1 disables retries)
+	InitialBackoff time.Duration `env:"RETRY_INITIAL_BACKOFF" json:"initial_backoff,omitempty"` // delay before the first retry
+	MaxBackoff     time.Duration `env:"RETRY_MAX_BACKOFF" json:"max_backoff,omitempty"`         // delay limit
+	Multiplier     float64       `env:"RETRY_MULTIPLIER" json:"multiplier,omitempty"`           // delay grows by this factor after every retry
+	Jitter         float64       `env:"RETRY_JITTER" json:"jitter,omitempty"`                   // delay is randomly reduced by up to this fraction
+}
+
+// DefaultRetryPolicy is a policy with 3 attempts
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("retry max attempts must be positive")
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < p.InitialBackoff {
+		return fmt.Errorf("retry backoff must not be negative or exceed max backoff")
+	}
+	if p.Multiplier < 1 {
+		return fmt.Errorf("retry multiplier must not be less than 1")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be within [0, 1]")
+	}
+	return nil
+}
+
+// Backoff returns delay before given retry (starting from 1)
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry-1))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d -= d * p.Jitter * rand.Float64()
+	return time.Duration(d)
+}
+
+func (p *RetryPolicy) UnmarshalJSON(b []byte) error {
+	type policyAlias RetryPolicy
+
+	aliasValue := &struct {
+		*policyAlias
+		InitialBackoff string `json:"initial_backoff,omitempty"`
+		MaxBackoff     string `json:"max_backoff,omitempty"`
+	}{
+		policyAlias: (*policyAlias)(p),
+	}
+
+	err := json.Unmarshal(b, aliasValue)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal json: %w", err)
+	}
+
+	if len(aliasValue.InitialBackoff) > 0 {
+		p.InitialBackoff, err = time.ParseDuration(aliasValue.InitialBackoff)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
+	if len(aliasValue.MaxBackoff) > 0 {
+		p.MaxBackoff, err = time.ParseDuration(aliasValue.MaxBackoff)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Retrying is a transport which retries sending via underlying transport according to policy.
+// Every attempt carries the same batch identifier, so that server applies batch once
+type Retrying struct {
+	t      Transport
+	policy RetryPolicy
+}
+
+func NewRetrying(t Transport, policy RetryPolicy) Retrying {
+	return Retrying{
+		t:      t,
+		policy: policy,
+	}
+}
+
+func (r Retrying) SendMetric(metric model.Metric) error {
+	return r.retry(context.Background(), func(_ context.Context) error {
+		return r.t.SendMetric(metric)
+	})
+}
+
+func (r Retrying) SendMetrics(mx []model.Metric) error {
+	return r.SendMetricsWithContext(context.Background(), mx)
+}
+
+func (r Retrying) SendMetricsWithContext(ctx context.Context, mx []model.Metric) error {
+	if len(BatchID(ctx)) == 0 {
+		ctx = WithBatchID(ctx, NewBatchID())
+	}
+	return r.retry(ctx, func(ctx context.Context) error {
+		return r.t.SendMetricsWithContext(ctx, mx)
+	})
+}
+
+func (r Retrying) retry(ctx context.Context, send func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = send(ctx)
+		if err == nil || !IsRetryable(err) || attempt >= r.policy.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		backoff := r.policy.Backoff(attempt)
+		log.Warn().Err(err).Msgf("sending failed (attempt %d of %d), retrying in %s", attempt, r.policy.MaxAttempts, backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
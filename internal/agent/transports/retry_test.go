@@ -0,0 +1,94 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// failing is a transport failing with given errors in turn, then succeeding
+type failing struct {
+	errs     []error
+	batchIDs []string
+}
+
+func (f *failing) SendMetric(_ model.Metric) error {
+	return f.SendMetricsWithContext(context.Background(), nil)
+}
+
+func (f *failing) SendMetrics(mx []model.Metric) error {
+	return f.SendMetricsWithContext(context.Background(), mx)
+}
+
+func (f *failing) SendMetricsWithContext(ctx context.Context, _ []model.Metric) error {
+	f.batchIDs = append(f.batchIDs, BatchID(ctx))
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func TestRetrying(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+	temporary := fmt.Errorf("%w: response code: 503", ErrTemporary)
+
+	t.Run("retried until success", func(t *testing.T) {
+		f := &failing{errs: []error{temporary, temporary}}
+		err := NewRetrying(f, policy).SendMetrics(nil)
+		assert.NoError(t, err)
+		assert.Len(t, f.batchIDs, 3)
+		assert.NotEmpty(t, f.batchIDs[0])
+		assert.Equal(t, f.batchIDs[0], f.batchIDs[2], "every attempt carries the same batch id")
+	})
+	t.Run("attempts exhausted", func(t *testing.T) {
+		f := &failing{errs: []error{temporary, temporary, temporary}}
+		err := NewRetrying(f, policy).SendMetrics(nil)
+		assert.ErrorIs(t, err, ErrTemporary)
+		assert.Len(t, f.batchIDs, 3)
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		f := &failing{errs: []error{errors.New("response code: 400")}}
+		err := NewRetrying(f, policy).SendMetrics(nil)
+		assert.Error(t, err)
+		assert.Len(t, f.batchIDs, 1)
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		f := &failing{errs: []error{temporary, temporary}}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := NewRetrying(f, policy).SendMetricsWithContext(ctx, nil)
+		assert.ErrorIs(t, err, ErrTemporary)
+		assert.Len(t, f.batchIDs, 1)
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	assert.NoError(t, p.Validate())
+	assert.Equal(t, 100*time.Millisecond, p.Backoff(1))
+	assert.Equal(t, 400*time.Millisecond, p.Backoff(3))
+	assert.Equal(t, time.Second, p.Backoff(10))
+
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		b := p.Backoff(2)
+		assert.True(t, b > 100*time.Millisecond && b <= 200*time.Millisecond, "backoff %s is out of range", b)
+	}
+
+	p.Multiplier = 0.5
+	assert.Error(t, p.Validate())
+}
@@ -0,0 +1,165 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Envelope of hybrid encryption:
+//
+//	magic (4 bytes) | version (1 byte) | encrypted key length (2 bytes, big endian) | encrypted key | nonce | ciphertext
+//
+// Session key is a random AES-256 key encrypted with RSA-OAEP (SHA-256). Message is encrypted with AES-GCM, header
+// (magic and version) is authenticated as additional data
+const (
+	envelopeV1     byte = 1
+	sessionKeySize      = 32
+)
+
+var envelopeMagic = []byte("MTRC")
+
+var ErrUnknownEnvelope = errors.New("unknown envelope version")
+
+type hybridEncryptor struct {
+	key   *rsa.PublicKey
+	label []byte
+}
+
+// NewHybridEncryptor creates encryptor which encrypts message with random session key, encrypted with given RSA key
+func NewHybridEncryptor(key *rsa.PublicKey, label string) Encryptor {
+	return hybridEncryptor{
+		key:   key,
+		label: []byte(label),
+	}
+}
+
+func NewHybridEncryptorFromFile(publicKeyFile string, label string) (Encryptor, error) {
+	key, err := readPublicKey(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHybridEncryptor(key, label), nil
+}
+
+func (e hybridEncryptor) Encrypt(msg []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeySize)
+	if _, err := io.ReadFull(rand.Reader, sessionKey); err != nil {
+		return nil, fmt.Errorf("could not generate session key: %w", err)
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, e.key, sessionKey, e.label)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt session key: %w", err)
+	}
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+
+	header := append(append([]byte{}, envelopeMagic...), envelopeV1)
+	envelope := make([]byte, 0, len(header)+2+len(encryptedKey)+len(nonce)+len(msg)+gcm.Overhead())
+	envelope = append(envelope, header...)
+	keySize := make([]byte, 2)
+	binary.BigEndian.PutUint16(keySize, uint16(len(encryptedKey)))
+	envelope = append(envelope, keySize...)
+	envelope = append(envelope, encryptedKey...)
+	envelope = append(envelope, nonce...)
+	return gcm.Seal(envelope, nonce, msg, header), nil
+}
+
+type hybridDecryptor struct {
+	key    *rsa.PrivateKey
+	label  []byte
+	legacy Decryptor
+}
+
+// NewHybridDecryptor creates decryptor of messages encrypted by hybrid encryptor. Messages without envelope are
+// decrypted as encrypted by RSA encryptor (see NewRSAEncryptor), so that agents using it are still supported
+func NewHybridDecryptor(key *rsa.PrivateKey, label string) Decryptor {
+	return hybridDecryptor{
+		key:    key,
+		label:  []byte(label),
+		legacy: NewRSADecryptor(key, label),
+	}
+}
+
+func NewHybridDecryptorFromFile(privateKeyFile string, label string) (Decryptor, error) {
+	key, err := readPrivateKey(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHybridDecryptor(key, label), nil
+}
+
+func (d hybridDecryptor) Decrypt(msg []byte) ([]byte, error) {
+	if !bytes.HasPrefix(msg, envelopeMagic) {
+		return d.legacy.Decrypt(msg)
+	}
+	decrypted, err := d.open(msg)
+	if err != nil && len(msg)%d.key.Size() == 0 {
+		// legacy message may start with magic by chance
+		if legacyDecrypted, legacyErr := d.legacy.Decrypt(msg); legacyErr == nil {
+			return legacyDecrypted, nil
+		}
+	}
+	return decrypted, err
+}
+
+func (d hybridDecryptor) open(msg []byte) ([]byte, error) {
+	headerSize := len(envelopeMagic) + 1
+	if len(msg) < headerSize+2 {
+		return nil, fmt.Errorf("could not decrypt message: envelope is too short")
+	}
+	header := msg[:headerSize]
+	if version := header[len(envelopeMagic)]; version != envelopeV1 {
+		return nil, fmt.Errorf("could not decrypt message: %w: %d", ErrUnknownEnvelope, version)
+	}
+	rest := msg[headerSize:]
+	keySize := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < keySize {
+		return nil, fmt.Errorf("could not decrypt message: envelope is too short")
+	}
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, d.key, rest[:keySize], d.label)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt session key: %w", err)
+	}
+	rest = rest[keySize:]
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("could not decrypt message: envelope is too short")
+	}
+	decrypted, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt message: %w", err)
+	}
+	return decrypted, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHybrid(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	label := "test"
+	encryptor := NewHybridEncryptor(&privateKey.PublicKey, label)
+	decryptor := NewHybridDecryptor(privateKey, label)
+
+	message := []byte(`{"test": "test"}`)
+
+	t.Run("test correct ciphertext", func(t *testing.T) {
+		encrypted, err := encryptor.Encrypt(message)
+		require.NoError(t, err)
+		assert.Equal(t, envelopeMagic, encrypted[:len(envelopeMagic)])
+		assert.Equal(t, envelopeV1, encrypted[len(envelopeMagic)])
+
+		decrypted, err := decryptor.Decrypt(encrypted)
+		require.NoError(t, err)
+
+		assert.Equal(t, message, decrypted)
+	})
+
+	t.Run("test long message", func(t *testing.T) {
+		bs := make([]byte, 1<<20)
+		_, err := rand.Read(bs)
+		require.NoError(t, err)
+
+		encrypted, err := encryptor.Encrypt(bs)
+		require.NoError(t, err)
+		assert.Less(t, len(encrypted), len(bs)+512)
+
+		decrypted, err := decryptor.Decrypt(encrypted)
+		require.NoError(t, err)
+
+		assert.Equal(t, bs, decrypted)
+	})
+
+	t.Run("test legacy ciphertext", func(t *testing.T) {
+		encrypted, err := NewRSAEncryptor(&privateKey.PublicKey, label).Encrypt(message)
+		require.NoError(t, err)
+
+		decrypted, err := decryptor.Decrypt(encrypted)
+		require.NoError(t, err)
+
+		assert.Equal(t, message, decrypted)
+	})
+
+	t.Run("test tampered ciphertext", func(t *testing.T) {
+		encrypted, err := encryptor.Encrypt(message)
+		require.NoError(t, err)
+		encrypted[len(encrypted)-1] ^= 1
+
+		_, err = decryptor.Decrypt(encrypted)
+		require.Error(t, err)
+	})
+
+	t.Run("test unknown version", func(t *testing.T) {
+		encrypted, err := encryptor.Encrypt(message)
+		require.NoError(t, err)
+		encrypted[len(envelopeMagic)] = 2
+
+		_, err = decryptor.Decrypt(encrypted)
+		require.ErrorIs(t, err, ErrUnknownEnvelope)
+	})
+
+	t.Run("test incorrect ciphertext", func(t *testing.T) {
+		_, err := decryptor.Decrypt([]byte("wrong"))
+		require.Error(t, err)
+		_, err = decryptor.Decrypt(append(append([]byte{}, envelopeMagic...), envelopeV1, 1))
+		require.Error(t, err)
+	})
+}
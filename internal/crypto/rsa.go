@@ -16,6 +16,8 @@ type rsaEncryptor struct {
 	label []byte
 }
 
+// NewRSAEncryptor creates encryptor which encrypts message block-wise with RSA key. It is kept for compatibility,
+// use NewHybridEncryptor instead
 func NewRSAEncryptor(key *rsa.PublicKey, label string) Encryptor {
 	return rsaEncryptor{
 		key:   key,
@@ -24,20 +26,12 @@ func NewRSAEncryptor(key *rsa.PublicKey, label string) Encryptor {
 }
 
 func NewRSAEncryptorFromFile(publicKeyFile string, label string) (Encryptor, error) {
-	bs, err := os.ReadFile(publicKeyFile)
-	if err != nil {
-		return nil, fmt.Errorf("could not read public key file: %w", err)
-	}
-	publicPem, _ := pem.Decode(bs)
-	if publicPem == nil {
-		return nil, fmt.Errorf("could not decode PEM (public key)")
-	}
-	parsedKey, err := x509.ParsePKCS1PublicKey(publicPem.Bytes)
+	key, err := readPublicKey(publicKeyFile)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse public key: %w", err)
+		return nil, err
 	}
 
-	return NewRSAEncryptor(parsedKey, label), nil
+	return NewRSAEncryptor(key, label), nil
 }
 
 func (e rsaEncryptor) Encrypt(msg []byte) (encrypted []byte, err error) {
@@ -71,20 +65,12 @@ func NewRSADecryptor(key *rsa.PrivateKey, label string) Decryptor {
 }
 
 func NewRSADecryptorFromFile(privateKeyFile string, label string) (Decryptor, error) {
-	bs, err := os.ReadFile(privateKeyFile)
-	if err != nil {
-		return nil, fmt.Errorf("could not read private key file: %w", err)
-	}
-	privatePem, _ := pem.Decode(bs)
-	if privatePem == nil {
-		return nil, fmt.Errorf("could not decode PEM (private key)")
-	}
-	parsedKey, err := x509.ParsePKCS1PrivateKey(privatePem.Bytes)
+	key, err := readPrivateKey(privateKeyFile)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse private key: %w", err)
+		return nil, err
 	}
 
-	return NewRSADecryptor(parsedKey, label), nil
+	return NewRSADecryptor(key, label), nil
 }
 
 func (d rsaDecryptor) Decrypt(msg []byte) (decrypted []byte, err error) {
@@ -100,6 +86,38 @@ func (d rsaDecryptor) Decrypt(msg []byte) (decrypted []byte, err error) {
 	return
 }
 
+func readPublicKey(publicKeyFile string) (*rsa.PublicKey, error) {
+	bs, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read public key file: %w", err)
+	}
+	publicPem, _ := pem.Decode(bs)
+	if publicPem == nil {
+		return nil, fmt.Errorf("could not decode PEM (public key)")
+	}
+	parsedKey, err := x509.ParsePKCS1PublicKey(publicPem.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key: %w", err)
+	}
+	return parsedKey, nil
+}
+
+func readPrivateKey(privateKeyFile string) (*rsa.PrivateKey, error) {
+	bs, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read private key file: %w", err)
+	}
+	privatePem, _ := pem.Decode(bs)
+	if privatePem == nil {
+		return nil, fmt.Errorf("could not decode PEM (private key)")
+	}
+	parsedKey, err := x509.ParsePKCS1PrivateKey(privatePem.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %w", err)
+	}
+	return parsedKey, nil
+}
+
 func divide(s []byte, blockSize int) [][]byte {
 	var divided [][]byte
 	for i := 0; i < len(s); i += blockSize {
@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issuer is a certificate which signs other certificates
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue creates certificate signed by issuer (self-signed if issuer is nil), saves it and its key to dir and returns
+// paths to files
+func issue(t *testing.T, dir string, name string, parent *issuer) (certFile string, keyFile string, self *issuer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer := &issuer{cert: template, key: key}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certFile, keyFile, &issuer{cert: template, key: key}
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	caFile, _, ca := issue(t, dir, "ca", nil)
+	serverCert, serverKey, _ := issue(t, dir, "server", ca)
+	clientCert, clientKey, _ := issue(t, dir, "client", ca)
+	otherCAFile, _, otherCA := issue(t, dir, "other-ca", nil)
+	otherCert, otherKey, _ := issue(t, dir, "other", otherCA)
+
+	serve := func(t *testing.T, clientCAFile string) *httptest.Server {
+		config, err := NewServerTLSConfig(serverCert, serverKey, clientCAFile)
+		require.NoError(t, err)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = config
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		return server
+	}
+	get := func(t *testing.T, url string, caFile string, certFile string, keyFile string) error {
+		config, err := NewClientTLSConfig(caFile, certFile, keyFile)
+		require.NoError(t, err)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	t.Run("tls", func(t *testing.T) {
+		server := serve(t, "")
+		assert.NoError(t, get(t, server.URL, caFile, "", ""))
+		assert.Error(t, get(t, server.URL, otherCAFile, "", ""), "server certificate should not be trusted")
+	})
+
+	t.Run("mutual tls", func(t *testing.T) {
+		server := serve(t, caFile)
+		assert.NoError(t, get(t, server.URL, caFile, clientCert, clientKey))
+		assert.Error(t, get(t, server.URL, caFile, "", ""), "client without certificate should be rejected")
+		assert.Error(t, get(t, server.URL, caFile, otherCert, otherKey), "client certificate should not be trusted")
+	})
+
+	t.Run("bad files", func(t *testing.T) {
+		_, err := NewServerTLSConfig(serverCert, clientKey, "")
+		assert.Error(t, err)
+		_, err = NewServerTLSConfig(serverCert, serverKey, serverKey)
+		assert.Error(t, err)
+		_, err = NewClientTLSConfig(filepath.Join(dir, "missing.crt"), "", "")
+		assert.Error(t, err)
+	})
+}
@@ -2,16 +2,54 @@
 package dto
 
 import (
+	"time"
+
 	"github.com/tony-spark/metrico/internal/model"
 )
 
 // Metric is a DTO with metric's data
 type Metric struct {
-	ID    string   `json:"id"`                          // metric's ID
-	MType string   `json:"type" enums:"gauge,counter"`  // type of metric ("gauge" or "counter)
-	Delta *int64   `json:"delta,omitempty"`             // value of counter metric
-	Value *float64 `json:"value,omitempty"`             // value of gauge metric
-	Hash  string   `json:"hash,omitempty" format:"HEX"` // object hash
+	ID        string           `json:"id"`                                   // metric's ID
+	MType     string           `json:"type" enums:"gauge,counter,histogram"` // type of metric ("gauge", "counter" or "histogram")
+	Labels    model.Labels     `json:"labels,omitempty"`                     // metric's labels
+	Delta     *int64           `json:"delta,omitempty"`                      // value of counter metric
+	Value     *float64         `json:"value,omitempty"`                      // value of gauge metric
+	Histogram *model.Histogram `json:"histogram,omitempty"`                  // observations of histogram metric
+	Hash      string           `json:"hash,omitempty" format:"HEX"`          // object hash
+	UpdatedAt *time.Time       `json:"updated_at,omitempty"`                 // when metric was last updated (server responses only)
+	Stale     bool             `json:"stale,omitempty"`                      // whether metric was not updated for too long (server responses only)
+}
+
+// Batch is a DTO with metrics sent together. Batch with the same ID is applied by server only once
+type Batch struct {
+	ID      string   `json:"id" example:"5f1d7a3c9e0b4d2a8c6e1f3b7a9d0c2e"` // batch identifier, unique per sending attempt
+	Metrics []Metric `json:"metrics"`                                       // metrics of batch
+}
+
+// RangeResult is a DTO with metric's history aggregated into time buckets
+type RangeResult struct {
+	ID          string       `json:"id"`                                            // metric's ID
+	MType       string       `json:"type" enums:"gauge,counter"`                    // type of metric
+	Labels      model.Labels `json:"labels,omitempty"`                              // metric's labels
+	Aggregation string       `json:"aggregation" enums:"min,max,avg,last,sum,rate"` // aggregation of values within bucket
+	Step        string       `json:"step" example:"1m0s"`                           // bucket size
+	Points      []Point      `json:"points"`                                        // aggregated values
+}
+
+// Point is a DTO with aggregated value of time bucket
+type Point struct {
+	Timestamp time.Time `json:"ts"`    // start of time bucket
+	Value     float64   `json:"value"` // aggregated value
+}
+
+// Source is a DTO with summary of metrics reported by agent
+type Source struct {
+	Source     string    `json:"source"`     // agent identifier (empty for metrics reported without it)
+	Gauges     int       `json:"gauges"`     // number of gauges
+	Counters   int       `json:"counters"`   // number of counters
+	Histograms int       `json:"histograms"` // number of histograms
+	LastSeen   time.Time `json:"last_seen"`  // when any metric of source was last updated
+	Stale      bool      `json:"stale"`      // whether every metric of source is stale (agent is down)
 }
 
 // Hasher implementation is used to calculate and check DTO's hash
@@ -29,6 +67,8 @@ func (m Metric) HasValue() bool {
 		return m.Value != nil
 	case model.COUNTER:
 		return m.Delta != nil
+	case model.HISTOGRAM:
+		return m.Histogram != nil
 	}
 	return false
 }
@@ -36,10 +76,11 @@ func (m Metric) HasValue() bool {
 // NewMetric creates a DTO from model object
 func NewMetric(m model.Metric) *Metric {
 	mdto := &Metric{
-		ID:    m.ID(),
-		MType: m.Type(),
-		Delta: nil,
-		Value: nil,
+		ID:     m.ID(),
+		MType:  m.Type(),
+		Labels: m.Labels().Copy(),
+		Delta:  nil,
+		Value:  nil,
 	}
 
 	switch m.Type() {
@@ -51,6 +92,9 @@ func NewMetric(m model.Metric) *Metric {
 		var d int64
 		d = m.Val().(int64)
 		mdto.Delta = &d
+	case model.HISTOGRAM:
+		h := m.Val().(model.Histogram).Copy()
+		mdto.Histogram = &h
 	}
 
 	return mdto
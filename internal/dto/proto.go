@@ -0,0 +1,97 @@
+package dto
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	pb "github.com/tony-spark/metrico/gen/pb/api"
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// FromProto creates a DTO from protobuf message
+func FromProto(m *pb.Metric) Metric {
+	mdto := Metric{
+		ID:     m.GetId(),
+		MType:  strings.ToLower(m.GetType().String()),
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Hash:   hex.EncodeToString(m.GetHash()),
+		Labels: model.Labels(m.GetLabels()).Copy(),
+	}
+	if h := m.GetHistogram(); h != nil {
+		mdto.Histogram = &model.Histogram{
+			Bounds: h.GetBounds(),
+			Counts: h.GetCounts(),
+			Count:  h.GetCount(),
+			Sum:    h.GetSum(),
+		}
+	}
+	return mdto
+}
+
+// Proto creates protobuf message from DTO
+func (m Metric) Proto() (*pb.Metric, error) {
+	var mt pb.MetricType
+	switch m.MType {
+	case model.GAUGE:
+		mt = pb.MetricType_GAUGE
+	case model.COUNTER:
+		mt = pb.MetricType_COUNTER
+	case model.HISTOGRAM:
+		mt = pb.MetricType_HISTOGRAM
+	default:
+		return nil, fmt.Errorf("unknown metric type: %s", m.MType)
+	}
+	hash, err := hex.DecodeString(m.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode hash: %w", err)
+	}
+	pm := &pb.Metric{
+		Id:     m.ID,
+		Type:   mt,
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Labels: m.Labels,
+	}
+	if len(hash) > 0 {
+		pm.Hash = hash
+	}
+	if m.Histogram != nil {
+		pm.Histogram = &pb.Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Count:  m.Histogram.Count,
+			Sum:    m.Histogram.Sum,
+		}
+	}
+	return pm, nil
+}
+
+// BatchFromProto creates a DTO from protobuf message
+func BatchFromProto(b *pb.Batch) Batch {
+	batch := Batch{
+		ID:      b.GetId(),
+		Metrics: make([]Metric, 0, len(b.GetMetrics())),
+	}
+	for _, m := range b.GetMetrics() {
+		batch.Metrics = append(batch.Metrics, FromProto(m))
+	}
+	return batch
+}
+
+// Proto creates protobuf message from DTO
+func (b Batch) Proto() (*pb.Batch, error) {
+	pbatch := &pb.Batch{
+		Id:      b.ID,
+		Metrics: make([]*pb.Metric, 0, len(b.Metrics)),
+	}
+	for _, m := range b.Metrics {
+		pm, err := m.Proto()
+		if err != nil {
+			return nil, fmt.Errorf("could not convert metric %s: %w", m.ID, err)
+		}
+		pbatch.Metrics = append(pbatch.Metrics, pm)
+	}
+	return pbatch, nil
+}
@@ -44,17 +44,34 @@ func (s Sha256Hmac) Check(m dto.Metric) (bool, error) {
 	return bytes.Equal(calc, orig), nil
 }
 
-func hashBin(m dto.Metric, key string) ([]byte, error) {
-	var repr string
+// Repr returns representation of metric which is hashed, so that it can be compared with client's one when hash check
+// fails
+func (s Sha256Hmac) Repr(m dto.Metric) (string, error) {
+	return repr(m)
+}
+
+func repr(m dto.Metric) (string, error) {
+	if !m.HasValue() {
+		return "", fmt.Errorf("could not calculate hash for metric %s without value", m.ID)
+	}
+	// labels are hashed in canonical form, unlabeled metrics are hashed as before labels were introduced
 	switch m.MType {
 	case model.COUNTER:
-		repr = fmt.Sprintf("%s:counter:%d", m.ID, *m.Delta)
+		return fmt.Sprintf("%s%s:counter:%d", m.ID, m.Labels, *m.Delta), nil
 	case model.GAUGE:
-		repr = fmt.Sprintf("%s:gauge:%f", m.ID, *m.Value)
-	default:
-		return nil, fmt.Errorf("coulnd not calculate hash for unknown metric type: %s", m.MType)
+		return fmt.Sprintf("%s%s:gauge:%f", m.ID, m.Labels, *m.Value), nil
+	case model.HISTOGRAM:
+		return fmt.Sprintf("%s%s:histogram:%v:%v:%d:%f", m.ID, m.Labels, m.Histogram.Bounds, m.Histogram.Counts, m.Histogram.Count, m.Histogram.Sum), nil
+	}
+	return "", fmt.Errorf("coulnd not calculate hash for unknown metric type: %s", m.MType)
+}
+
+func hashBin(m dto.Metric, key string) ([]byte, error) {
+	r, err := repr(m)
+	if err != nil {
+		return nil, err
 	}
 	h := hmac.New(sha256.New, []byte(key))
-	h.Write([]byte(repr))
+	h.Write([]byte(r))
 	return h.Sum(nil), nil
 }
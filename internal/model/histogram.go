@@ -0,0 +1,139 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var ErrInvalidHistogram = errors.New("invalid histogram")
+
+// Histogram is a value of histogram metric: distribution of observations over buckets, their count and sum
+type Histogram struct {
+	Bounds []float64 `json:"bounds"` // ascending upper bounds of buckets (inclusive), the last bucket (+Inf) is implicit
+	Counts []int64   `json:"counts"` // number of observations in each bucket, one more than bounds
+	Count  int64     `json:"count"`  // total number of observations
+	Sum    float64   `json:"sum"`    // sum of observations
+}
+
+// NewHistogram returns empty histogram with given bucket bounds
+func NewHistogram(bounds []float64) Histogram {
+	bs := make([]float64, len(bounds))
+	copy(bs, bounds)
+	return Histogram{
+		Bounds: bs,
+		Counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// Observe adds observation to histogram
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(h.Bounds) && v > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += v
+}
+
+// Validate checks whether histogram is consistent
+func (h Histogram) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%w: %d bounds require %d counts, got %d", ErrInvalidHistogram, len(h.Bounds), len(h.Bounds)+1, len(h.Counts))
+	}
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("%w: bound %v is not finite", ErrInvalidHistogram, b)
+		}
+		if i > 0 && b <= h.Bounds[i-1] {
+			return fmt.Errorf("%w: bounds are not ascending", ErrInvalidHistogram)
+		}
+	}
+	var count int64
+	for _, c := range h.Counts {
+		if c < 0 {
+			return fmt.Errorf("%w: negative count", ErrInvalidHistogram)
+		}
+		count += c
+	}
+	if count != h.Count {
+		return fmt.Errorf("%w: total count %d does not match bucket counts %d", ErrInvalidHistogram, h.Count, count)
+	}
+	return nil
+}
+
+// SameBuckets returns whether histograms have the same bucket bounds
+func (h Histogram) SameBuckets(o Histogram) bool {
+	if len(h.Bounds) != len(o.Bounds) {
+		return false
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != o.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Add returns histogram with observations of both histograms. If bucket bounds differ, o is returned
+// (as if histogram was reset)
+func (h Histogram) Add(o Histogram) Histogram {
+	if !h.SameBuckets(o) {
+		return o.Copy()
+	}
+	r := h.Copy()
+	for i := range r.Counts {
+		r.Counts[i] += o.Counts[i]
+	}
+	r.Count += o.Count
+	r.Sum += o.Sum
+	return r
+}
+
+// Sub returns histogram with observations of h which are not in o (o is an earlier snapshot of h). If bucket bounds
+// differ or h has less observations than o, h is returned (as if histogram was reset)
+func (h Histogram) Sub(o Histogram) Histogram {
+	if !h.SameBuckets(o) || h.Count < o.Count {
+		return h.Copy()
+	}
+	r := h.Copy()
+	for i := range r.Counts {
+		r.Counts[i] -= o.Counts[i]
+	}
+	r.Count -= o.Count
+	r.Sum -= o.Sum
+	return r
+}
+
+// Copy returns a deep copy of histogram
+func (h Histogram) Copy() Histogram {
+	c := Histogram{
+		Bounds: make([]float64, len(h.Bounds)),
+		Counts: make([]int64, len(h.Counts)),
+		Count:  h.Count,
+		Sum:    h.Sum,
+	}
+	copy(c.Bounds, h.Bounds)
+	copy(c.Counts, h.Counts)
+	return c
+}
+
+// String returns histogram in form count=3 sum=1.5 buckets=[0.1:1 1:2 +Inf:0]
+func (h Histogram) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "count=%d sum=%v buckets=[", h.Count, h.Sum)
+	for i, c := range h.Counts {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		if i < len(h.Bounds) {
+			fmt.Fprintf(&sb, "%v:%d", h.Bounds[i], c)
+		} else {
+			fmt.Fprintf(&sb, "+Inf:%d", c)
+		}
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
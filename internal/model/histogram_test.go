@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	t.Run("observe", func(t *testing.T) {
+		h := NewHistogram([]float64{0.1, 1})
+		for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+			h.Observe(v)
+		}
+		assert.Equal(t, []int64{2, 1, 1}, h.Counts)
+		assert.Equal(t, int64(4), h.Count)
+		assert.InDelta(t, 2.65, h.Sum, 1e-9)
+		assert.NoError(t, h.Validate())
+		assert.Equal(t, "count=4 sum=2.65 buckets=[0.1:2 1:1 +Inf:1]", h.String())
+	})
+	t.Run("add", func(t *testing.T) {
+		h1 := Histogram{Bounds: []float64{1}, Counts: []int64{1, 2}, Count: 3, Sum: 5}
+		h2 := Histogram{Bounds: []float64{1}, Counts: []int64{3, 0}, Count: 3, Sum: 1.5}
+		assert.Equal(t, Histogram{Bounds: []float64{1}, Counts: []int64{4, 2}, Count: 6, Sum: 6.5}, h1.Add(h2))
+		assert.Equal(t, []int64{1, 2}, h1.Counts)
+	})
+	t.Run("add with other buckets resets", func(t *testing.T) {
+		h1 := Histogram{Bounds: []float64{1}, Counts: []int64{1, 2}, Count: 3, Sum: 5}
+		h2 := Histogram{Bounds: []float64{2}, Counts: []int64{1, 0}, Count: 1, Sum: 1}
+		assert.Equal(t, h2, h1.Add(h2))
+	})
+	t.Run("sub", func(t *testing.T) {
+		h1 := Histogram{Bounds: []float64{1}, Counts: []int64{4, 2}, Count: 6, Sum: 6.5}
+		h2 := Histogram{Bounds: []float64{1}, Counts: []int64{3, 0}, Count: 3, Sum: 1.5}
+		assert.Equal(t, Histogram{Bounds: []float64{1}, Counts: []int64{1, 2}, Count: 3, Sum: 5}, h1.Sub(h2))
+		assert.Equal(t, h2, h2.Sub(h1), "histogram was reset")
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, h := range []Histogram{
+			{Bounds: []float64{1}, Counts: []int64{1}, Count: 1},
+			{Bounds: []float64{2, 1}, Counts: []int64{0, 0, 0}},
+			{Bounds: []float64{1}, Counts: []int64{1, -1}},
+			{Bounds: []float64{1}, Counts: []int64{1, 1}, Count: 3},
+		} {
+			assert.ErrorIs(t, h.Validate(), ErrInvalidHistogram)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SourceLabel is a label holding identifier of agent which reported metric
+const SourceLabel = "source"
+
+// Labels is a set of metric's key/value labels. Metric is identified by its ID, type and labels
+type Labels map[string]string
+
+// String returns labels in canonical form (keys are sorted, values are quoted), e.g. {host="a",region="b"}.
+// Empty labels are represented by empty string
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(l[k]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// Matches returns whether labels contain every key/value pair of filter
+func (l Labels) Matches(filter Labels) bool {
+	for k, v := range filter {
+		if lv, ok := l[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy returns a copy of labels (nil if there are no labels)
+func (l Labels) Copy() Labels {
+	if len(l) == 0 {
+		return nil
+	}
+	c := make(Labels, len(l))
+	for k, v := range l {
+		c[k] = v
+	}
+	return c
+}
+
+// With returns a copy of labels with key set to value
+func (l Labels) With(key string, value string) Labels {
+	c := make(Labels, len(l)+1)
+	for k, v := range l {
+		c[k] = v
+	}
+	c[key] = value
+	return c
+}
+
+// Without returns a copy of labels without key (nil if there are no other labels)
+func (l Labels) Without(key string) Labels {
+	c := l.Copy()
+	delete(c, key)
+	return c.Copy()
+}
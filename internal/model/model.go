@@ -7,8 +7,9 @@ import (
 
 // Metric types
 const (
-	COUNTER string = "counter" // counter metric has int64 value
-	GAUGE   string = "gauge"   // gauge metric has float64 value
+	COUNTER   string = "counter"   // counter metric has int64 value
+	GAUGE     string = "gauge"     // gauge metric has float64 value
+	HISTOGRAM string = "histogram" // histogram metric has Histogram value
 )
 
 // Metric is a main model interface
@@ -20,4 +21,6 @@ type Metric interface {
 	Type() string
 	// Val returns metric's value
 	Val() interface{}
+	// Labels returns metric's labels (nil if metric has no labels)
+	Labels() Labels
 }
@@ -0,0 +1,164 @@
+// Package access contains client access policy shared by HTTP and gRPC controllers: which client addresses are allowed
+// and which proxies are trusted to report client address
+package access
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DefaultProxyHeader is a header (metadata key for gRPC) trusted proxies report client address chain in
+const DefaultProxyHeader = "X-Forwarded-For"
+
+var (
+	ErrUnknownClient = errors.New("unknown client's IP")
+	ErrDenied        = errors.New("client's IP is not allowed")
+)
+
+// Policy allows clients by their addresses: denied subnets take precedence over allowed ones, and if no allowed
+// subnets are set, every client not denied is allowed.
+//
+// Client address is the peer address, unless peer is a trusted proxy. Then proxy header chain (e.g.
+// "client, proxy1, proxy2") is walked from the right, skipping trusted proxies, and the first untrusted address is
+// taken as client's one. Proxy header is never trusted if peer is not a trusted proxy
+type Policy struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+	proxies []*net.IPNet
+	header  string
+}
+
+// Option represents option function for policy configuration
+type Option func(p *Policy)
+
+// WithAllowed configures policy to allow only clients within subnets
+func WithAllowed(subnets ...*net.IPNet) Option {
+	return func(p *Policy) {
+		p.allowed = append(p.allowed, subnets...)
+	}
+}
+
+// WithDenied configures policy to deny clients within subnets
+func WithDenied(subnets ...*net.IPNet) Option {
+	return func(p *Policy) {
+		p.denied = append(p.denied, subnets...)
+	}
+}
+
+// WithTrustedProxies configures policy to take client address from proxy header if peer is within subnets
+func WithTrustedProxies(subnets ...*net.IPNet) Option {
+	return func(p *Policy) {
+		p.proxies = append(p.proxies, subnets...)
+	}
+}
+
+// WithProxyHeader configures header trusted proxies report client address in (DefaultProxyHeader by default)
+func WithProxyHeader(header string) Option {
+	return func(p *Policy) {
+		if len(header) > 0 {
+			p.header = header
+		}
+	}
+}
+
+func NewPolicy(options ...Option) Policy {
+	p := Policy{
+		header: DefaultProxyHeader,
+	}
+	for _, opt := range options {
+		opt(&p)
+	}
+	return p
+}
+
+// Restricts returns whether policy denies any client
+func (p Policy) Restricts() bool {
+	return len(p.allowed) > 0 || len(p.denied) > 0
+}
+
+// TrustsProxies returns whether policy takes client address from proxy header
+func (p Policy) TrustsProxies() bool {
+	return len(p.proxies) > 0
+}
+
+// Header returns header trusted proxies report client address in
+func (p Policy) Header() string {
+	return p.header
+}
+
+// ClientIP returns address of client connected from peer with given proxy header values (nil if it is unknown)
+func (p Policy) ClientIP(peer net.IP, forwarded []string) net.IP {
+	if peer == nil || !contains(p.proxies, peer) {
+		return peer
+	}
+	var chain []string
+	for _, v := range forwarded {
+		chain = append(chain, strings.Split(v, ",")...)
+	}
+	clientIP := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		clientIP = net.ParseIP(strings.TrimSpace(chain[i]))
+		if clientIP == nil {
+			// chain is malformed, so that client can not be told apart from proxies
+			return nil
+		}
+		if !contains(p.proxies, clientIP) {
+			break
+		}
+	}
+	return clientIP
+}
+
+// Check returns error if client with given address (nil if unknown) is not allowed
+func (p Policy) Check(clientIP net.IP) error {
+	if !p.Restricts() {
+		return nil
+	}
+	if clientIP == nil {
+		return ErrUnknownClient
+	}
+	if contains(p.denied, clientIP) || (len(p.allowed) > 0 && !contains(p.allowed, clientIP)) {
+		return fmt.Errorf("%w: %s", ErrDenied, clientIP)
+	}
+	return nil
+}
+
+// ParseSubnets parses subnets in CIDR notation, single addresses are parsed as subnets of one address
+func ParseSubnets(ss []string) ([]*net.IPNet, error) {
+	subnets := make([]*net.IPNet, 0, len(ss))
+	for _, s := range ss {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("could not parse address %s", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			subnets = append(subnets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse subnet: %w", err)
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+func contains(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package access
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	subnets := func(ss ...string) []*net.IPNet {
+		parsed, err := ParseSubnets(ss)
+		require.NoError(t, err)
+		return parsed
+	}
+	ip := net.ParseIP
+
+	t.Run("denied subnets take precedence over allowed ones", func(t *testing.T) {
+		p := NewPolicy(
+			WithAllowed(subnets("10.0.0.0/8", "192.168.1.0/24")...),
+			WithDenied(subnets("10.0.13.0/24")...),
+		)
+		assert.NoError(t, p.Check(ip("10.1.2.3")))
+		assert.NoError(t, p.Check(ip("192.168.1.7")))
+		assert.True(t, errors.Is(p.Check(ip("10.0.13.1")), ErrDenied))
+		assert.True(t, errors.Is(p.Check(ip("172.16.0.1")), ErrDenied))
+		assert.True(t, errors.Is(p.Check(nil), ErrUnknownClient))
+	})
+
+	t.Run("every client not denied is allowed without allowed subnets", func(t *testing.T) {
+		p := NewPolicy(WithDenied(subnets("203.0.113.5", "2001:db8::/32")...))
+		assert.NoError(t, p.Check(ip("203.0.113.6")))
+		assert.Error(t, p.Check(ip("203.0.113.5")))
+		assert.Error(t, p.Check(ip("2001:db8::1")))
+	})
+
+	t.Run("every client is allowed by empty policy", func(t *testing.T) {
+		p := NewPolicy()
+		assert.False(t, p.Restricts())
+		assert.NoError(t, p.Check(nil))
+	})
+
+	t.Run("proxy header is trusted only from trusted proxies", func(t *testing.T) {
+		p := NewPolicy(WithTrustedProxies(subnets("10.0.0.0/24", "10.0.1.1")...))
+		chain := []string{"1.1.1.1, 192.168.1.10", "10.0.1.1"}
+
+		assert.Equal(t, ip("192.168.1.10"), p.ClientIP(ip("10.0.0.2"), chain), "trusted proxies should be skipped")
+		assert.Equal(t, ip("172.16.0.1"), p.ClientIP(ip("172.16.0.1"), chain), "header should be ignored")
+		assert.Equal(t, ip("10.0.0.2"), p.ClientIP(ip("10.0.0.2"), nil))
+		assert.Equal(t, ip("10.0.1.1"), p.ClientIP(ip("10.0.0.2"), []string{"10.0.1.1"}))
+		assert.Nil(t, p.ClientIP(ip("10.0.0.2"), []string{"unknown, 10.0.1.1"}))
+		assert.Nil(t, p.ClientIP(nil, chain))
+	})
+
+	t.Run("invalid subnets are not parsed", func(t *testing.T) {
+		_, err := ParseSubnets([]string{"10.0.0.0/33"})
+		assert.Error(t, err)
+		_, err = ParseSubnets([]string{"localhost"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,375 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// Alert states
+const (
+	StatePending  = "pending"  // condition holds, but not long enough
+	StateFiring   = "firing"   // condition holds for rule's For duration
+	StateResolved = "resolved" // condition does not hold anymore after alert fired
+)
+
+const (
+	defaultEvaluationInterval = 10 * time.Second
+	defaultResolvedRetention  = 15 * time.Minute
+	defaultStaleAfter         = 5 * time.Minute
+	notificationQueueSize     = 100
+)
+
+// Alert is a state of rule evaluated on metric series
+type Alert struct {
+	Rule        string       `json:"rule"`
+	Condition   string       `json:"condition"`
+	Metric      string       `json:"metric"`
+	Type        string       `json:"type"`
+	Labels      model.Labels `json:"labels,omitempty"`
+	State       string       `json:"state" enums:"pending,firing,resolved"`
+	Value       float64      `json:"value"`                 // value of rule function at last evaluation
+	ActiveSince time.Time    `json:"active_since"`          // when condition started to hold
+	FiredAt     *time.Time   `json:"fired_at,omitempty"`    // when alert fired
+	ResolvedAt  *time.Time   `json:"resolved_at,omitempty"` // when alert was resolved
+}
+
+// Notifier sends notifications on alerts firing and resolving
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+type sample struct {
+	ts    time.Time
+	delta int64
+}
+
+type series struct {
+	metric    string
+	mType     string
+	labels    model.Labels
+	firstSeen time.Time
+	updatedAt time.Time
+	value     float64  // last gauge value
+	samples   []sample // counter increments within the longest window
+}
+
+// Engine evaluates rules on every metric update and periodically, alerts are kept in memory
+type Engine struct {
+	rules             []Rule
+	notifiers         []Notifier
+	interval          time.Duration
+	resolvedRetention time.Duration
+	staleAfter        time.Duration
+	maxWindow         time.Duration
+	now               func() time.Time
+	mu                *sync.Mutex
+	series            map[string]*series
+	alerts            map[string]*Alert
+	notifications     chan Alert
+}
+
+// Option represents option function for engine configuration
+type Option func(e *Engine)
+
+// WithNotifiers configures engine to send notifications via given notifiers
+func WithNotifiers(ns ...Notifier) Option {
+	return func(e *Engine) {
+		e.notifiers = append(e.notifiers, ns...)
+	}
+}
+
+// WithEvaluationInterval configures engine to evaluate all rules at a given interval
+func WithEvaluationInterval(interval time.Duration) Option {
+	return func(e *Engine) {
+		if interval > 0 {
+			e.interval = interval
+		}
+	}
+}
+
+// WithResolvedRetention configures how long resolved alerts are kept
+func WithResolvedRetention(retention time.Duration) Option {
+	return func(e *Engine) {
+		e.resolvedRetention = retention
+	}
+}
+
+// WithStaleAfter configures engine to drop series not updated for a given duration (or for the longest rule window if
+// it is longer), alerts of dropped series are resolved
+func WithStaleAfter(d time.Duration) Option {
+	return func(e *Engine) {
+		if d > 0 {
+			e.staleAfter = d
+		}
+	}
+}
+
+// NewEngine creates engine for given rules, error is returned if some rule is invalid
+func NewEngine(rules []Rule, options ...Option) (*Engine, error) {
+	e := &Engine{
+		rules:             make([]Rule, len(rules)),
+		interval:          defaultEvaluationInterval,
+		resolvedRetention: defaultResolvedRetention,
+		staleAfter:        defaultStaleAfter,
+		now:               time.Now,
+		mu:                new(sync.Mutex),
+		series:            make(map[string]*series),
+		alerts:            make(map[string]*Alert),
+		notifications:     make(chan Alert, notificationQueueSize),
+	}
+	copy(e.rules, rules)
+	names := make(map[string]bool)
+	for i := range e.rules {
+		if err := e.rules[i].Validate(); err != nil {
+			return nil, err
+		}
+		if names[e.rules[i].Name] {
+			return nil, fmt.Errorf("%w: duplicate rule name %s", ErrInvalidRule, e.rules[i].Name)
+		}
+		names[e.rules[i].Name] = true
+		if e.rules[i].Window > e.maxWindow {
+			e.maxWindow = e.rules[i].Window
+		}
+	}
+
+	for _, opt := range options {
+		opt(e)
+	}
+
+	return e, nil
+}
+
+// Observe records metric update (counters carry increment) and evaluates rules on updated series
+func (e *Engine) Observe(m model.Metric) {
+	if !e.watched(m) {
+		return
+	}
+	var s *series
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	switch m.Type() {
+	case model.GAUGE:
+		s = e.getSeries(m, now)
+		s.value = m.Val().(float64)
+	case model.COUNTER:
+		s = e.getSeries(m, now)
+		s.samples = append(s.samples, sample{ts: now, delta: m.Val().(int64)})
+	default:
+		return
+	}
+	s.updatedAt = now
+	for _, r := range e.rules {
+		if matches(r, s) {
+			e.evaluate(r, s, now)
+		}
+	}
+}
+
+// Evaluate evaluates every rule on every matching series. Stale series (see WithStaleAfter) are dropped and their
+// alerts are resolved
+func (e *Engine) Evaluate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	staleAfter := e.staleAfter
+	if e.maxWindow > staleAfter {
+		staleAfter = e.maxWindow
+	}
+	for key, s := range e.series {
+		if now.Sub(s.updatedAt) > staleAfter {
+			log.Info().Msgf("dropping stale series %s%s", s.metric, s.labels)
+			for _, r := range e.rules {
+				if matches(r, s) {
+					e.resolve(r.Name+s.labels.String(), now)
+				}
+			}
+			delete(e.series, key)
+			continue
+		}
+		s.prune(now.Add(-e.maxWindow))
+		for _, r := range e.rules {
+			if matches(r, s) {
+				e.evaluate(r, s, now)
+			}
+		}
+	}
+	for key, a := range e.alerts {
+		if a.State == StateResolved && now.Sub(*a.ResolvedAt) > e.resolvedRetention {
+			delete(e.alerts, key)
+		}
+	}
+}
+
+// Alerts returns current alerts (including resolved recently) sorted by rule and labels
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	as := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		as = append(as, *a)
+	}
+	sort.Slice(as, func(i, j int) bool {
+		if as[i].Rule != as[j].Rule {
+			return as[i].Rule < as[j].Rule
+		}
+		return as[i].Labels.String() < as[j].Labels.String()
+	})
+	return as
+}
+
+// Run evaluates rules periodically and sends notifications until context is done
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.Evaluate()
+		case a := <-e.notifications:
+			for _, n := range e.notifiers {
+				if err := n.Notify(ctx, a); err != nil {
+					log.Error().Err(err).Msgf("could not send notification on alert %s", a.Rule)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watched returns whether some rule is defined for metric
+func (e *Engine) watched(m model.Metric) bool {
+	for _, r := range e.rules {
+		if r.Metric == m.ID() && r.Type == m.Type() {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) getSeries(m model.Metric, now time.Time) *series {
+	key := m.Type() + ":" + m.ID() + m.Labels().String()
+	s, ok := e.series[key]
+	if !ok {
+		s = &series{
+			metric:    m.ID(),
+			mType:     m.Type(),
+			labels:    m.Labels().Copy(),
+			firstSeen: now,
+		}
+		e.series[key] = s
+	}
+	return s
+}
+
+func (e *Engine) evaluate(r Rule, s *series, now time.Time) {
+	v, ok := s.apply(r, now)
+	if !ok {
+		return
+	}
+	key := r.Name + s.labels.String()
+	a, active := e.alerts[key]
+	if active && a.State == StateResolved {
+		active = false
+	}
+
+	if !r.holds(v) {
+		if active {
+			a.Value = v
+			e.resolve(key, now)
+		}
+		return
+	}
+
+	if !active {
+		a = &Alert{
+			Rule:        r.Name,
+			Condition:   r.String(),
+			Metric:      s.metric,
+			Type:        s.mType,
+			Labels:      s.labels,
+			State:       StatePending,
+			ActiveSince: now,
+		}
+		e.alerts[key] = a
+	}
+	a.Value = v
+	if a.State == StatePending && now.Sub(a.ActiveSince) >= r.For {
+		a.State = StateFiring
+		a.FiredAt = &now
+		e.notify(*a)
+	}
+}
+
+// resolve resolves firing alert and drops pending one
+func (e *Engine) resolve(key string, now time.Time) {
+	a, ok := e.alerts[key]
+	if !ok {
+		return
+	}
+	switch a.State {
+	case StateFiring:
+		a.State = StateResolved
+		a.ResolvedAt = &now
+		e.notify(*a)
+	case StatePending:
+		delete(e.alerts, key)
+	}
+}
+
+func (e *Engine) notify(a Alert) {
+	log.Info().Msgf("alert %s%s is %s (value %v)", a.Rule, a.Labels, a.State, a.Value)
+	select {
+	case e.notifications <- a:
+	default:
+		log.Warn().Msgf("notification on alert %s is dropped: queue is full", a.Rule)
+	}
+}
+
+func matches(r Rule, s *series) bool {
+	return r.Metric == s.metric && r.Type == s.mType && s.labels.Matches(r.Labels)
+}
+
+// apply returns value of rule function, false if there is not enough data (series is younger than window)
+func (s *series) apply(r Rule, now time.Time) (float64, bool) {
+	switch r.Function {
+	case FunctionValue:
+		return s.value, true
+	case FunctionIncrease, FunctionRate:
+		if now.Sub(s.firstSeen) < r.Window {
+			return 0, false
+		}
+		from := now.Add(-r.Window)
+		var increase int64
+		for _, smp := range s.samples {
+			if smp.ts.After(from) {
+				increase += smp.delta
+			}
+		}
+		if r.Function == FunctionRate {
+			return float64(increase) / r.Window.Seconds(), true
+		}
+		return float64(increase), true
+	}
+	return 0, false
+}
+
+// prune removes samples older than given time
+func (s *series) prune(before time.Time) {
+	i := sort.Search(len(s.samples), func(i int) bool {
+		return s.samples[i].ts.After(before)
+	})
+	s.samples = s.samples[i:]
+}
@@ -0,0 +1,196 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestEngine(t *testing.T, rules ...Rule) (*Engine, *clock) {
+	e, err := NewEngine(rules)
+	require.NoError(t, err)
+	c := &clock{t: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e.now = c.now
+	return e, c
+}
+
+func TestEngine(t *testing.T) {
+	t.Run("gauge alert lifecycle", func(t *testing.T) {
+		e, c := newTestEngine(t, Rule{
+			Name:      "LowMemory",
+			Metric:    "FreeMemory",
+			Type:      model.GAUGE,
+			Labels:    model.Labels{"source": "a1"},
+			Op:        "<",
+			Threshold: 100,
+			For:       time.Minute,
+		})
+
+		e.Observe(models.GaugeValue{Name: "FreeMemory", LabelSet: model.Labels{"source": "a2"}, Value: 10})
+		e.Observe(models.GaugeValue{Name: "FreeMemory", LabelSet: model.Labels{"source": "a1"}, Value: 10})
+		as := e.Alerts()
+		require.Len(t, as, 1)
+		assert.Equal(t, StatePending, as[0].State)
+		assert.Equal(t, model.Labels{"source": "a1"}, as[0].Labels)
+
+		c.advance(time.Minute)
+		e.Evaluate()
+		as = e.Alerts()
+		require.Len(t, as, 1)
+		assert.Equal(t, StateFiring, as[0].State)
+		assert.NotNil(t, as[0].FiredAt)
+
+		c.advance(time.Second)
+		e.Observe(models.GaugeValue{Name: "FreeMemory", LabelSet: model.Labels{"source": "a1"}, Value: 200})
+		as = e.Alerts()
+		require.Len(t, as, 1)
+		assert.Equal(t, StateResolved, as[0].State)
+		assert.Equal(t, 200.0, as[0].Value)
+
+		c.advance(defaultResolvedRetention + time.Second)
+		e.Evaluate()
+		assert.Empty(t, e.Alerts())
+
+		assert.Len(t, e.notifications, 2)
+	})
+	t.Run("pending alert is dropped", func(t *testing.T) {
+		e, c := newTestEngine(t, Rule{Name: "High", Metric: "Load", Type: model.GAUGE, Op: ">", Threshold: 1, For: time.Minute})
+
+		e.Observe(models.GaugeValue{Name: "Load", Value: 2})
+		require.Len(t, e.Alerts(), 1)
+		c.advance(time.Second)
+		e.Observe(models.GaugeValue{Name: "Load", Value: 0.5})
+		assert.Empty(t, e.Alerts())
+		assert.Empty(t, e.notifications)
+	})
+	t.Run("stale series are dropped", func(t *testing.T) {
+		e, c := newTestEngine(t, Rule{Name: "High", Metric: "Load", Type: model.GAUGE, Op: ">", Threshold: 1})
+
+		e.Observe(models.GaugeValue{Name: "Load", LabelSet: model.Labels{"source": "a1"}, Value: 2})
+		e.Observe(models.GaugeValue{Name: "Load", LabelSet: model.Labels{"source": "a2"}, Value: 2})
+		require.Len(t, e.Alerts(), 2)
+
+		c.advance(defaultStaleAfter)
+		e.Observe(models.GaugeValue{Name: "Load", LabelSet: model.Labels{"source": "a2"}, Value: 2})
+		c.advance(time.Second)
+		e.Evaluate()
+		as := e.Alerts()
+		require.Len(t, as, 2)
+		assert.Equal(t, StateResolved, as[0].State, "alert of dead agent should be resolved")
+		assert.Equal(t, StateFiring, as[1].State)
+		assert.Len(t, e.series, 1)
+		assert.Len(t, e.notifications, 3)
+	})
+	t.Run("counter rate", func(t *testing.T) {
+		e, c := newTestEngine(t, Rule{Name: "Stalled", Metric: "PollCount", Type: model.COUNTER, Window: time.Minute, Op: "<=", Threshold: 0})
+
+		e.Observe(models.CounterValue{Name: "PollCount", Value: 10})
+		assert.Empty(t, e.Alerts(), "series is younger than window")
+
+		c.advance(30 * time.Second)
+		e.Observe(models.CounterValue{Name: "PollCount", Value: 20})
+		c.advance(40 * time.Second)
+		e.Evaluate()
+		assert.Empty(t, e.Alerts())
+
+		c.advance(time.Minute)
+		e.Evaluate()
+		as := e.Alerts()
+		require.Len(t, as, 1)
+		assert.Equal(t, StateFiring, as[0].State)
+		assert.Equal(t, 0.0, as[0].Value)
+
+		c.advance(time.Second)
+		e.Observe(models.CounterValue{Name: "PollCount", Value: 6})
+		as = e.Alerts()
+		require.Len(t, as, 1)
+		assert.Equal(t, StateResolved, as[0].State)
+		assert.InDelta(t, 0.1, as[0].Value, 1e-9)
+	})
+	t.Run("counter increase", func(t *testing.T) {
+		e, c := newTestEngine(t, Rule{Name: "Errors", Metric: "Errors", Type: model.COUNTER, Function: FunctionIncrease, Window: time.Minute, Op: ">", Threshold: 5})
+
+		for i := 0; i < 3; i++ {
+			c.advance(30 * time.Second)
+			e.Observe(models.CounterValue{Name: "Errors", Value: 3})
+		}
+		as := e.Alerts()
+		require.Len(t, as, 1)
+		assert.Equal(t, StateFiring, as[0].State)
+		assert.Equal(t, 6.0, as[0].Value)
+	})
+}
+
+func TestRule(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		r := Rule{Name: "r", Metric: "m", Type: model.COUNTER, Window: time.Minute, Op: "<="}
+		require.NoError(t, r.Validate())
+		assert.Equal(t, FunctionRate, r.Function)
+		assert.Equal(t, "rate(m[1m0s]) <= 0 for 0s", r.String())
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, r := range []Rule{
+			{Metric: "m", Type: model.GAUGE, Op: "<"},
+			{Name: "r", Metric: "m", Type: model.HISTOGRAM, Op: "<"},
+			{Name: "r", Metric: "m", Type: model.GAUGE, Op: "=~"},
+			{Name: "r", Metric: "m", Type: model.GAUGE, Function: FunctionRate, Op: "<"},
+			{Name: "r", Metric: "m", Type: model.COUNTER, Op: "<"},
+		} {
+			assert.ErrorIs(t, r.Validate(), ErrInvalidRule)
+		}
+	})
+	t.Run("duplicate names", func(t *testing.T) {
+		r := Rule{Name: "r", Metric: "m", Type: model.GAUGE, Op: "<"}
+		_, err := NewEngine([]Rule{r, r})
+		assert.ErrorIs(t, err, ErrInvalidRule)
+	})
+	t.Run("unmarshal", func(t *testing.T) {
+		var c Config
+		err := json.Unmarshal([]byte(`{"evaluation_interval":"5s","rules":[{"name":"r","metric":"m","type":"counter","window":"1m","op":">","threshold":1,"for":"30s"}]}`), &c)
+		require.NoError(t, err)
+		assert.Equal(t, 5*time.Second, c.EvaluationInterval)
+		require.Len(t, c.Rules, 1)
+		assert.Equal(t, time.Minute, c.Rules[0].Window)
+		assert.Equal(t, 30*time.Second, c.Rules[0].For)
+	})
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received Alert
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		if received.State == StateResolved {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	n := NewWebhookNotifier(ts.URL)
+	require.NoError(t, n.Notify(context.Background(), Alert{Rule: "r", Metric: "m", State: StateFiring, Value: 1}))
+	assert.Equal(t, "r", received.Rule)
+	assert.Equal(t, StateFiring, received.State)
+
+	assert.Error(t, n.Notify(context.Background(), Alert{Rule: "r", State: StateResolved}))
+}
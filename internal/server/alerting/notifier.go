@@ -0,0 +1,65 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultWebhookTimeout = 5 * time.Second
+
+// LogNotifier writes alerts to log
+type LogNotifier struct{}
+
+func NewLogNotifier() LogNotifier {
+	return LogNotifier{}
+}
+
+func (n LogNotifier) Notify(_ context.Context, a Alert) error {
+	switch a.State {
+	case StateFiring:
+		log.Warn().Msgf("ALERT %s firing: %s%s %s, value %v", a.Rule, a.Metric, a.Labels, a.Condition, a.Value)
+	case StateResolved:
+		log.Info().Msgf("ALERT %s resolved: %s%s, value %v", a.Rule, a.Metric, a.Labels, a.Value)
+	}
+	return nil
+}
+
+// WebhookNotifier posts alerts to URL as JSON
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) WebhookNotifier {
+	return WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: defaultWebhookTimeout},
+	}
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, a Alert) error {
+	bs, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("could not marshal alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(bs))
+	if err != nil {
+		return fmt.Errorf("could not create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook not accepted, response code: %v", resp.StatusCode)
+	}
+	return nil
+}
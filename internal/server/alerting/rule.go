@@ -0,0 +1,159 @@
+// Package alerting contains threshold rules evaluation against incoming metrics and notifications on alerts
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// Functions of metric series which rule compares with threshold
+const (
+	FunctionValue    = "value"    // last gauge value
+	FunctionIncrease = "increase" // sum of counter increments within window
+	FunctionRate     = "rate"     // counter increments per second within window
+)
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Rule describes condition on metric series, e.g. FreeMemory < 524288000 for 2m.
+// Every series of metric which labels match rule labels is evaluated separately
+type Rule struct {
+	Name      string        `json:"name"`
+	Metric    string        `json:"metric"`
+	Type      string        `json:"type"`
+	Labels    model.Labels  `json:"labels,omitempty"`
+	Function  string        `json:"function,omitempty"` // FunctionValue for gauges and FunctionRate for counters by default
+	Window    time.Duration `json:"window,omitempty"`   // window of increase and rate functions
+	Op        string        `json:"op"`                 // one of <, <=, >, >=, ==, !=
+	Threshold float64       `json:"threshold"`
+	For       time.Duration `json:"for,omitempty"` // how long condition should hold before alert fires
+}
+
+// Config is an alerting configuration
+type Config struct {
+	Rules              []Rule        `json:"rules,omitempty"`
+	WebhookURL         string        `env:"ALERT_WEBHOOK_URL" json:"webhook_url,omitempty"`
+	EvaluationInterval time.Duration `env:"ALERT_EVALUATION_INTERVAL" json:"evaluation_interval,omitempty"`
+}
+
+// Validate checks rule and fills defaults
+func (r *Rule) Validate() error {
+	if len(r.Name) == 0 {
+		return fmt.Errorf("%w: name is not set", ErrInvalidRule)
+	}
+	if len(r.Metric) == 0 {
+		return fmt.Errorf("%w %s: metric is not set", ErrInvalidRule, r.Name)
+	}
+	switch r.Type {
+	case model.GAUGE:
+		if len(r.Function) == 0 {
+			r.Function = FunctionValue
+		}
+		if r.Function != FunctionValue {
+			return fmt.Errorf("%w %s: function %s is not supported for gauges", ErrInvalidRule, r.Name, r.Function)
+		}
+	case model.COUNTER:
+		if len(r.Function) == 0 {
+			r.Function = FunctionRate
+		}
+		if r.Function != FunctionIncrease && r.Function != FunctionRate {
+			return fmt.Errorf("%w %s: function %s is not supported for counters", ErrInvalidRule, r.Name, r.Function)
+		}
+		if r.Window <= 0 {
+			return fmt.Errorf("%w %s: window must be positive", ErrInvalidRule, r.Name)
+		}
+	default:
+		return fmt.Errorf("%w %s: metric type %s is not supported", ErrInvalidRule, r.Name, r.Type)
+	}
+	if _, ok := comparisons[r.Op]; !ok {
+		return fmt.Errorf("%w %s: unknown operator %s", ErrInvalidRule, r.Name, r.Op)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("%w %s: for must not be negative", ErrInvalidRule, r.Name)
+	}
+	return nil
+}
+
+// String returns rule condition, e.g. rate(PollCount[1m0s]) <= 0 for 1m0s
+func (r Rule) String() string {
+	expr := r.Metric + r.Labels.String()
+	if r.Function != FunctionValue {
+		expr = fmt.Sprintf("%s(%s[%s])", r.Function, expr, r.Window)
+	}
+	return fmt.Sprintf("%s %s %v for %s", expr, r.Op, r.Threshold, r.For)
+}
+
+var comparisons = map[string]func(v float64, threshold float64) bool{
+	"<":  func(v float64, threshold float64) bool { return v < threshold },
+	"<=": func(v float64, threshold float64) bool { return v <= threshold },
+	">":  func(v float64, threshold float64) bool { return v > threshold },
+	">=": func(v float64, threshold float64) bool { return v >= threshold },
+	"==": func(v float64, threshold float64) bool { return v == threshold },
+	"!=": func(v float64, threshold float64) bool { return v != threshold },
+}
+
+func (r Rule) holds(v float64) bool {
+	return comparisons[r.Op](v, r.Threshold)
+}
+
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	type ruleAlias Rule
+
+	aliasValue := &struct {
+		*ruleAlias
+		Window string `json:"window,omitempty"`
+		For    string `json:"for,omitempty"`
+	}{
+		ruleAlias: (*ruleAlias)(r),
+	}
+
+	err := json.Unmarshal(b, aliasValue)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal json: %w", err)
+	}
+
+	if len(aliasValue.Window) > 0 {
+		r.Window, err = time.ParseDuration(aliasValue.Window)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
+	if len(aliasValue.For) > 0 {
+		r.For, err = time.ParseDuration(aliasValue.For)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) UnmarshalJSON(b []byte) error {
+	type configAlias Config
+
+	aliasValue := &struct {
+		*configAlias
+		EvaluationInterval string `json:"evaluation_interval,omitempty"`
+	}{
+		configAlias: (*configAlias)(c),
+	}
+
+	err := json.Unmarshal(b, aliasValue)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal json: %w", err)
+	}
+
+	if len(aliasValue.EvaluationInterval) > 0 {
+		c.EvaluationInterval, err = time.ParseDuration(aliasValue.EvaluationInterval)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -11,27 +12,58 @@ import (
 	"github.com/caarlos0/env/v6"
 	"github.com/rs/zerolog/log"
 	configUtil "github.com/tony-spark/metrico/internal/config"
+	"github.com/tony-spark/metrico/internal/server/access"
+	"github.com/tony-spark/metrico/internal/server/alerting"
+	"github.com/tony-spark/metrico/internal/server/services"
 )
 
+// ErrTrustRealIP is returned if removed TRUST_REAL_IP setting is used. Client address (for HTTP too) is no longer
+// taken from X-Real-IP header sent by anyone, only from proxy header sent by trusted proxies, and otherwise it is the
+// peer address
+var ErrTrustRealIP = errors.New("TRUST_REAL_IP (-trust-real-ip, trust_real_ip) is not supported anymore, " +
+	"set TRUSTED_PROXIES (-trusted-proxy, trusted_proxies) to addresses of proxies and " +
+	"PROXY_HEADER (-proxy-header, proxy_header) to X-Real-IP instead")
+
 var (
 	Config = config{
-		Address:       "127.0.0.1:8080",
-		StoreInterval: 300 * time.Second,
-		StoreFilename: "/tmp/devops-metrics-db.json",
-		Restore:       true,
+		Address:           "127.0.0.1:8080",
+		StoreInterval:     300 * time.Second,
+		StoreFilename:     "/tmp/devops-metrics-db.json",
+		Restore:           true,
+		ReportInterval:    10 * time.Second,
+		StaleIntervals:    3,
+		RetentionInterval: time.Hour,
+		DedupWindow:       5 * time.Minute,
+		ProxyHeader:       access.DefaultProxyHeader,
 	}
 )
 
 type config struct {
-	Address        string        `env:"ADDRESS" json:"address,omitempty"`
-	GrpcAddress    string        `env:"GRPC_ADDRESS" json:"grpc_address,omitempty"`
-	StoreInterval  time.Duration `env:"STORE_INTERVAL" json:"store_interval,omitempty"`
-	StoreFilename  string        `env:"STORE_FILE" json:"store_filename,omitempty"`
-	Restore        bool          `env:"RESTORE" json:"restore,omitempty"`
-	Key            string        `env:"KEY" json:"key,omitempty"`
-	DSN            string        `env:"DATABASE_DSN" json:"database_dsn,omitempty"`
-	PrivateKeyFile string        `env:"CRYPTO_KEY" json:"crypto_key,omitempty"`
-	TrustedSubnet  string        `env:"TRUSTED_SUBNET" json:"trusted_subnet,omitempty"`
+	Address           string                   `env:"ADDRESS" json:"address,omitempty"`
+	GrpcAddress       string                   `env:"GRPC_ADDRESS" json:"grpc_address,omitempty"`
+	StoreInterval     time.Duration            `env:"STORE_INTERVAL" json:"store_interval,omitempty"`
+	StoreFilename     string                   `env:"STORE_FILE" json:"store_filename,omitempty"`
+	Restore           bool                     `env:"RESTORE" json:"restore,omitempty"`
+	Key               string                   `env:"KEY" json:"key,omitempty"`
+	DSN               string                   `env:"DATABASE_DSN" json:"database_dsn,omitempty"`
+	PrivateKeyFile    string                   `env:"CRYPTO_KEY" json:"crypto_key,omitempty"`
+	TrustedSubnet     string                   `env:"TRUSTED_SUBNET" json:"trusted_subnet,omitempty"`   // same as a single allowed subnet
+	AllowedSubnets    []string                 `env:"ALLOWED_SUBNETS" json:"allowed_subnets,omitempty"` // only clients within these subnets (or addresses) are allowed, if set
+	DeniedSubnets     []string                 `env:"DENIED_SUBNETS" json:"denied_subnets,omitempty"`   // clients within these subnets are denied even if allowed
+	TrustedProxies    []string                 `env:"TRUSTED_PROXIES" json:"trusted_proxies,omitempty"` // proxies trusted to report client address in proxy header
+	ProxyHeader       string                   `env:"PROXY_HEADER" json:"proxy_header,omitempty"`       // header with client address chain, e.g. X-Forwarded-For or X-Real-IP
+	TrustRealIP       bool                     `env:"TRUST_REAL_IP" json:"trust_real_ip,omitempty"`     // not supported, see ErrTrustRealIP
+	TLSCertFile       string                   `env:"TLS_CERT" json:"tls_cert,omitempty"`               // server certificate (PEM), enables TLS
+	TLSKeyFile        string                   `env:"TLS_KEY" json:"tls_key,omitempty"`                 // private key of server certificate (PEM)
+	TLSClientCAFile   string                   `env:"TLS_CLIENT_CA" json:"tls_client_ca,omitempty"`     // CA bundle to verify client certificates (PEM), enables mutual TLS
+	KeepHistory       bool                     `env:"KEEP_HISTORY" json:"keep_history,omitempty"`
+	ReportInterval    time.Duration            `env:"REPORT_INTERVAL" json:"report_interval,omitempty"`       // expected interval of agents reports
+	StaleIntervals    int                      `env:"STALE_INTERVALS" json:"stale_intervals,omitempty"`       // metric not updated within this number of report intervals is stale (0 to disable)
+	EvictStale        bool                     `env:"EVICT_STALE" json:"evict_stale,omitempty"`               // whether to remove stale metrics
+	Retention         services.RetentionPolicy `env:"RETENTION" json:"retention,omitempty"`                   // e.g. raw=24h,1m=720h,1h=8760h
+	RetentionInterval time.Duration            `env:"RETENTION_INTERVAL" json:"retention_interval,omitempty"` // how often retention policy is enforced
+	DedupWindow       time.Duration            `env:"DEDUP_WINDOW" json:"dedup_window,omitempty"`             // replayed batches are not applied again within this window (0 to disable)
+	Alerting          alerting.Config          `json:"alerting,omitempty"`
 }
 
 func Parse() error {
@@ -63,6 +95,34 @@ func Parse() error {
 	flag.StringVar(&Config.DSN, "d", Config.DSN, "database connection string")
 	flag.StringVar(&Config.PrivateKeyFile, "crypto-key", Config.PrivateKeyFile, "private key for message decryption (PEM)")
 	flag.StringVar(&Config.TrustedSubnet, "t", Config.TrustedSubnet, "trusted subnet for clients")
+	flag.Func("allow", "subnet or address of allowed clients (may be repeated)", func(s string) error {
+		Config.AllowedSubnets = append(Config.AllowedSubnets, s)
+		return nil
+	})
+	flag.Func("deny", "subnet or address of denied clients (may be repeated)", func(s string) error {
+		Config.DeniedSubnets = append(Config.DeniedSubnets, s)
+		return nil
+	})
+	flag.Func("trusted-proxy", "subnet or address of proxies trusted to report client address (may be repeated)", func(s string) error {
+		Config.TrustedProxies = append(Config.TrustedProxies, s)
+		return nil
+	})
+	flag.StringVar(&Config.ProxyHeader, "proxy-header", Config.ProxyHeader, "header trusted proxies report client address chain in")
+	flag.BoolVar(&Config.TrustRealIP, "trust-real-ip", Config.TrustRealIP, "not supported, use -trusted-proxy and -proxy-header")
+	flag.StringVar(&Config.TLSCertFile, "tls-cert", Config.TLSCertFile, "server certificate to serve TLS (PEM)")
+	flag.StringVar(&Config.TLSKeyFile, "tls-key", Config.TLSKeyFile, "private key of server certificate (PEM)")
+	flag.StringVar(&Config.TLSClientCAFile, "tls-client-ca", Config.TLSClientCAFile, "CA bundle to verify client certificates (PEM)")
+	flag.BoolVar(&Config.KeepHistory, "history", Config.KeepHistory, "whether to keep metrics history in memory (always kept in database)")
+	flag.DurationVar(&Config.ReportInterval, "ri", Config.ReportInterval, "expected report interval of agents")
+	flag.IntVar(&Config.StaleIntervals, "stale", Config.StaleIntervals, "number of missed report intervals after which metric is stale (0 to disable)")
+	flag.BoolVar(&Config.EvictStale, "evict", Config.EvictStale, "whether to remove stale metrics")
+	flag.Func("retention", "history retention policy, e.g. raw=24h,1m=720h,1h=8760h", func(s string) error {
+		return Config.Retention.UnmarshalText([]byte(s))
+	})
+	flag.DurationVar(&Config.RetentionInterval, "retention-interval", Config.RetentionInterval, "how often history retention policy is enforced")
+	flag.DurationVar(&Config.DedupWindow, "dedup-window", Config.DedupWindow, "window within which replayed batches are not applied again (0 to disable)")
+	flag.StringVar(&Config.Alerting.WebhookURL, "alert-webhook", Config.Alerting.WebhookURL, "URL to post alert notifications to")
+	flag.DurationVar(&Config.Alerting.EvaluationInterval, "alert-interval", Config.Alerting.EvaluationInterval, "alert rules evaluation interval")
 	flag.StringVar(&configFile, "config", "", "config file")
 	flag.StringVar(&configFile, "c", "", "shortcut to --config")
 	flag.Parse()
@@ -72,6 +132,18 @@ func Parse() error {
 		return fmt.Errorf("could not read config: %w", err)
 	}
 
+	if Config.TrustRealIP {
+		return ErrTrustRealIP
+	}
+
+	if len(Config.TLSClientCAFile) > 0 && len(Config.TLSCertFile) == 0 {
+		return fmt.Errorf("client certificates verification requires server certificate")
+	}
+
+	if _, err = Config.AccessPolicy(); err != nil {
+		return fmt.Errorf("invalid access policy: %w", err)
+	}
+
 	log.Info().Msgf("Server config parsed:  %+v", Config)
 	return nil
 }
@@ -81,7 +153,10 @@ func (c *config) UnmarshalJSON(b []byte) error {
 
 	aliasValue := &struct {
 		*configAlias
-		StoreInterval string `json:"store_interval,omitempty"`
+		StoreInterval     string `json:"store_interval,omitempty"`
+		ReportInterval    string `json:"report_interval,omitempty"`
+		RetentionInterval string `json:"retention_interval,omitempty"`
+		DedupWindow       string `json:"dedup_window,omitempty"`
 	}{
 		configAlias: (*configAlias)(c),
 	}
@@ -98,5 +173,53 @@ func (c *config) UnmarshalJSON(b []byte) error {
 		}
 	}
 
+	if len(aliasValue.ReportInterval) > 0 {
+		c.ReportInterval, err = time.ParseDuration(aliasValue.ReportInterval)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
+	if len(aliasValue.RetentionInterval) > 0 {
+		c.RetentionInterval, err = time.ParseDuration(aliasValue.RetentionInterval)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
+	if len(aliasValue.DedupWindow) > 0 {
+		c.DedupWindow, err = time.ParseDuration(aliasValue.DedupWindow)
+		if err != nil {
+			return fmt.Errorf("could not parse time.Duration: %w", err)
+		}
+	}
+
 	return nil
 }
+
+// StaleAfter returns duration after which not updated metric is stale, 0 if staleness is not tracked
+func (c config) StaleAfter() time.Duration {
+	return time.Duration(c.StaleIntervals) * c.ReportInterval
+}
+
+// AccessPolicy returns policy of clients access to server
+func (c config) AccessPolicy() (access.Policy, error) {
+	allowed, err := access.ParseSubnets(append([]string{c.TrustedSubnet}, c.AllowedSubnets...))
+	if err != nil {
+		return access.Policy{}, fmt.Errorf("could not parse allowed subnets: %w", err)
+	}
+	denied, err := access.ParseSubnets(c.DeniedSubnets)
+	if err != nil {
+		return access.Policy{}, fmt.Errorf("could not parse denied subnets: %w", err)
+	}
+	proxies, err := access.ParseSubnets(c.TrustedProxies)
+	if err != nil {
+		return access.Policy{}, fmt.Errorf("could not parse trusted proxies: %w", err)
+	}
+	return access.NewPolicy(
+		access.WithAllowed(allowed...),
+		access.WithDenied(denied...),
+		access.WithTrustedProxies(proxies...),
+		access.WithProxyHeader(c.ProxyHeader),
+	), nil
+}
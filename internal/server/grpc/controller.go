@@ -2,31 +2,46 @@ package grpc
 
 import (
 	"context"
-	"encoding/hex"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
-	"strings"
 
 	"github.com/rs/zerolog/log"
 	pb "github.com/tony-spark/metrico/gen/pb/api"
 	"github.com/tony-spark/metrico/internal/crypto"
 	"github.com/tony-spark/metrico/internal/dto"
+	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/access"
 	"github.com/tony-spark/metrico/internal/server/models"
 	"github.com/tony-spark/metrico/internal/server/services"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// agentIDKey is a metadata key holding identifier of agent sending metrics
+	agentIDKey = "x-agent-id"
+	// batchIDKey is a metadata key holding identifier of batch sent in stream
+	batchIDKey = "x-batch-id"
 )
 
 type Controller struct {
 	pb.UnimplementedMetricServiceServer
 
 	srv           *grpc.Server
+	health        *health.Server
 	listenAddress string
 	ms            *services.MetricService
 	dbm           models.DBManager
 	h             dto.Hasher
 	d             crypto.Decryptor
-	trustedSubNet *net.IPNet
+	policy        access.Policy
+	tlsConfig     *tls.Config
 }
 
 type Option func(c *Controller)
@@ -49,22 +64,52 @@ func WithDBManager(dbm models.DBManager) Option {
 	}
 }
 
-func WithTrustedSubNet(subnet *net.IPNet) Option {
+// WithDecryptor configures controller to accept encrypted metrics only
+func WithDecryptor(d crypto.Decryptor) Option {
+	return func(c *Controller) {
+		c.d = d
+	}
+}
+
+// WithAccessPolicy configures controller to allow calls only from clients allowed by policy. Proxy header is read from
+// metadata with lowercased header name as a key
+func WithAccessPolicy(p access.Policy) Option {
 	return func(c *Controller) {
-		c.trustedSubNet = subnet
+		c.policy = p
+	}
+}
+
+// WithTLSConfig configures controller to accept TLS connections only
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Controller) {
+		c.tlsConfig = config
 	}
 }
 
 func NewController(metricService *services.MetricService, options ...Option) *Controller {
 	controller := &Controller{
-		ms:  metricService,
-		srv: grpc.NewServer(),
+		ms: metricService,
 	}
 
 	for _, opt := range options {
 		opt(controller)
 	}
 
+	var serverOpts []grpc.ServerOption
+	if controller.tlsConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(controller.tlsConfig)))
+	}
+	if controller.policy.Restricts() {
+		serverOpts = append(serverOpts,
+			grpc.ChainUnaryInterceptor(controller.accessUnaryInterceptor),
+			grpc.ChainStreamInterceptor(controller.accessStreamInterceptor),
+		)
+	}
+	controller.srv = grpc.NewServer(serverOpts...)
+	// standard health service reports liveness regardless of database connection (see DBStatus)
+	controller.health = health.NewServer()
+	healthpb.RegisterHealthServer(controller.srv, controller.health)
+
 	return controller
 }
 
@@ -89,35 +134,142 @@ func (c *Controller) DBStatus(ctx context.Context, _ *pb.Empty) (*pb.Response, e
 	return &response, nil
 }
 
+// Update reads whole stream of metrics and applies them atomically: if some metric is rejected (e.g. invalid or with
+// wrong hash) or could not be saved, none are applied. Rejected metrics are reported in response.
+// Stream with batch identifier which was already applied is acknowledged without applying it again
 func (c *Controller) Update(stream pb.MetricService_UpdateServer) error {
+	source := agentID(stream.Context())
+	var (
+		gs         []models.GaugeValue
+		cs         []models.CounterValue
+		hs         []models.HistogramValue
+		rejections rejections
+	)
 	for {
 		m, err := stream.Recv()
 		if err == io.EOF {
-			return stream.SendAndClose(&pb.Response{Status: pb.Status_OK})
+			break
 		}
 		if err != nil {
 			return err
 		}
+		m, err = c.decrypt(m)
+		if err != nil {
+			log.Error().Err(err).Msg("could not decrypt metric")
+			rejections.add(&pb.Rejection{Reason: pb.Reason_UNDECRYPTABLE, Error: err.Error()})
+			continue
+		}
 		log.Info().Msgf("got %v", m)
-		mdto := toDTO(m)
-		if !mdto.HasValue() {
-			log.Error().Msgf("no value: %+v", mdto)
+		mdto := dto.FromProto(m)
+		if err = validate(mdto); err != nil {
+			log.Error().Err(err).Msgf("invalid metric: %+v", mdto)
+			rejections.add(&pb.Rejection{Id: mdto.ID, Reason: pb.Reason_INVALID, Error: err.Error()})
+			continue
+		}
+		if err = c.checkHash(mdto); err != nil {
+			repr := c.repr(mdto)
+			log.Error().Err(err).Msgf("wrong hash of %s, hashed %q", mdto.ID, repr)
+			rejections.add(&pb.Rejection{Id: mdto.ID, Reason: pb.Reason_BAD_HASH, Error: err.Error(), Repr: repr})
 			continue
 		}
-		if c.h != nil {
-			var ok bool
-			ok, err = c.h.Check(mdto)
-			if err != nil || !ok {
-				log.Error().Err(err).Msg("wrong hash")
-			}
+		if len(source) > 0 {
+			mdto.Labels = mdto.Labels.With(model.SourceLabel, source)
+		}
+		switch metric := models.FromDTO(mdto).(type) {
+		case models.GaugeValue:
+			gs = append(gs, metric)
+		case models.CounterValue:
+			cs = append(cs, metric)
+		case models.HistogramValue:
+			hs = append(hs, metric)
+		}
+	}
+	if rejections.count > 0 {
+		return stream.SendAndClose(rejections.response())
+	}
+
+	id := batchID(stream.Context())
+	applied, err := c.ms.UpdateBatch(stream.Context(), id, gs, cs, hs)
+	if err != nil {
+		log.Error().Err(err).Msg("could not update metrics")
+		return stream.SendAndClose(errorResponse("could not save metrics"))
+	}
+	if !applied {
+		log.Info().Msgf("batch %s is already applied", id)
+	}
+	return stream.SendAndClose(&pb.Response{Status: pb.Status_OK})
+}
+
+// UpdateStream applies every metric as soon as it is received and acknowledges it, metrics which are not applied are
+// acknowledged with reason. Metric is identified by batch identifier and its sequence number, so that metric sent
+// again within the same batch is acknowledged without applying it again
+func (c *Controller) UpdateStream(stream pb.MetricService_UpdateStreamServer) error {
+	ctx := stream.Context()
+	source := agentID(ctx)
+	id := batchID(ctx)
+	for {
+		u, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
-		metric := models.FromDTO(mdto)
-		_, err = c.ms.UpdateMetric(context.Background(), metric)
+		err = stream.Send(c.apply(ctx, id, source, u))
 		if err != nil {
-			log.Error().Err(err).Msg("could not update metric")
+			return err
 		}
+	}
+}
 
+// apply applies metric received in update stream, returning its acknowledgement
+func (c *Controller) apply(ctx context.Context, batchID string, source string, u *pb.MetricUpdate) *pb.Ack {
+	if u.GetMetric() == nil {
+		return nack(u.GetSeq(), pb.Reason_INVALID, "no metric")
+	}
+	m, err := c.decrypt(u.GetMetric())
+	if err != nil {
+		log.Error().Err(err).Msg("could not decrypt metric")
+		return nack(u.GetSeq(), pb.Reason_UNDECRYPTABLE, err.Error())
+	}
+	mdto := dto.FromProto(m)
+	if err = validate(mdto); err != nil {
+		log.Error().Err(err).Msgf("invalid metric: %+v", mdto)
+		return nack(u.GetSeq(), pb.Reason_INVALID, err.Error())
+	}
+	if err = c.checkHash(mdto); err != nil {
+		repr := c.repr(mdto)
+		log.Error().Err(err).Msgf("wrong hash of %s, hashed %q", mdto.ID, repr)
+		ack := nack(u.GetSeq(), pb.Reason_BAD_HASH, err.Error())
+		ack.Repr = repr
+		return ack
+	}
+	if len(source) > 0 {
+		mdto.Labels = mdto.Labels.With(model.SourceLabel, source)
 	}
+	var (
+		gs []models.GaugeValue
+		cs []models.CounterValue
+		hs []models.HistogramValue
+	)
+	switch metric := models.FromDTO(mdto).(type) {
+	case models.GaugeValue:
+		gs = append(gs, metric)
+	case models.CounterValue:
+		cs = append(cs, metric)
+	case models.HistogramValue:
+		hs = append(hs, metric)
+	}
+	var id string
+	if len(batchID) > 0 {
+		id = fmt.Sprintf("%s/%d", batchID, u.GetSeq())
+	}
+	_, err = c.ms.UpdateBatch(ctx, id, gs, cs, hs)
+	if err != nil {
+		log.Error().Err(err).Msgf("could not update metric %s", mdto.ID)
+		return nack(u.GetSeq(), pb.Reason_UNAVAILABLE, "could not save metric")
+	}
+	return &pb.Ack{Seq: u.GetSeq(), Status: pb.Status_OK}
 }
 
 func (c *Controller) Run() error {
@@ -140,6 +292,7 @@ func (c *Controller) Shutdown(ctx context.Context) error {
 	stopped := make(chan struct{})
 	go func() {
 		log.Info().Msg("stopping grpc server gracefully...")
+		c.health.Shutdown()
 		c.srv.GracefulStop()
 		close(stopped)
 	}()
@@ -159,12 +312,130 @@ func (c Controller) String() string {
 	return fmt.Sprintf("GRPC controller at " + c.listenAddress)
 }
 
-func toDTO(m *pb.Metric) dto.Metric {
-	return dto.Metric{
-		ID:    m.Id,
-		MType: strings.ToLower(m.GetType().String()),
-		Delta: m.Delta,
-		Value: m.Value,
-		Hash:  hex.EncodeToString(m.Hash),
+// decrypt returns metric decrypted from the encrypted one. If controller has no decryptor, metric must not be
+// encrypted, otherwise it must be
+func (c Controller) decrypt(m *pb.Metric) (*pb.Metric, error) {
+	if c.d == nil {
+		if len(m.Encrypted) > 0 {
+			return nil, fmt.Errorf("encrypted metrics are not supported")
+		}
+		return m, nil
+	}
+	if len(m.Encrypted) == 0 {
+		return nil, fmt.Errorf("metric %s is not encrypted", m.Id)
+	}
+	bs, err := c.d.Decrypt(m.Encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt metric: %w", err)
+	}
+	var decrypted pb.Metric
+	err = proto.Unmarshal(bs, &decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse decrypted metric: %w", err)
+	}
+	return &decrypted, nil
+}
+
+// validate returns error if metric has no value of known type or its histogram is inconsistent
+func validate(mdto dto.Metric) error {
+	if !mdto.HasValue() {
+		return fmt.Errorf("metric %s has no value", mdto.ID)
+	}
+	if mdto.Histogram != nil {
+		if err := mdto.Histogram.Validate(); err != nil {
+			return fmt.Errorf("invalid histogram %s: %w", mdto.ID, err)
+		}
+	}
+	return nil
+}
+
+// checkHash returns error if controller checks hashes and metric hash is wrong
+func (c Controller) checkHash(mdto dto.Metric) error {
+	if c.h == nil {
+		return nil
+	}
+	ok, err := c.h.Check(mdto)
+	if err != nil {
+		return fmt.Errorf("could not check hash: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("hash check failed")
+	}
+	return nil
+}
+
+// repr returns representation of metric hashed by controller hasher (if it tells one). Hash itself is never reported,
+// as it could be used to forge metrics
+func (c Controller) repr(mdto dto.Metric) string {
+	h, ok := c.h.(interface {
+		Repr(m dto.Metric) (string, error)
+	})
+	if !ok {
+		return ""
+	}
+	repr, err := h.Repr(mdto)
+	if err != nil {
+		return ""
+	}
+	return repr
+}
+
+// maxRejections is a number of rejected metrics reported in response
+const maxRejections = 100
+
+// rejections collects metrics rejected in update
+type rejections struct {
+	count uint32
+	list  []*pb.Rejection
+}
+
+func (r *rejections) add(rejection *pb.Rejection) {
+	r.count++
+	if len(r.list) < maxRejections {
+		r.list = append(r.list, rejection)
+	}
+}
+
+func (r *rejections) response() *pb.Response {
+	response := errorResponse(fmt.Sprintf("%d metrics rejected, none applied", r.count))
+	response.Rejected = r.count
+	response.Rejections = r.list
+	return response
+}
+
+func nack(seq uint64, reason pb.Reason, errTxt string) *pb.Ack {
+	return &pb.Ack{
+		Seq:    seq,
+		Status: pb.Status_ERROR,
+		Reason: reason,
+		Error:  &errTxt,
+	}
+}
+
+func errorResponse(errTxt string) *pb.Response {
+	return &pb.Response{
+		Status: pb.Status_ERROR,
+		Error:  &errTxt,
+	}
+}
+
+// agentID returns identifier of agent from request metadata (empty if not set)
+func agentID(ctx context.Context) string {
+	return metadataValue(ctx, agentIDKey)
+}
+
+// batchID returns identifier of batch from request metadata (empty if not set)
+func batchID(ctx context.Context) string {
+	return metadataValue(ctx, batchIDKey)
+}
+
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if vs := md.Get(key); len(vs) > 0 {
+		return vs[0]
 	}
+	return ""
 }
@@ -0,0 +1,308 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/tony-spark/metrico/gen/pb/api"
+	"github.com/tony-spark/metrico/internal/crypto"
+	"github.com/tony-spark/metrico/internal/dto"
+	"github.com/tony-spark/metrico/internal/hash"
+	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/access"
+	"github.com/tony-spark/metrico/internal/server/services"
+	"github.com/tony-spark/metrico/internal/server/storage"
+)
+
+func TestDecrypt(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	encryptor := crypto.NewHybridEncryptor(&privateKey.PublicKey, "metrico")
+
+	value := 1.5
+	metric := &pb.Metric{Id: "test", Type: pb.MetricType_GAUGE, Value: &value}
+	bs, err := proto.Marshal(metric)
+	require.NoError(t, err)
+	encryptedBs, err := encryptor.Encrypt(bs)
+	require.NoError(t, err)
+	encrypted := &pb.Metric{Encrypted: encryptedBs}
+
+	c := Controller{d: crypto.NewHybridDecryptor(privateKey, "metrico")}
+
+	t.Run("encrypted", func(t *testing.T) {
+		decrypted, err := c.decrypt(encrypted)
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(metric, decrypted))
+	})
+
+	t.Run("not encrypted", func(t *testing.T) {
+		_, err := c.decrypt(metric)
+		assert.Error(t, err)
+	})
+
+	t.Run("no decryptor", func(t *testing.T) {
+		plain := Controller{}
+		_, err := plain.decrypt(encrypted)
+		assert.Error(t, err)
+		decrypted, err := plain.decrypt(metric)
+		require.NoError(t, err)
+		assert.Equal(t, metric, decrypted)
+	})
+}
+
+func TestUpdateStream(t *testing.T) {
+	h := hash.NewSha256Hmac("key")
+	ms := services.NewMetricService(storage.NewSingleValueRepository(), nil, services.WithDedupWindow(time.Minute))
+	client := newTestClient(t, NewController(ms, WithHasher(h)))
+
+	delta := int64(2)
+	counter := signed(t, h, dto.Metric{ID: "PollCount", MType: model.COUNTER, Delta: &delta})
+	badHash := signed(t, h, dto.Metric{ID: "PollCount", MType: model.COUNTER, Delta: &delta})
+	badHash.Hash[0] ^= 1
+	noValue := &pb.Metric{Id: "Alloc", Type: pb.MetricType_GAUGE}
+
+	send := func(t *testing.T, batchID string, us ...*pb.MetricUpdate) []*pb.Ack {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), batchIDKey, batchID)
+		stream, err := client.UpdateStream(ctx)
+		require.NoError(t, err)
+		for _, u := range us {
+			require.NoError(t, stream.Send(u))
+		}
+		require.NoError(t, stream.CloseSend())
+		var acks []*pb.Ack
+		for {
+			ack, err := stream.Recv()
+			if err == io.EOF {
+				return acks
+			}
+			require.NoError(t, err)
+			acks = append(acks, ack)
+		}
+	}
+	counterValue := func(t *testing.T) int64 {
+		return counterValue(t, ms, "PollCount")
+	}
+
+	t.Run("metrics are acknowledged", func(t *testing.T) {
+		acks := send(t, "batch1",
+			&pb.MetricUpdate{Seq: 0, Metric: counter},
+			&pb.MetricUpdate{Seq: 1, Metric: badHash},
+			&pb.MetricUpdate{Seq: 2, Metric: noValue},
+			&pb.MetricUpdate{Seq: 3},
+		)
+		require.Len(t, acks, 4)
+		assert.Equal(t, pb.Status_OK, acks[0].Status)
+		assert.Equal(t, pb.Reason_BAD_HASH, acks[1].Reason)
+		assert.Equal(t, "PollCount:counter:2", acks[1].Repr)
+		assert.Equal(t, pb.Reason_INVALID, acks[2].Reason)
+		assert.Equal(t, pb.Reason_INVALID, acks[3].Reason)
+		assert.Equal(t, delta, counterValue(t))
+	})
+
+	t.Run("metric sent again is not applied again", func(t *testing.T) {
+		acks := send(t, "batch1", &pb.MetricUpdate{Seq: 0, Metric: counter}, &pb.MetricUpdate{Seq: 4, Metric: counter})
+		require.Len(t, acks, 2)
+		assert.Equal(t, pb.Status_OK, acks[0].Status)
+		assert.Equal(t, pb.Status_OK, acks[1].Status)
+		assert.Equal(t, 2*delta, counterValue(t))
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	h := hash.NewSha256Hmac("key")
+	ms := services.NewMetricService(storage.NewSingleValueRepository(), nil)
+	client := newTestClient(t, NewController(ms, WithHasher(h)))
+
+	delta := int64(2)
+	counter := signed(t, h, dto.Metric{ID: "PollCount", MType: model.COUNTER, Delta: &delta})
+	badHash := signed(t, hash.NewSha256Hmac("other key"), dto.Metric{ID: "Frees", MType: model.COUNTER, Delta: &delta})
+
+	send := func(t *testing.T, mx ...*pb.Metric) *pb.Response {
+		stream, err := client.Update(context.Background())
+		require.NoError(t, err)
+		for _, m := range mx {
+			require.NoError(t, stream.Send(m))
+		}
+		r, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		return r
+	}
+
+	t.Run("metrics with wrong hash are rejected", func(t *testing.T) {
+		r := send(t, counter, badHash, &pb.Metric{Id: "Alloc"})
+		assert.Equal(t, pb.Status_ERROR, r.Status)
+		assert.Equal(t, uint32(2), r.Rejected)
+		require.Len(t, r.Rejections, 2)
+		assert.Equal(t, "Frees", r.Rejections[0].Id)
+		assert.Equal(t, pb.Reason_BAD_HASH, r.Rejections[0].Reason)
+		assert.Equal(t, "Frees:counter:2", r.Rejections[0].Repr)
+		assert.Equal(t, pb.Reason_INVALID, r.Rejections[1].Reason)
+
+		m, err := ms.Get(context.Background(), "PollCount", nil, model.COUNTER)
+		require.NoError(t, err)
+		assert.Nil(t, m, "no metrics should be applied")
+	})
+
+	t.Run("metrics with correct hash are applied", func(t *testing.T) {
+		r := send(t, counter)
+		assert.Equal(t, pb.Status_OK, r.Status)
+		assert.Equal(t, uint32(0), r.Rejected)
+		assert.Equal(t, delta, counterValue(t, ms, "PollCount"))
+	})
+}
+
+func TestAccessPolicy(t *testing.T) {
+	subnets := func(ss ...string) []*net.IPNet {
+		parsed, err := access.ParseSubnets(ss)
+		require.NoError(t, err)
+		return parsed
+	}
+	policy := access.NewPolicy(
+		access.WithAllowed(subnets("192.168.1.0/24", "10.0.0.0/8")...),
+		access.WithDenied(subnets("10.0.13.0/24")...),
+		access.WithTrustedProxies(subnets("10.0.0.1")...),
+	)
+	ms := services.NewMetricService(storage.NewSingleValueRepository(), nil)
+
+	forwardedFor := func(ip string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-forwarded-for", ip)
+	}
+	sendStream := func(ctx context.Context, client pb.MetricServiceClient) error {
+		stream, err := client.UpdateStream(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.CloseSend())
+		_, err = stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	from := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+	}
+
+	t.Run("calls are denied if client's IP is unknown", func(t *testing.T) {
+		client := newTestClient(t, NewController(ms, WithAccessPolicy(policy)))
+
+		_, err := client.DBStatus(forwardedFor("192.168.1.10"), &pb.Empty{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Equal(t, codes.PermissionDenied, status.Code(sendStream(context.Background(), client)))
+	})
+
+	t.Run("calls are allowed by peer address", func(t *testing.T) {
+		allowed := newTestClientFrom(t, NewController(ms, WithAccessPolicy(policy)), from("192.168.1.10"))
+		_, err := allowed.DBStatus(context.Background(), &pb.Empty{})
+		assert.NoError(t, err)
+		assert.NoError(t, sendStream(context.Background(), allowed))
+
+		denied := newTestClientFrom(t, NewController(ms, WithAccessPolicy(policy)), from("10.0.13.5"))
+		_, err = denied.DBStatus(context.Background(), &pb.Empty{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Equal(t, codes.PermissionDenied, status.Code(sendStream(context.Background(), denied)))
+	})
+
+	t.Run("proxy header is trusted only from trusted proxy", func(t *testing.T) {
+		proxied := newTestClientFrom(t, NewController(ms, WithAccessPolicy(policy)), from("10.0.0.1"))
+		_, err := proxied.DBStatus(forwardedFor("192.168.1.10"), &pb.Empty{})
+		assert.NoError(t, err)
+		_, err = proxied.DBStatus(forwardedFor("172.16.0.1"), &pb.Empty{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		spoofed := newTestClientFrom(t, NewController(ms, WithAccessPolicy(policy)), from("172.16.0.1"))
+		_, err = spoofed.DBStatus(forwardedFor("192.168.1.10"), &pb.Empty{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("calls are allowed without access policy", func(t *testing.T) {
+		client := newTestClient(t, NewController(ms))
+
+		_, err := client.DBStatus(context.Background(), &pb.Empty{})
+		assert.NoError(t, err)
+	})
+}
+
+// newTestClient serves controller in memory, returning client connected to it
+func newTestClient(t *testing.T, c *Controller, options ...grpc.DialOption) pb.MetricServiceClient {
+	return newTestClientFrom(t, c, nil, options...)
+}
+
+// newTestClientFrom is like newTestClient, but controller sees client connected from given address (if not nil)
+func newTestClientFrom(t *testing.T, c *Controller, addr net.Addr, options ...grpc.DialOption) pb.MetricServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	pb.RegisterMetricServiceServer(c.srv, c)
+	go func() {
+		if addr == nil {
+			_ = c.srv.Serve(listener)
+			return
+		}
+		_ = c.srv.Serve(addrListener{Listener: listener, addr: addr})
+	}()
+	t.Cleanup(c.srv.Stop)
+
+	options = append(options,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.Dial("bufnet", options...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return pb.NewMetricServiceClient(conn)
+}
+
+// addrListener accepts connections with overridden remote address
+type addrListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (l addrListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return addrConn{Conn: conn, addr: l.addr}, nil
+}
+
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// signed returns protobuf message of metric with hash
+func signed(t *testing.T, h dto.Hasher, m dto.Metric) *pb.Metric {
+	var err error
+	m.Hash, err = h.Hash(m)
+	require.NoError(t, err)
+	pm, err := m.Proto()
+	require.NoError(t, err)
+	return pm
+}
+
+func counterValue(t *testing.T, ms *services.MetricService, name string) int64 {
+	m, err := ms.Get(context.Background(), name, nil, model.COUNTER)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	return m.Val().(int64)
+}
@@ -0,0 +1,66 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/tony-spark/metrico/internal/server/access"
+)
+
+// accessUnaryInterceptor allows calls only from clients allowed by access policy
+func (c *Controller) accessUnaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := c.checkClient(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// accessStreamInterceptor allows streams only from clients allowed by access policy
+func (c *Controller) accessStreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := c.checkClient(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// checkClient returns error if client address is unknown or not allowed
+func (c *Controller) checkClient(ctx context.Context) error {
+	var forwarded []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		forwarded = md.Get(strings.ToLower(c.policy.Header()))
+	}
+	err := c.policy.Check(c.policy.ClientIP(peerIP(ctx), forwarded))
+	if errors.Is(err, access.ErrUnknownClient) {
+		return status.Error(codes.PermissionDenied, "unknown client's IP")
+	}
+	if err != nil {
+		log.Info().Err(err).Msg("client not trusted, aborting call")
+		return status.Error(codes.PermissionDenied, "client's IP is not allowed")
+	}
+	return nil
+}
+
+// peerIP returns address of peer (nil if unknown)
+func peerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+	if addr, ok := p.Addr.(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -3,6 +3,7 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -15,6 +16,8 @@ import (
 
 	"github.com/tony-spark/metrico/internal/dto"
 	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/access"
+	"github.com/tony-spark/metrico/internal/server/alerting"
 	"github.com/tony-spark/metrico/internal/server/models"
 	"github.com/tony-spark/metrico/internal/server/services"
 	"github.com/tony-spark/metrico/internal/server/web"
@@ -33,7 +36,9 @@ type Controller struct {
 	dbm           models.DBManager
 	h             dto.Hasher
 	d             crypto.Decryptor
-	trustedSubNet *net.IPNet
+	policy        access.Policy
+	alerts        *alerting.Engine
+	tlsConfig     *tls.Config
 }
 
 type Option func(r *Controller)
@@ -62,9 +67,23 @@ func WithDecryptor(d crypto.Decryptor) Option {
 	}
 }
 
-func WithTrustedSubNet(subnet *net.IPNet) Option {
+// WithAccessPolicy configures controller to serve only clients allowed by policy
+func WithAccessPolicy(p access.Policy) Option {
 	return func(r *Controller) {
-		r.trustedSubNet = subnet
+		r.policy = p
+	}
+}
+
+func WithAlerting(e *alerting.Engine) Option {
+	return func(r *Controller) {
+		r.alerts = e
+	}
+}
+
+// WithTLSConfig configures controller to serve HTTPS
+func WithTLSConfig(config *tls.Config) Option {
+	return func(r *Controller) {
+		r.tlsConfig = config
 	}
 }
 
@@ -81,9 +100,8 @@ func NewController(metricService *services.MetricService, options ...Option) *Co
 		opt(router)
 	}
 
-	r.Use(middleware.RealIP)
-	if router.trustedSubNet != nil {
-		r.Use(SubnetClientFilter(*router.trustedSubNet))
+	if router.policy.Restricts() || router.policy.TrustsProxies() {
+		r.Use(ClientFilter(router.policy))
 	}
 	r.Use(middleware.RequestID)
 	r.Use(httplog.RequestLogger(log.Logger))
@@ -109,24 +127,41 @@ func NewController(metricService *services.MetricService, options ...Option) *Co
 		r.Route("/gauge", func(r chi.Router) {
 			r.Get("/{name}", router.MetricGetHandler(model.GAUGE))
 		})
+		r.Route("/histogram", func(r chi.Router) {
+			r.Get("/{name}", router.MetricGetHandler(model.HISTOGRAM))
+		})
 		r.Post("/", router.GetPostHandler())
 		r.HandleFunc("/*", handleUnknown)
 	})
 	r.Get("/ping", router.PingHandler())
+	r.Get("/health", router.HealthHandler())
+	r.Get("/metrics", router.PrometheusHandler())
 	r.Route("/updates", func(r chi.Router) {
 		r.Post("/", router.BulkUpdatePostHandler())
 	})
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Get("/query_range", router.QueryRangeHandler())
+		r.Get("/sources", router.SourcesHandler())
+		r.Get("/alerts", router.AlertsHandler())
+	})
 
 	return router
 }
 
 func (c *Controller) Run() error {
 	c.srv = &http.Server{
-		Addr:    c.listenAddress,
-		Handler: c.r,
+		Addr:      c.listenAddress,
+		Handler:   c.r,
+		TLSConfig: c.tlsConfig,
 	}
 
-	err := c.srv.ListenAndServe()
+	var err error
+	if c.tlsConfig != nil {
+		// certificates are taken from TLSConfig
+		err = c.srv.ListenAndServeTLS("", "")
+	} else {
+		err = c.srv.ListenAndServe()
+	}
 	if err != http.ErrServerClosed && err != net.ErrClosed {
 		return fmt.Errorf("error running http server: %w", err)
 	}
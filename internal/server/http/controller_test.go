@@ -2,19 +2,30 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tony-spark/metrico/internal/server/services"
+	"google.golang.org/protobuf/proto"
 
+	pb "github.com/tony-spark/metrico/gen/pb/api"
+	"github.com/tony-spark/metrico/internal/crypto"
 	"github.com/tony-spark/metrico/internal/dto"
 	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/access"
+	"github.com/tony-spark/metrico/internal/server/alerting"
 	"github.com/tony-spark/metrico/internal/server/storage"
 )
 
@@ -160,6 +171,235 @@ func TestRouter(t *testing.T) {
 		statusCode, _ := testJSONRequest(t, ts, "POST", "/updates", msReq)
 		assert.Equal(t, http.StatusOK, statusCode)
 	})
+	t.Run("labeled series", func(t *testing.T) {
+		statusCode, _ := testRequest(t, ts, "POST", "/update/counter/LabeledCounter/3?label=host=a")
+		require.Equal(t, http.StatusOK, statusCode)
+		statusCode, _ = testRequest(t, ts, "POST", "/update/counter/LabeledCounter/5?label=host=b&label=dc=x")
+		require.Equal(t, http.StatusOK, statusCode)
+
+		statusCode, body := testRequest(t, ts, "GET", "/value/counter/LabeledCounter?label=host=a")
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, "3", body)
+		statusCode, _ = testRequest(t, ts, "GET", "/value/counter/LabeledCounter")
+		assert.Equal(t, http.StatusNotFound, statusCode)
+
+		mreq := dto.Metric{
+			ID:     "LabeledCounter",
+			MType:  model.COUNTER,
+			Labels: model.Labels{"dc": "x", "host": "b"},
+		}
+		statusCode, mresp := testMetricRequest(t, ts, "POST", "/value", mreq)
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, int64(5), *mresp.Delta)
+		assert.Equal(t, mreq.Labels, mresp.Labels)
+	})
+	t.Run("histogram update", func(t *testing.T) {
+		mreq := dto.Metric{
+			ID:        "TestHistogram",
+			MType:     model.HISTOGRAM,
+			Histogram: &model.Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 0, 2}, Count: 3, Sum: 10.05},
+		}
+		statusCode, _ := testJSONRequest(t, ts, "POST", "/updates", []dto.Metric{mreq})
+		require.Equal(t, http.StatusOK, statusCode)
+		statusCode, mresp := testMetricRequest(t, ts, "POST", "/update", mreq)
+		require.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, []int64{2, 0, 4}, mresp.Histogram.Counts)
+
+		statusCode, body := testRequest(t, ts, "GET", "/value/histogram/TestHistogram")
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, "count=6 sum=20.1 buckets=[0.1:2 1:0 +Inf:4]", body)
+	})
+	t.Run("invalid histogram", func(t *testing.T) {
+		mreq := dto.Metric{
+			ID:        "TestHistogram",
+			MType:     model.HISTOGRAM,
+			Histogram: &model.Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 0}, Count: 1, Sum: 0.05},
+		}
+		statusCode, _ := testJSONRequest(t, ts, "POST", "/update", mreq)
+		assert.Equal(t, http.StatusBadRequest, statusCode)
+	})
+	t.Run("invalid label", func(t *testing.T) {
+		statusCode, _ := testRequest(t, ts, "POST", "/update/gauge/LabeledGauge/1?label=host")
+		assert.Equal(t, http.StatusBadRequest, statusCode)
+	})
+}
+
+func TestQueryRange(t *testing.T) {
+	t.Run("history not kept", func(t *testing.T) {
+		r := NewController(services.NewMetricService(storage.NewSingleValueRepository(), nil))
+		ts := httptest.NewServer(r.r)
+		defer ts.Close()
+
+		statusCode, _ := testRequest(t, ts, "GET", "/api/v1/query_range?name=test&type=gauge")
+		assert.Equal(t, http.StatusNotImplemented, statusCode)
+	})
+
+	r := NewController(services.NewMetricService(storage.NewHistoryRepository(), nil))
+	ts := httptest.NewServer(r.r)
+	defer ts.Close()
+
+	from := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	for _, v := range []string{"1", "5", "3"} {
+		statusCode, _ := testRequest(t, ts, "POST", "/update/gauge/RangeGauge/"+v)
+		require.Equal(t, http.StatusOK, statusCode)
+		statusCode, _ = testRequest(t, ts, "POST", "/update/counter/RangeCounter/"+v)
+		require.Equal(t, http.StatusOK, statusCode)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected float64
+	}{
+		{"gauge last", "name=RangeGauge&type=gauge", 3},
+		{"gauge min", "name=RangeGauge&type=gauge&agg=min", 1},
+		{"gauge max", "name=RangeGauge&type=gauge&agg=max", 5},
+		{"gauge avg", "name=RangeGauge&type=gauge&agg=avg", 3},
+		{"counter sum", "name=RangeCounter&type=counter", 9},
+		{"counter rate", "name=RangeCounter&type=counter&agg=rate&step=3m", 9.0 / 180},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusCode, body := testRequest(t, ts, "GET", "/api/v1/query_range?from="+from+"&"+tt.query)
+			require.Equal(t, http.StatusOK, statusCode)
+			var result dto.RangeResult
+			require.NoError(t, json.Unmarshal([]byte(body), &result))
+			require.NotEmpty(t, result.Points)
+			var v float64
+			for _, p := range result.Points {
+				v += p.Value
+			}
+			assert.InDelta(t, tt.expected, v, 1e-9)
+		})
+	}
+	t.Run("wrong aggregation", func(t *testing.T) {
+		statusCode, _ := testRequest(t, ts, "GET", "/api/v1/query_range?name=RangeGauge&type=gauge&agg=rate")
+		assert.Equal(t, http.StatusBadRequest, statusCode)
+	})
+	t.Run("too many points", func(t *testing.T) {
+		statusCode, _ := testRequest(t, ts, "GET", "/api/v1/query_range?name=RangeGauge&type=gauge&step=1ms")
+		assert.Equal(t, http.StatusBadRequest, statusCode)
+	})
+	t.Run("no metric name", func(t *testing.T) {
+		statusCode, _ := testRequest(t, ts, "GET", "/api/v1/query_range?type=gauge")
+		assert.Equal(t, http.StatusBadRequest, statusCode)
+	})
+}
+
+func TestPrometheus(t *testing.T) {
+	mr := storage.NewSingleValueRepository()
+	r := NewController(services.NewMetricService(mr, nil))
+	ts := httptest.NewServer(r.r)
+	defer ts.Close()
+
+	for _, path := range []string{
+		"/update/gauge/Alloc/1.5",
+		"/update/counter/PollCount/10",
+		"/update/gauge/cpu.load-1/0.25",
+		"/update/gauge/1st/2",
+		"/update/counter/Requests/3?label=path=/a",
+		"/update/counter/Requests/4?label=path=/b&label=code=200",
+	} {
+		statusCode, _ := testRequest(t, ts, "POST", path)
+		require.Equal(t, http.StatusOK, statusCode)
+	}
+
+	statusCode, _ := testJSONRequest(t, ts, "POST", "/update", dto.Metric{
+		ID:        "Latency",
+		MType:     model.HISTOGRAM,
+		Labels:    model.Labels{"path": "/a"},
+		Histogram: &model.Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 3}, Count: 6, Sum: 12.5},
+	})
+	require.Equal(t, http.StatusOK, statusCode)
+
+	statusCode, body := testRequest(t, ts, "GET", "/metrics")
+	require.Equal(t, http.StatusOK, statusCode)
+	expected := `# HELP Alloc gauge Alloc
+# TYPE Alloc gauge
+Alloc 1.5
+# HELP Latency histogram Latency
+# TYPE Latency histogram
+Latency_bucket{le="0.1",path="/a"} 1
+Latency_bucket{le="1",path="/a"} 3
+Latency_bucket{le="+Inf",path="/a"} 6
+Latency_sum{path="/a"} 12.5
+Latency_count{path="/a"} 6
+# HELP PollCount counter PollCount
+# TYPE PollCount counter
+PollCount 10
+# HELP Requests counter Requests
+# TYPE Requests counter
+Requests{code="200",path="/b"} 4
+Requests{path="/a"} 3
+# HELP _1st gauge 1st
+# TYPE _1st gauge
+_1st 2
+# HELP cpu_load_1 gauge cpu.load-1
+# TYPE cpu_load_1 gauge
+cpu_load_1 0.25
+`
+	assert.Equal(t, expected, body)
+}
+
+func TestSources(t *testing.T) {
+	mr := storage.NewSingleValueRepository()
+	r := NewController(services.NewMetricService(mr, nil))
+	ts := httptest.NewServer(r.r)
+	defer ts.Close()
+
+	send := func(agentID string, path string) {
+		req, err := http.NewRequest("POST", ts.URL+path, nil)
+		require.NoError(t, err)
+		if len(agentID) > 0 {
+			req.Header.Set("X-Agent-ID", agentID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	send("agent1", "/update/counter/PollCount/1")
+	send("agent2", "/update/counter/PollCount/5")
+	send("agent2", "/update/gauge/Alloc/1.5")
+	send("", "/update/counter/PollCount/7")
+
+	t.Run("counters are not summed across agents", func(t *testing.T) {
+		for _, tt := range []struct {
+			query    string
+			expected string
+		}{
+			{"?source=agent1", "1"},
+			{"?label=source=agent2", "5"},
+			{"", "7"},
+		} {
+			statusCode, body := testRequest(t, ts, "GET", "/value/counter/PollCount"+tt.query)
+			assert.Equal(t, http.StatusOK, statusCode)
+			assert.Equal(t, tt.expected, body)
+		}
+	})
+	t.Run("sources", func(t *testing.T) {
+		statusCode, body := testRequest(t, ts, "GET", "/api/v1/sources")
+		require.Equal(t, http.StatusOK, statusCode)
+		var sources []dto.Source
+		require.NoError(t, json.Unmarshal([]byte(body), &sources))
+		for i := range sources {
+			assert.False(t, sources[i].LastSeen.IsZero())
+			sources[i].LastSeen = time.Time{}
+		}
+		assert.Equal(t, []dto.Source{
+			{Source: "", Gauges: 0, Counters: 1},
+			{Source: "agent1", Gauges: 0, Counters: 1},
+			{Source: "agent2", Gauges: 1, Counters: 1},
+		}, sources)
+	})
+	t.Run("prometheus filtered by source", func(t *testing.T) {
+		statusCode, body := testRequest(t, ts, "GET", "/metrics?source=agent1")
+		require.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, `# HELP PollCount counter PollCount
+# TYPE PollCount counter
+PollCount{source="agent1"} 1
+`, body)
+	})
 }
 
 func testRequest(t *testing.T, ts *httptest.Server, method, path string) (int, string) {
@@ -213,3 +453,311 @@ func marshal(t *testing.T, obj interface{}) []byte {
 
 	return b
 }
+
+func TestAlerts(t *testing.T) {
+	mr := storage.NewSingleValueRepository()
+	ms := services.NewMetricService(mr, nil)
+
+	t.Run("not configured", func(t *testing.T) {
+		ts := httptest.NewServer(NewController(ms).r)
+		defer ts.Close()
+		statusCode, _ := testRequest(t, ts, "GET", "/api/v1/alerts")
+		assert.Equal(t, http.StatusNotImplemented, statusCode)
+	})
+	t.Run("alerts", func(t *testing.T) {
+		e, err := alerting.NewEngine([]alerting.Rule{
+			{Name: "LowMemory", Metric: "FreeMemory", Type: model.GAUGE, Op: "<", Threshold: 100},
+		})
+		require.NoError(t, err)
+		ms.AddListener(e.Observe)
+		ts := httptest.NewServer(NewController(ms, WithAlerting(e)).r)
+		defer ts.Close()
+
+		statusCode, _ := testRequest(t, ts, "POST", "/update/gauge/FreeMemory/10")
+		require.Equal(t, http.StatusOK, statusCode)
+
+		statusCode, body := testRequest(t, ts, "GET", "/api/v1/alerts")
+		require.Equal(t, http.StatusOK, statusCode)
+		var alerts []alerting.Alert
+		require.NoError(t, json.Unmarshal([]byte(body), &alerts))
+		require.Len(t, alerts, 1)
+		assert.Equal(t, "LowMemory", alerts[0].Rule)
+		assert.Equal(t, alerting.StateFiring, alerts[0].State)
+		assert.Equal(t, 10.0, alerts[0].Value)
+	})
+}
+
+func TestStaleness(t *testing.T) {
+	mr := storage.NewSingleValueRepository()
+	ms := services.NewMetricService(mr, nil, services.WithStaleAfter(100*time.Millisecond))
+	ts := httptest.NewServer(NewController(ms).r)
+	defer ts.Close()
+
+	send := func(agentID string, path string) {
+		req, err := http.NewRequest("POST", ts.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Agent-ID", agentID)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	send("agent1", "/update/gauge/RandomValue/0.5")
+	time.Sleep(150 * time.Millisecond)
+	send("agent2", "/update/gauge/RandomValue/0.7")
+
+	t.Run("sources", func(t *testing.T) {
+		statusCode, body := testRequest(t, ts, "GET", "/api/v1/sources")
+		require.Equal(t, http.StatusOK, statusCode)
+		var sources []dto.Source
+		require.NoError(t, json.Unmarshal([]byte(body), &sources))
+		require.Len(t, sources, 2)
+		assert.True(t, sources[0].Stale)
+		assert.False(t, sources[1].Stale)
+		assert.True(t, sources[0].LastSeen.Before(sources[1].LastSeen))
+	})
+	t.Run("value", func(t *testing.T) {
+		for _, tt := range []struct {
+			source string
+			stale  bool
+		}{
+			{"agent1", true},
+			{"agent2", false},
+		} {
+			statusCode, mdto := testMetricRequest(t, ts, "POST", "/value/", dto.Metric{
+				ID:     "RandomValue",
+				MType:  model.GAUGE,
+				Labels: model.Labels{model.SourceLabel: tt.source},
+			})
+			require.Equal(t, http.StatusOK, statusCode)
+			assert.Equal(t, tt.stale, mdto.Stale)
+			assert.NotNil(t, mdto.UpdatedAt)
+		}
+	})
+	t.Run("stale metrics are not exported to prometheus", func(t *testing.T) {
+		statusCode, body := testRequest(t, ts, "GET", "/metrics")
+		require.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, `# HELP RandomValue gauge RandomValue
+# TYPE RandomValue gauge
+RandomValue{source="agent2"} 0.7
+`, body)
+	})
+	t.Run("view", func(t *testing.T) {
+		statusCode, body := testRequest(t, ts, "GET", "/")
+		require.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, 1, strings.Count(body, "(stale)"))
+	})
+}
+
+func TestBatchReplay(t *testing.T) {
+	mr := storage.NewSingleValueRepository()
+	ms := services.NewMetricService(mr, nil, services.WithDedupWindow(time.Minute))
+	ts := httptest.NewServer(NewController(ms).r)
+	defer ts.Close()
+
+	delta := int64(1)
+	batch := dto.Batch{
+		ID:      "batch1",
+		Metrics: []dto.Metric{{ID: "PollCount", MType: model.COUNTER, Delta: &delta}},
+	}
+	counter := func(t *testing.T) int64 {
+		statusCode, mdto := testMetricRequest(t, ts, "POST", "/value/", dto.Metric{ID: "PollCount", MType: model.COUNTER})
+		require.Equal(t, http.StatusOK, statusCode)
+		require.NotNil(t, mdto.Delta)
+		return *mdto.Delta
+	}
+
+	t.Run("replayed batch is not applied", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			statusCode, _ := testJSONRequest(t, ts, "POST", "/updates/", batch)
+			require.Equal(t, http.StatusOK, statusCode)
+		}
+		assert.Equal(t, int64(1), counter(t))
+	})
+	t.Run("new batch is applied", func(t *testing.T) {
+		batch.ID = "batch2"
+		statusCode, _ := testJSONRequest(t, ts, "POST", "/updates/", batch)
+		require.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, int64(2), counter(t))
+	})
+	t.Run("batch without id", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			statusCode, _ := testJSONRequest(t, ts, "POST", "/updates/", batch.Metrics)
+			require.Equal(t, http.StatusOK, statusCode)
+		}
+		assert.Equal(t, int64(4), counter(t))
+	})
+}
+
+func TestRequestBodyEncoding(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	encryptor := crypto.NewHybridEncryptor(&privateKey.PublicKey, "metrico")
+	legacyEncryptor := crypto.NewRSAEncryptor(&privateKey.PublicKey, "metrico")
+
+	ms := services.NewMetricService(storage.NewSingleValueRepository(), nil)
+	ts := httptest.NewServer(NewController(ms, WithDecryptor(crypto.NewHybridDecryptor(privateKey, "metrico"))).r)
+	defer ts.Close()
+	plainTS := httptest.NewServer(NewController(ms).r)
+	defer plainTS.Close()
+
+	value := 1.0
+	metric := dto.Metric{ID: "Alloc", MType: model.GAUGE, Value: &value}
+	encrypt := func(t *testing.T, e crypto.Encryptor, bs []byte) []byte {
+		encrypted, err := e.Encrypt(bs)
+		require.NoError(t, err)
+		return encrypted
+	}
+	compress := func(t *testing.T, bs []byte) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write(bs)
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		return buf.Bytes()
+	}
+	post := func(t *testing.T, ts *httptest.Server, path string, body []byte, headers map[string]string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	encrypted := map[string]string{encryptedHeader: "true"}
+
+	t.Run("plain", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, post(t, plainTS, "/update/", marshal(t, metric), nil))
+		assert.Equal(t, http.StatusOK, post(t, plainTS, "/updates/", marshal(t, []dto.Metric{metric}), nil))
+	})
+	t.Run("plain with decryptor", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post(t, ts, "/update/", marshal(t, metric), nil))
+		assert.Equal(t, http.StatusBadRequest, post(t, ts, "/updates/", marshal(t, []dto.Metric{metric}), nil))
+		assert.Equal(t, http.StatusBadRequest, post(t, ts, "/update/gauge/Alloc/1", nil, nil))
+		assert.Equal(t, http.StatusOK, post(t, ts, "/value/", marshal(t, metric), nil), "queries are not encrypted")
+	})
+	t.Run("encrypted", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, post(t, ts, "/update/", encrypt(t, encryptor, marshal(t, metric)), encrypted))
+		assert.Equal(t, http.StatusOK, post(t, ts, "/updates/", encrypt(t, encryptor, marshal(t, []dto.Metric{metric})), encrypted))
+		assert.Equal(t, http.StatusOK, post(t, ts, "/updates/", encrypt(t, legacyEncryptor, marshal(t, []dto.Metric{metric})), encrypted))
+	})
+	t.Run("compressed", func(t *testing.T) {
+		headers := map[string]string{"Content-Encoding": "gzip"}
+		assert.Equal(t, http.StatusOK, post(t, plainTS, "/updates/", compress(t, marshal(t, []dto.Metric{metric})), headers))
+		headers[encryptedHeader] = "true"
+		body := compress(t, encrypt(t, encryptor, marshal(t, []dto.Metric{metric})))
+		assert.Equal(t, http.StatusOK, post(t, ts, "/updates/", body, headers))
+	})
+	t.Run("encrypted without header", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post(t, ts, "/updates/", encrypt(t, encryptor, marshal(t, []dto.Metric{metric})), nil))
+	})
+	t.Run("encrypted without decryptor", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post(t, plainTS, "/updates/", encrypt(t, encryptor, marshal(t, []dto.Metric{metric})), encrypted))
+	})
+	t.Run("unsupported encoding", func(t *testing.T) {
+		headers := map[string]string{"Content-Encoding": "br"}
+		assert.Equal(t, http.StatusUnsupportedMediaType, post(t, ts, "/updates/", marshal(t, []dto.Metric{metric}), headers))
+	})
+}
+
+func TestProtobufBody(t *testing.T) {
+	mr := storage.NewSingleValueRepository()
+	ts := httptest.NewServer(NewController(services.NewMetricService(mr, nil)).r)
+	defer ts.Close()
+
+	post := func(t *testing.T, path string, contentType string, batch *pb.Batch) int {
+		bs, err := proto.Marshal(batch)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(bs))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	value := 2.5
+	delta := int64(3)
+	gauge := &pb.Metric{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: &value}
+	counter := &pb.Metric{Id: "PollCount", Type: pb.MetricType_COUNTER, Delta: &delta}
+
+	t.Run("batch", func(t *testing.T) {
+		statusCode := post(t, "/updates/", "application/x-protobuf", &pb.Batch{Id: "batch1", Metrics: []*pb.Metric{gauge, counter}})
+		require.Equal(t, http.StatusOK, statusCode)
+		statusCode, mdto := testMetricRequest(t, ts, "POST", "/value/", dto.Metric{ID: "Alloc", MType: model.GAUGE})
+		require.Equal(t, http.StatusOK, statusCode)
+		require.NotNil(t, mdto.Value)
+		assert.Equal(t, value, *mdto.Value)
+	})
+	t.Run("single metric", func(t *testing.T) {
+		statusCode := post(t, "/update/", "application/x-protobuf", &pb.Batch{Metrics: []*pb.Metric{counter}})
+		require.Equal(t, http.StatusOK, statusCode)
+		statusCode, mdto := testMetricRequest(t, ts, "POST", "/value/", dto.Metric{ID: "PollCount", MType: model.COUNTER})
+		require.Equal(t, http.StatusOK, statusCode)
+		require.NotNil(t, mdto.Delta)
+		assert.Equal(t, 2*delta, *mdto.Delta)
+	})
+	t.Run("several metrics to single metric endpoint", func(t *testing.T) {
+		statusCode := post(t, "/update/", "application/x-protobuf", &pb.Batch{Metrics: []*pb.Metric{gauge, counter}})
+		assert.Equal(t, http.StatusBadRequest, statusCode)
+	})
+	t.Run("metric without value", func(t *testing.T) {
+		statusCode := post(t, "/updates/", "application/x-protobuf", &pb.Batch{Metrics: []*pb.Metric{{Id: "Alloc"}}})
+		assert.Equal(t, http.StatusBadRequest, statusCode)
+	})
+	t.Run("unsupported content type", func(t *testing.T) {
+		statusCode := post(t, "/updates/", "application/xml", &pb.Batch{Metrics: []*pb.Metric{gauge}})
+		assert.Equal(t, http.StatusUnsupportedMediaType, statusCode)
+	})
+}
+
+func TestClientFilter(t *testing.T) {
+	subnets := func(ss ...string) []*net.IPNet {
+		parsed, err := access.ParseSubnets(ss)
+		require.NoError(t, err)
+		return parsed
+	}
+	policy := access.NewPolicy(
+		access.WithAllowed(subnets("192.168.1.0/24", "10.0.0.0/8")...),
+		access.WithDenied(subnets("10.0.13.0/24")...),
+		access.WithTrustedProxies(subnets("10.0.0.1")...),
+	)
+	var remoteAddr string
+	filter := ClientFilter(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       int
+		wantAddr   string
+	}{
+		{name: "allowed peer with port", remoteAddr: "192.168.1.10:51234", want: http.StatusOK, wantAddr: "192.168.1.10:51234"},
+		{name: "denied peer", remoteAddr: "10.0.13.5:51234", want: http.StatusForbidden},
+		{name: "peer not allowed", remoteAddr: "172.16.0.1:51234", want: http.StatusForbidden},
+		{name: "unknown peer", remoteAddr: "pipe", want: http.StatusForbidden},
+		{name: "header from untrusted peer", remoteAddr: "172.16.0.1:51234", forwarded: "192.168.1.10", want: http.StatusForbidden},
+		{name: "allowed client behind trusted proxy", remoteAddr: "10.0.0.1:51234", forwarded: "172.16.0.1, 192.168.1.10", want: http.StatusOK, wantAddr: "192.168.1.10"},
+		{name: "denied client behind trusted proxy", remoteAddr: "10.0.0.1:51234", forwarded: "10.0.13.5", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remoteAddr = ""
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if len(tt.forwarded) > 0 {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			filter.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code)
+			assert.Equal(t, tt.wantAddr, remoteAddr)
+		})
+	}
+}
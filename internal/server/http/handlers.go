@@ -1,80 +1,215 @@
 package http
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"mime"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
 
+	pb "github.com/tony-spark/metrico/gen/pb/api"
 	"github.com/tony-spark/metrico/internal/dto"
 	"github.com/tony-spark/metrico/internal/model"
 	"github.com/tony-spark/metrico/internal/server/models"
+	"github.com/tony-spark/metrico/internal/server/services"
+)
+
+const (
+	// labelParam is a name of query parameter holding metric label in key=value form, may be repeated
+	labelParam = "label"
+	// sourceParam is a name of query parameter holding agent identifier, a shortcut for label=source=...
+	sourceParam = "source"
+	// agentIDHeader is a name of header holding identifier of agent sending metrics
+	agentIDHeader = "X-Agent-ID"
+	// encryptedHeader is a name of header telling whether request body is encrypted
+	encryptedHeader = "X-Encrypted"
+	// maxBodySize is a limit of request body size (after decompression)
+	maxBodySize = 32 << 20
+)
+
+// Supported request body formats
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf" // pb.Batch message
+)
+
+const (
+	defaultRangeStep   = time.Minute
+	defaultRangeLength = time.Hour
 )
 
 func checkContentType(w http.ResponseWriter, r *http.Request) error {
 	ctype := r.Header.Get("Content-Type")
 	t, _, err := mime.ParseMediaType(ctype)
-	if err != nil || t != "application/json" {
+	if err != nil || t != contentTypeJSON {
 		http.Error(w, "Only application/json supported", http.StatusUnsupportedMediaType)
 		return fmt.Errorf("could not check content type: %w", err)
 	}
 	return nil
 }
 
-func readMetric(w http.ResponseWriter, r *http.Request) (*dto.Metric, error) {
-	defer r.Body.Close()
-	body, err := io.ReadAll(r.Body)
+// bodyFormat returns format of request body of update requests: JSON or protobuf
+func bodyFormat(w http.ResponseWriter, r *http.Request) (string, error) {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (t != contentTypeJSON && t != contentTypeProtobuf) {
+		http.Error(w, "Only application/json and application/x-protobuf supported", http.StatusUnsupportedMediaType)
+		return "", fmt.Errorf("unsupported content type: %s", r.Header.Get("Content-Type"))
+	}
+	return t, nil
+}
+
+// readMetric reads metric from request body in given format. Protobuf body is a batch of single metric
+func (c Controller) readMetric(w http.ResponseWriter, r *http.Request, format string, update bool) (*dto.Metric, error) {
+	body, err := c.readBody(w, r, update)
 	if err != nil {
-		http.Error(w, "Could not read body", http.StatusBadRequest)
 		return nil, fmt.Errorf("failed to read metric from request: %w", err)
 	}
 	var m dto.Metric
-	err = json.Unmarshal(body, &m)
-	if err != nil {
-		http.Error(w, "Could not parse json", http.StatusBadRequest)
-		return nil, fmt.Errorf("failed to read metric from request: %w", err)
+	if format == contentTypeProtobuf {
+		var batch pb.Batch
+		err = proto.Unmarshal(body, &batch)
+		if err == nil && len(batch.Metrics) != 1 {
+			err = fmt.Errorf("batch contains %d metrics instead of one", len(batch.Metrics))
+		}
+		if err != nil {
+			http.Error(w, "Could not parse protobuf", http.StatusBadRequest)
+			return nil, fmt.Errorf("failed to read metric from request: %w", err)
+		}
+		m = dto.FromProto(batch.Metrics[0])
+	} else {
+		err = json.Unmarshal(body, &m)
+		if err != nil {
+			http.Error(w, "Could not parse json", http.StatusBadRequest)
+			return nil, fmt.Errorf("failed to read metric from request: %w", err)
+		}
 	}
-	if m.MType != model.GAUGE && m.MType != model.COUNTER {
-		http.Error(w, "Unknown metric type", http.StatusBadRequest)
-		return nil, fmt.Errorf("unknown metric type: %v", m.MType)
+	if err = checkMetric(m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, fmt.Errorf("failed to read metric from request: %w", err)
 	}
 	return &m, nil
 }
 
-func (c Controller) readMetrics(w http.ResponseWriter, r *http.Request) ([]dto.Metric, error) {
+// readBody reads request body, decompressing it if Content-Encoding is gzip and decrypting it if X-Encrypted is true.
+// If controller has decryptor, body of update must be encrypted (like metrics sent to gRPC controller)
+func (c Controller) readBody(w http.ResponseWriter, r *http.Request, update bool) ([]byte, error) {
 	defer r.Body.Close()
-	body, err := io.ReadAll(r.Body)
+	var reader io.Reader = r.Body
+	switch encoding := strings.ToLower(r.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "Could not decompress body", http.StatusBadRequest)
+			return nil, fmt.Errorf("could not decompress body: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	default:
+		http.Error(w, "Unsupported content encoding", http.StatusUnsupportedMediaType)
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	body, err := io.ReadAll(io.LimitReader(reader, maxBodySize+1))
 	if err != nil {
 		http.Error(w, "Could not read body", http.StatusBadRequest)
-		return nil, fmt.Errorf("failed to read metrics from request: %w", err)
+		return nil, fmt.Errorf("could not read body: %w", err)
 	}
-	if c.d != nil {
-		body, err = c.d.Decrypt(body)
-		if err != nil {
-			http.Error(w, "Could not decrypt body", http.StatusInternalServerError)
-			return nil, fmt.Errorf("failed to decrypt request: %w", err)
+	if len(body) > maxBodySize {
+		http.Error(w, "Body is too large", http.StatusRequestEntityTooLarge)
+		return nil, fmt.Errorf("body exceeds %d bytes", maxBodySize)
+	}
+	if encrypted, _ := strconv.ParseBool(r.Header.Get(encryptedHeader)); !encrypted {
+		if update {
+			if err = c.checkEncrypted(w); err != nil {
+				return nil, err
+			}
 		}
+		return body, nil
+	}
+	if c.d == nil {
+		http.Error(w, "Encryption is not supported", http.StatusBadRequest)
+		return nil, fmt.Errorf("body is encrypted, but decryptor is not configured")
+	}
+	body, err = c.d.Decrypt(body)
+	if err != nil {
+		http.Error(w, "Could not decrypt body", http.StatusBadRequest)
+		return nil, fmt.Errorf("could not decrypt body: %w", err)
 	}
-	var ms []dto.Metric
-	err = json.Unmarshal(body, &ms)
+	return body, nil
+}
+
+// readBatch reads batch of metrics from request in given format. JSON body is either a dto.Batch or array of metrics
+// (batch without ID), protobuf body is a pb.Batch
+func (c Controller) readBatch(w http.ResponseWriter, r *http.Request, format string) (dto.Batch, error) {
+	var batch dto.Batch
+	body, err := c.readBody(w, r, true)
 	if err != nil {
-		http.Error(w, "Could not parse json", http.StatusBadRequest)
-		return nil, fmt.Errorf("failed to read metrics from request: %w", err)
+		return batch, fmt.Errorf("failed to read metrics from request: %w", err)
+	}
+	if format == contentTypeProtobuf {
+		var pbatch pb.Batch
+		err = proto.Unmarshal(body, &pbatch)
+		if err != nil {
+			http.Error(w, "Could not parse protobuf", http.StatusBadRequest)
+			return batch, fmt.Errorf("failed to read metrics from request: %w", err)
+		}
+		batch = dto.BatchFromProto(&pbatch)
+	} else {
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+			err = json.Unmarshal(body, &batch)
+		} else {
+			err = json.Unmarshal(body, &batch.Metrics)
+		}
+		if err != nil {
+			http.Error(w, "Could not parse json", http.StatusBadRequest)
+			return batch, fmt.Errorf("failed to read metrics from request: %w", err)
+		}
 	}
-	for _, m := range ms {
-		if m.MType != model.GAUGE && m.MType != model.COUNTER {
-			http.Error(w, "Unknown metric type", http.StatusBadRequest)
-			return nil, fmt.Errorf("unknown metric type: %v", m.MType)
+	for _, m := range batch.Metrics {
+		if err = checkMetric(m); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return batch, fmt.Errorf("failed to read metrics from request: %w", err)
 		}
 	}
-	return ms, nil
+	return batch, nil
+}
+
+// checkEncrypted returns error (and responds with it) if controller has decryptor, which means that only encrypted
+// updates are accepted
+func (c Controller) checkEncrypted(w http.ResponseWriter) error {
+	if c.d == nil {
+		return nil
+	}
+	http.Error(w, "Unencrypted metrics are not accepted", http.StatusBadRequest)
+	return fmt.Errorf("metrics are not encrypted, but decryptor is configured")
+}
+
+// checkMetric checks metric type and consistency of histogram value (if any)
+func checkMetric(m dto.Metric) error {
+	switch m.MType {
+	case model.GAUGE, model.COUNTER:
+		return nil
+	case model.HISTOGRAM:
+		if m.Histogram == nil {
+			return nil
+		}
+		return m.Histogram.Validate()
+	default:
+		return fmt.Errorf("unknown metric type: %v", m.MType)
+	}
 }
 
 func (c Controller) checkHash(mdto dto.Metric, w http.ResponseWriter) bool {
@@ -95,18 +230,23 @@ func (c Controller) checkHash(mdto dto.Metric, w http.ResponseWriter) bool {
 
 // UpdatePostHandler godoc
 // @Summary Update metric value
-// @Accepts json
+// @Accepts json,application/x-protobuf
 // @Produce json
+// @Description Protobuf body is a Batch message with a single metric (see proto/metrico.proto)
 // @Param metric_data body dto.Metric true "Metric's data"
 // @Success 200 {object} dto.Metric
+// @Param X-Agent-ID header string false "Agent identifier, recorded as source label"
+// @Param X-Encrypted header bool false "Whether body is encrypted with server public key (required if server has private key)"
+// @Param Content-Encoding header string false "Body compression" Enums(gzip)
 // @Router /update [post]
 func (c Controller) UpdatePostHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := checkContentType(w, r); err != nil {
+		format, err := bodyFormat(w, r)
+		if err != nil {
 			log.Error().Err(err).Msg("Wrong content type")
 			return
 		}
-		mdto, err := readMetric(w, r)
+		mdto, err := c.readMetric(w, r, format, true)
 		if err != nil {
 			log.Error().Err(err).Msg("Could not parse metric")
 			return
@@ -118,6 +258,7 @@ func (c Controller) UpdatePostHandler() http.HandlerFunc {
 			http.Error(w, "metric value is null", http.StatusBadRequest)
 			return
 		}
+		mdto.Labels = withSource(r, mdto.Labels)
 		mvalue := models.FromDTO(*mdto)
 		updated, err := c.ms.UpdateMetric(context.Background(), mvalue)
 		if err != nil {
@@ -140,20 +281,28 @@ func (c Controller) UpdatePostHandler() http.HandlerFunc {
 
 // BulkUpdatePostHandler godoc
 // @Summary Update metric value of multiple metrics
-// @Accepts json
+// @Accepts json,application/x-protobuf
 // @Produce json
-// @Param metric_data body []dto.Metric true "Metric's data"
+// @Description Body is either a batch or an array of metrics (batch without ID).
+// @Description Batch which ID was already applied recently is acknowledged without being applied again.
+// @Description Protobuf body is a Batch message (see proto/metrico.proto)
+// @Param metric_data body dto.Batch true "Batch of metrics"
+// @Param X-Agent-ID header string false "Agent identifier, recorded as source label"
+// @Param X-Encrypted header bool false "Whether body is encrypted with server public key (required if server has private key)"
+// @Param Content-Encoding header string false "Body compression" Enums(gzip)
 // @Router /updates [post]
 func (c Controller) BulkUpdatePostHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := checkContentType(w, r); err != nil {
+		format, err := bodyFormat(w, r)
+		if err != nil {
 			log.Error().Err(err).Msg("Wrong content type")
 			return
 		}
-		ms, err := c.readMetrics(w, r)
+		batch, err := c.readBatch(w, r, format)
 		if err != nil {
 			return
 		}
+		ms := batch.Metrics
 		for _, m := range ms {
 			if !c.checkHash(m, w) {
 				http.Error(w, "hash check failed", http.StatusBadRequest)
@@ -162,6 +311,7 @@ func (c Controller) BulkUpdatePostHandler() http.HandlerFunc {
 		}
 		gs := make([]models.GaugeValue, 0)
 		cs := make([]models.CounterValue, 0)
+		hs := make([]models.HistogramValue, 0)
 		for _, m := range ms {
 			if !m.HasValue() {
 				http.Error(w, "metric value is null", http.StatusBadRequest)
@@ -170,20 +320,32 @@ func (c Controller) BulkUpdatePostHandler() http.HandlerFunc {
 			switch m.MType {
 			case model.GAUGE:
 				gs = append(gs, models.GaugeValue{
-					Name:  m.ID,
-					Value: *m.Value,
+					Name:     m.ID,
+					LabelSet: withSource(r, m.Labels),
+					Value:    *m.Value,
 				})
 			case model.COUNTER:
 				cs = append(cs, models.CounterValue{
-					Name:  m.ID,
-					Value: *m.Delta,
+					Name:     m.ID,
+					LabelSet: withSource(r, m.Labels),
+					Value:    *m.Delta,
+				})
+			case model.HISTOGRAM:
+				hs = append(hs, models.HistogramValue{
+					Name:     m.ID,
+					LabelSet: withSource(r, m.Labels),
+					Value:    m.Histogram.Copy(),
 				})
 			}
 		}
-		err = c.ms.UpdateAll(context.Background(), gs, cs)
+		applied, err := c.ms.UpdateBatch(context.Background(), batch.ID, gs, cs, hs)
 		if err != nil {
 			log.Error().Err(err).Msg("Error saving metrics")
 			http.Error(w, "Could not save metrics", http.StatusInternalServerError)
+			return
+		}
+		if !applied {
+			log.Info().Msgf("batch %s is already applied", batch.ID)
 		}
 		_, err = w.Write([]byte(""))
 		if err != nil {
@@ -205,12 +367,12 @@ func (c Controller) GetPostHandler() http.HandlerFunc {
 			log.Error().Err(err).Msg("Wrong content type")
 			return
 		}
-		mdto, err := readMetric(w, r)
+		mdto, err := c.readMetric(w, r, contentTypeJSON, false)
 		if err != nil {
 			log.Error().Err(err).Msg("Could not parse metric")
 			return
 		}
-		mvalue, err := c.ms.Get(context.Background(), mdto.ID, mdto.MType)
+		mvalue, err := c.ms.Get(context.Background(), mdto.ID, mdto.Labels, mdto.MType)
 		if err != nil {
 			log.Error().Err(err).Msg("Could not get metric")
 			http.Error(w, "could not retrieve metric", http.StatusInternalServerError)
@@ -228,6 +390,11 @@ func (c Controller) GetPostHandler() http.HandlerFunc {
 				return
 			}
 		}
+		if t, ok := mvalue.(models.Timestamped); ok && !t.LastUpdated().IsZero() {
+			updatedAt := t.LastUpdated()
+			mdto.UpdatedAt = &updatedAt
+			mdto.Stale = c.ms.IsStale(mvalue)
+		}
 		b, err := json.Marshal(mdto)
 		if err != nil {
 			log.Error().Err(err).Msg("error unmarshalling")
@@ -244,14 +411,21 @@ func (c Controller) GetPostHandler() http.HandlerFunc {
 
 // MetricGetHandler godoc
 // @Summary Get metric value
-// @Param metric_type path string true "Metric type" Enum(gauge, counter)
+// @Param metric_type path string true "Metric type" Enum(gauge, counter, histogram)
 // @Param metric_name path string true "Metric name"
+// @Param label query []string false "Metric label in key=value form" collectionFormat(multi)
+// @Param source query string false "Agent identifier (same as label=source=...)"
 // @Success 200 {string} string "Metric value"
 // @Router /value/{metric_type}/{metric_name} [get]
 func (c Controller) MetricGetHandler(mType string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		name := chi.URLParam(r, "name")
-		m, err := c.ms.Get(context.Background(), name, mType)
+		labels, err := parseLabels(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m, err := c.ms.Get(context.Background(), name, labels, mType)
 		if err != nil {
 			log.Error().Err(err).Msg("error getting value")
 			http.Error(w, "error retrieving value", http.StatusInternalServerError)
@@ -272,9 +446,15 @@ func (c Controller) MetricGetHandler(mType string) http.HandlerFunc {
 // @Summary Update counter value
 // @Param metric_name path string true "Counter name"
 // @Param metric_value path int true "Counter value"
+// @Param label query []string false "Metric label in key=value form" collectionFormat(multi)
+// @Param X-Agent-ID header string false "Agent identifier, recorded as source label"
 // @Router /update/counter/{metric_name}/{metric_value} [post]
 func (c Controller) CounterPostHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if err := c.checkEncrypted(w); err != nil {
+			log.Error().Err(err).Msg("Could not update counter")
+			return
+		}
 		name := chi.URLParam(r, "name")
 		svalue := chi.URLParam(r, "svalue")
 
@@ -283,7 +463,12 @@ func (c Controller) CounterPostHandler() http.HandlerFunc {
 			http.Error(w, "VALUE type must be int64", http.StatusBadRequest)
 			return
 		}
-		_, err = c.ms.UpdateCounter(context.Background(), models.CounterValue{Name: name, Value: value})
+		labels, err := parseLabels(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = c.ms.UpdateCounter(context.Background(), models.CounterValue{Name: name, LabelSet: withSource(r, labels), Value: value})
 		if err != nil {
 			log.Error().Err(err).Msgf("Could not add and save counter value %s = %v", name, value)
 			http.Error(w, "Could not add and save counter value", http.StatusInternalServerError)
@@ -296,9 +481,15 @@ func (c Controller) CounterPostHandler() http.HandlerFunc {
 // @Summary Update gauge value
 // @Param metric_name path string true "Gauge name"
 // @Param metric_value path number true "Gauge value"
+// @Param label query []string false "Metric label in key=value form" collectionFormat(multi)
+// @Param X-Agent-ID header string false "Agent identifier, recorded as source label"
 // @Router /update/gauge/{metric_name}/{metric_value} [post]
 func (c Controller) GaugePostHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if err := c.checkEncrypted(w); err != nil {
+			log.Error().Err(err).Msg("Could not update gauge")
+			return
+		}
 		name := chi.URLParam(r, "name")
 		svalue := chi.URLParam(r, "svalue")
 
@@ -307,7 +498,12 @@ func (c Controller) GaugePostHandler() http.HandlerFunc {
 			http.Error(w, "VALUE type must be float64", http.StatusBadRequest)
 			return
 		}
-		_, err = c.ms.UpdateGauge(context.Background(), models.GaugeValue{Name: name, Value: value})
+		labels, err := parseLabels(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = c.ms.UpdateGauge(context.Background(), models.GaugeValue{Name: name, LabelSet: withSource(r, labels), Value: value})
 		if err != nil {
 			log.Error().Err(err).Msgf("Could not save gauge value %s = %v", name, value)
 			http.Error(w, "Could not save gauge value", http.StatusInternalServerError)
@@ -318,9 +514,13 @@ func (c Controller) GaugePostHandler() http.HandlerFunc {
 
 func (c Controller) MetricsViewPageHandler() http.HandlerFunc {
 	type Item struct {
-		Name  string
-		Type  string
-		Value string
+		Name    string
+		Source  string
+		Labels  string
+		Type    string
+		Value   string
+		Updated string
+		Stale   bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -328,18 +528,40 @@ func (c Controller) MetricsViewPageHandler() http.HandlerFunc {
 			Items []Item
 		}{}
 
-		ms, err := c.ms.GetAll(context.Background())
+		filter, err := parseLabels(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ms, err := c.ms.GetAll(context.Background(), filter)
 		if err != nil {
 			log.Error().Err(err).Msg("error getting metrics")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		for _, m := range ms {
-			data.Items = append(data.Items, Item{m.ID(), m.Type(), fmt.Sprint(m.Val())})
+			item := Item{
+				Name:   m.ID(),
+				Source: m.Labels()[model.SourceLabel],
+				Labels: m.Labels().Without(model.SourceLabel).String(),
+				Type:   m.Type(),
+				Value:  fmt.Sprint(m.Val()),
+				Stale:  c.ms.IsStale(m),
+			}
+			if t, ok := m.(models.Timestamped); ok && !t.LastUpdated().IsZero() {
+				item.Updated = time.Since(t.LastUpdated()).Truncate(time.Second).String() + " ago"
+			}
+			data.Items = append(data.Items, item)
 		}
 
 		sort.Slice(data.Items, func(i, j int) bool {
-			return data.Items[i].Name < data.Items[j].Name
+			if data.Items[i].Source != data.Items[j].Source {
+				return data.Items[i].Source < data.Items[j].Source
+			}
+			if data.Items[i].Name != data.Items[j].Name {
+				return data.Items[i].Name < data.Items[j].Name
+			}
+			return data.Items[i].Labels < data.Items[j].Labels
 		})
 
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
@@ -353,6 +575,56 @@ func (c Controller) MetricsViewPageHandler() http.HandlerFunc {
 	}
 }
 
+// PrometheusHandler godoc
+// @Summary Get all metrics in Prometheus text exposition format
+// @Description Stale metrics are not exported, so Prometheus marks them stale as well
+// @Produce plain
+// @Param label query []string false "Export only metrics having label in key=value form" collectionFormat(multi)
+// @Param source query string false "Export only metrics of agent (same as label=source=...)"
+// @Success 200 {string} string "Metrics"
+// @Router /metrics [get]
+func (c Controller) PrometheusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseLabels(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ms, err := c.ms.GetAll(r.Context(), filter)
+		if err != nil {
+			log.Error().Err(err).Msg("error getting metrics")
+			http.Error(w, "could not retrieve metrics", http.StatusInternalServerError)
+			return
+		}
+		fresh := ms[:0]
+		for _, m := range ms {
+			if !c.ms.IsStale(m) {
+				fresh = append(fresh, m)
+			}
+		}
+		w.Header().Set("Content-Type", prometheusContentType)
+		err = writePrometheus(w, fresh)
+		if err != nil {
+			log.Error().Err(err).Msg("error writing response")
+		}
+	}
+}
+
+// HealthHandler godoc
+// @Summary Get server liveness (regardless of database connection, see /ping)
+// @Produce plain
+// @Success 200 {string} string "OK"
+// @Router /health [get]
+func (c Controller) HealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err := w.Write([]byte("OK"))
+		if err != nil {
+			log.Error().Err(err).Msg("error writing response")
+		}
+	}
+}
+
 // PingHandler godoc
 // @Summary Get database connection status
 // @Success 200
@@ -373,6 +645,223 @@ func (c Controller) PingHandler() http.HandlerFunc {
 	}
 }
 
+// SourcesHandler godoc
+// @Summary Get summary of metrics grouped by source (agent identifier)
+// @Produce json
+// @Success 200 {array} dto.Source
+// @Router /api/v1/sources [get]
+func (c Controller) SourcesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sources, err := c.ms.GetSources(r.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("could not get sources")
+			http.Error(w, "could not retrieve sources", http.StatusInternalServerError)
+			return
+		}
+		result := make([]dto.Source, 0, len(sources))
+		for _, src := range sources {
+			result = append(result, dto.Source{
+				Source:     src.Name,
+				Gauges:     src.Gauges,
+				Counters:   src.Counters,
+				Histograms: src.Histograms,
+				LastSeen:   src.LastSeen,
+				Stale:      src.Stale,
+			})
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			log.Error().Err(err).Msg("error marshalling")
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(b)
+		if err != nil {
+			log.Error().Err(err).Msg("error writing response")
+		}
+	}
+}
+
+// AlertsHandler godoc
+// @Summary Get active and recently resolved alerts
+// @Produce json
+// @Success 200 {array} alerting.Alert
+// @Failure 501 {string} string "Alerting is not configured"
+// @Router /api/v1/alerts [get]
+func (c Controller) AlertsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.alerts == nil {
+			http.Error(w, "alerting is not configured", http.StatusNotImplemented)
+			return
+		}
+		b, err := json.Marshal(c.alerts.Alerts())
+		if err != nil {
+			log.Error().Err(err).Msg("error marshalling")
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(b)
+		if err != nil {
+			log.Error().Err(err).Msg("error writing response")
+		}
+	}
+}
+
+// QueryRangeHandler godoc
+// @Summary Get metric history aggregated into time buckets
+// @Produce json
+// @Param name query string true "Metric name"
+// @Param type query string true "Metric type" Enums(gauge, counter)
+// @Param label query []string false "Metric label in key=value form" collectionFormat(multi)
+// @Param source query string false "Agent identifier (same as label=source=...)"
+// @Param from query string false "Start of range (RFC3339 or unix time), an hour before end by default"
+// @Param to query string false "End of range (RFC3339 or unix time), current time by default"
+// @Param step query string false "Bucket size" default(1m)
+// @Param agg query string false "Aggregation within bucket (last for gauges and sum for counters by default)" Enums(min, max, avg, last, sum, rate)
+// @Success 200 {object} dto.RangeResult
+// @Failure 400 {string} string "Invalid query"
+// @Failure 501 {string} string "Metrics history is not kept"
+// @Router /api/v1/query_range [get]
+func (c Controller) QueryRangeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseRangeQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ps, err := c.ms.QueryRange(r.Context(), q)
+		if errors.Is(err, services.ErrHistoryNotSupported) {
+			http.Error(w, "Metrics history is not kept", http.StatusNotImplemented)
+			return
+		}
+		if errors.Is(err, services.ErrInvalidRangeQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("could not query metric history")
+			http.Error(w, "could not query metric history", http.StatusInternalServerError)
+			return
+		}
+		result := dto.RangeResult{
+			ID:          q.Name,
+			MType:       q.Type,
+			Labels:      q.Labels,
+			Aggregation: q.Aggregation,
+			Step:        q.Step.String(),
+			Points:      make([]dto.Point, 0, len(ps)),
+		}
+		for _, p := range ps {
+			result.Points = append(result.Points, dto.Point{
+				Timestamp: p.Timestamp,
+				Value:     p.Value,
+			})
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			log.Error().Err(err).Msg("error marshalling")
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(b)
+		if err != nil {
+			log.Error().Err(err).Msg("error writing response")
+		}
+	}
+}
+
+func parseRangeQuery(r *http.Request) (services.RangeQuery, error) {
+	params := r.URL.Query()
+	q := services.RangeQuery{
+		Name:        params.Get("name"),
+		Type:        params.Get("type"),
+		To:          time.Now(),
+		Step:        defaultRangeStep,
+		Aggregation: params.Get("agg"),
+	}
+	if len(q.Name) == 0 {
+		return q, fmt.Errorf("metric name is not set")
+	}
+	if q.Type != model.GAUGE && q.Type != model.COUNTER {
+		return q, fmt.Errorf("unknown metric type: %s", q.Type)
+	}
+	if len(q.Aggregation) == 0 {
+		q.Aggregation = services.DefaultAggregation(q.Type)
+	}
+	var err error
+	q.Labels, err = parseLabels(r)
+	if err != nil {
+		return q, err
+	}
+	if s := params.Get("to"); len(s) > 0 {
+		q.To, err = parseTime(s)
+		if err != nil {
+			return q, fmt.Errorf("could not parse end of range: %w", err)
+		}
+	}
+	q.From = q.To.Add(-defaultRangeLength)
+	if s := params.Get("from"); len(s) > 0 {
+		q.From, err = parseTime(s)
+		if err != nil {
+			return q, fmt.Errorf("could not parse start of range: %w", err)
+		}
+	}
+	if s := params.Get("step"); len(s) > 0 {
+		q.Step, err = time.ParseDuration(s)
+		if err != nil {
+			return q, fmt.Errorf("could not parse step: %w", err)
+		}
+	}
+	return q, nil
+}
+
+// parseTime parses time in RFC3339 format or unix time (in seconds, may be fractional)
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time %s is neither RFC3339 nor unix time", s)
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9)), nil
+}
+
+// parseLabels parses labels from repeated query parameter of key=value form and source parameter
+func parseLabels(r *http.Request) (model.Labels, error) {
+	params := r.URL.Query()[labelParam]
+	source := r.URL.Query().Get(sourceParam)
+	if len(params) == 0 && len(source) == 0 {
+		return nil, nil
+	}
+	labels := make(model.Labels, len(params)+1)
+	for _, p := range params {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok || len(key) == 0 {
+			return nil, fmt.Errorf("label %s is not in key=value form", p)
+		}
+		labels[key] = value
+	}
+	if len(source) > 0 {
+		labels[model.SourceLabel] = source
+	}
+	return labels, nil
+}
+
+// withSource returns labels with source label set to agent identifier from request (if any)
+func withSource(r *http.Request, labels model.Labels) model.Labels {
+	id := r.Header.Get(agentIDHeader)
+	if len(id) == 0 {
+		return labels.Copy()
+	}
+	return labels.With(model.SourceLabel, id)
+}
+
 func handleUnknown(w http.ResponseWriter, r *http.Request) {
 	mtype := chi.URLParam(r, "*")
 	http.Error(w, "unknown metric type in "+mtype, http.StatusNotImplemented)
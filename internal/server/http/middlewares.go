@@ -1,29 +1,47 @@
 package http
 
 import (
+	"errors"
 	"net"
 	"net/http"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/tony-spark/metrico/internal/server/access"
 )
 
-// SubnetClientFilter is a middleware, that allows requests only from trusted subnet
-func SubnetClientFilter(subnet net.IPNet) func(next http.Handler) http.Handler {
+// ClientFilter is a middleware, that allows requests only from clients allowed by policy. Remote address of request is
+// replaced by client's address reported by trusted proxy (if any)
+func ClientFilter(p access.Policy) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			clientIP := net.ParseIP(r.RemoteAddr)
-			if clientIP == nil {
-				http.Error(w, "Unknown client's IP (is headers set?)", http.StatusForbidden)
+			peerIP := remoteIP(r.RemoteAddr)
+			clientIP := p.ClientIP(peerIP, r.Header.Values(p.Header()))
+
+			err := p.Check(clientIP)
+			if errors.Is(err, access.ErrUnknownClient) {
+				http.Error(w, "Unknown client's IP", http.StatusForbidden)
 				return
 			}
-
-			if !subnet.Contains(clientIP) {
-				http.Error(w, "Client's IP is not in trusted subnet", http.StatusForbidden)
-				log.Info().Msgf("client not trusted, aborting request: %s", r.RemoteAddr)
+			if err != nil {
+				http.Error(w, "Client's IP is not allowed", http.StatusForbidden)
+				log.Info().Err(err).Msgf("client not trusted, aborting request from %s", r.RemoteAddr)
 				return
 			}
 
+			if clientIP != nil && !clientIP.Equal(peerIP) {
+				r.RemoteAddr = clientIP.String()
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// remoteIP returns IP of request remote address (nil if it could not be parsed)
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return net.ParseIP(addr)
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,180 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tony-spark/metrico/internal/model"
+)
+
+// prometheusContentType is a content type of Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type prometheusSeries struct {
+	name   string
+	mType  string
+	labels string
+	metric model.Metric
+}
+
+// writePrometheus writes metrics in Prometheus text exposition format.
+//
+// Metric names are sanitized to match Prometheus naming rules, if several metrics get the same name, only the first one
+// (in order of original name and type) is written. Series of the same metric having different labels form one family
+func writePrometheus(w io.Writer, ms []model.Metric) error {
+	series := make([]prometheusSeries, 0, len(ms))
+	for _, m := range ms {
+		var mType string
+		switch m.Type() {
+		case model.GAUGE:
+			mType = "gauge"
+		case model.COUNTER:
+			mType = "counter"
+		case model.HISTOGRAM:
+			mType = "histogram"
+		default:
+			continue
+		}
+		series = append(series, prometheusSeries{
+			name:   sanitizePrometheusName(m.ID()),
+			mType:  mType,
+			labels: formatPrometheusLabels(m.Labels()),
+			metric: m,
+		})
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		if series[i].metric.ID() != series[j].metric.ID() {
+			return series[i].metric.ID() < series[j].metric.ID()
+		}
+		if series[i].mType != series[j].mType {
+			return series[i].mType < series[j].mType
+		}
+		return series[i].labels < series[j].labels
+	})
+
+	bw := bufio.NewWriter(w)
+	var family *prometheusSeries
+	for i, s := range series {
+		if family != nil && family.name == s.name {
+			if family.metric.ID() != s.metric.ID() || family.mType != s.mType {
+				log.Warn().Msgf("metric %s (%s) is not exported: name %s is already used", s.metric.ID(), s.metric.Type(), s.name)
+				continue
+			}
+			if series[i-1].labels == s.labels {
+				log.Warn().Msgf("metric %s%s (%s) is not exported: duplicate series", s.metric.ID(), s.labels, s.metric.Type())
+				continue
+			}
+		} else {
+			family = &series[i]
+			fmt.Fprintf(bw, "# HELP %s %s %s\n", s.name, s.metric.Type(), escapePrometheusHelp(s.metric.ID()))
+			fmt.Fprintf(bw, "# TYPE %s %s\n", s.name, s.mType)
+		}
+		writePrometheusSeries(bw, s)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("could not write metrics: %w", err)
+	}
+	return nil
+}
+
+// writePrometheusSeries writes sample lines of series, histogram is written as cumulative buckets, sum and count
+func writePrometheusSeries(w io.Writer, s prometheusSeries) {
+	h, ok := s.metric.Val().(model.Histogram)
+	if !ok {
+		fmt.Fprintf(w, "%s%s %s\n", s.name, s.labels, formatPrometheusValue(s.metric.Val()))
+		return
+	}
+	var cumulative int64
+	for i, b := range h.Bounds {
+		cumulative += h.Counts[i]
+		le := formatPrometheusLabels(s.metric.Labels().With("le", formatPrometheusValue(b)))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", s.name, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", s.name, formatPrometheusLabels(s.metric.Labels().With("le", "+Inf")), h.Count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", s.name, s.labels, formatPrometheusValue(h.Sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", s.name, s.labels, h.Count)
+}
+
+// formatPrometheusLabels returns label set in Prometheus format, label names are sanitized
+func formatPrometheusLabels(labels model.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sanitized := make(map[string]string, len(labels))
+	for k, v := range labels {
+		sanitized[sanitizePrometheusLabelName(k)] = v
+	}
+	keys := make([]string, 0, len(sanitized))
+	for k := range sanitized {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteString(`="`)
+		sb.WriteString(escapePrometheusLabelValue(sanitized[k]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// sanitizePrometheusName replaces characters not allowed in Prometheus metric name with underscores
+func sanitizePrometheusName(name string) string {
+	if len(name) == 0 {
+		return "_"
+	}
+	var sb strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// sanitizePrometheusLabelName replaces characters not allowed in Prometheus label name with underscores
+func sanitizePrometheusLabelName(name string) string {
+	return strings.ReplaceAll(sanitizePrometheusName(name), ":", "_")
+}
+
+func escapePrometheusHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapePrometheusLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatPrometheusValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -3,19 +3,79 @@ package models
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/tony-spark/metrico/internal/dto"
 	"github.com/tony-spark/metrico/internal/model"
 )
 
 type GaugeValue struct {
-	Name  string
-	Value float64
+	Name      string
+	LabelSet  model.Labels
+	Value     float64
+	UpdatedAt time.Time `json:"-"` // when value was last saved
 }
 
 type CounterValue struct {
-	Name  string
-	Value int64
+	Name      string
+	LabelSet  model.Labels
+	Value     int64
+	UpdatedAt time.Time `json:"-"` // when value was last saved
+}
+
+// HistogramValue is a histogram, its observations are accumulated like counter increments
+type HistogramValue struct {
+	Name      string
+	LabelSet  model.Labels
+	Value     model.Histogram
+	UpdatedAt time.Time `json:"-"` // when value was last saved
+}
+
+// Timestamped is implemented by stored metrics which know when they were last updated
+type Timestamped interface {
+	LastUpdated() time.Time
+}
+
+// GaugeSample is a gauge value saved at a given time
+type GaugeSample struct {
+	Name      string
+	LabelSet  model.Labels
+	Value     float64
+	Samples   int // number of saved values averaged into Value by history compaction, 1 for saved value
+	Timestamp time.Time
+}
+
+// Weight returns number of saved values sample stands for, so that averages of compacted samples are weighted
+func (g GaugeSample) Weight() int {
+	if g.Samples < 1 {
+		return 1
+	}
+	return g.Samples
+}
+
+// CounterSample is a counter increment saved at a given time
+type CounterSample struct {
+	Name      string
+	LabelSet  model.Labels
+	Delta     int64 // increment
+	Value     int64 // counter value after increment
+	Timestamp time.Time
+}
+
+// Source is a summary of metrics reported by agent identified by Name (empty for metrics without source)
+type Source struct {
+	Name       string
+	Gauges     int
+	Counters   int
+	Histograms int
+	LastSeen   time.Time // when any metric of source was last updated
+	Stale      bool      // whether every metric of source is stale (agent is down)
+}
+
+// Point is an aggregated value of metric samples within time bucket which starts at Timestamp
+type Point struct {
+	Timestamp time.Time
+	Value     float64
 }
 
 func (g GaugeValue) ID() string {
@@ -34,6 +94,14 @@ func (g GaugeValue) String() string {
 	return fmt.Sprint(g.Value)
 }
 
+func (g GaugeValue) Labels() model.Labels {
+	return g.LabelSet
+}
+
+func (g GaugeValue) LastUpdated() time.Time {
+	return g.UpdatedAt
+}
+
 func (c CounterValue) ID() string {
 	return c.Name
 }
@@ -50,17 +118,57 @@ func (c CounterValue) String() string {
 	return fmt.Sprint(c.Value)
 }
 
+func (c CounterValue) Labels() model.Labels {
+	return c.LabelSet
+}
+
+func (c CounterValue) LastUpdated() time.Time {
+	return c.UpdatedAt
+}
+
+func (h HistogramValue) ID() string {
+	return h.Name
+}
+
+func (h HistogramValue) Type() string {
+	return model.HISTOGRAM
+}
+
+func (h HistogramValue) Val() interface{} {
+	return h.Value
+}
+
+func (h HistogramValue) String() string {
+	return h.Value.String()
+}
+
+func (h HistogramValue) Labels() model.Labels {
+	return h.LabelSet
+}
+
+func (h HistogramValue) LastUpdated() time.Time {
+	return h.UpdatedAt
+}
+
 func FromDTO(mdto dto.Metric) model.Metric {
 	switch mdto.MType {
 	case model.GAUGE:
 		return GaugeValue{
-			Name:  mdto.ID,
-			Value: *mdto.Value,
+			Name:     mdto.ID,
+			LabelSet: mdto.Labels.Copy(),
+			Value:    *mdto.Value,
 		}
 	case model.COUNTER:
 		return CounterValue{
-			Name:  mdto.ID,
-			Value: *mdto.Delta,
+			Name:     mdto.ID,
+			LabelSet: mdto.Labels.Copy(),
+			Value:    *mdto.Delta,
+		}
+	case model.HISTOGRAM:
+		return HistogramValue{
+			Name:     mdto.ID,
+			LabelSet: mdto.Labels.Copy(),
+			Value:    mdto.Histogram.Copy(),
 		}
 	}
 	return nil
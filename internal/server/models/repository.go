@@ -3,19 +3,55 @@ package models
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/tony-spark/metrico/internal/model"
 )
 
+// MetricRepository stores metric values, metric is identified by its type, name and labels
 type MetricRepository interface {
-	GetGaugeByName(ctx context.Context, name string) (*GaugeValue, error)
-	SaveGauge(ctx context.Context, name string, value float64) (*GaugeValue, error)
+	GetGaugeByName(ctx context.Context, name string, labels model.Labels) (*GaugeValue, error)
+	SaveGauge(ctx context.Context, name string, labels model.Labels, value float64) (*GaugeValue, error)
 	SaveAllGauges(ctx context.Context, gs []GaugeValue) error
-	GetCounterByName(ctx context.Context, name string) (*CounterValue, error)
-	AddAndSaveCounter(ctx context.Context, name string, value int64) (*CounterValue, error)
+	GetCounterByName(ctx context.Context, name string, labels model.Labels) (*CounterValue, error)
+	AddAndSaveCounter(ctx context.Context, name string, labels model.Labels, value int64) (*CounterValue, error)
 	AddAndSaveAllCounters(ctx context.Context, cs []CounterValue) error
-	SaveCounter(ctx context.Context, name string, value int64) (*CounterValue, error)
-	GetAll(ctx context.Context) ([]model.Metric, error)
+	SaveCounter(ctx context.Context, name string, labels model.Labels, value int64) (*CounterValue, error)
+	GetHistogramByName(ctx context.Context, name string, labels model.Labels) (*HistogramValue, error)
+	// AddAndSaveHistogram adds observations to stored histogram, histogram with different buckets is replaced
+	AddAndSaveHistogram(ctx context.Context, name string, labels model.Labels, value model.Histogram) (*HistogramValue, error)
+	AddAndSaveAllHistograms(ctx context.Context, hs []HistogramValue) error
+	SaveHistogram(ctx context.Context, name string, labels model.Labels, value model.Histogram) (*HistogramValue, error)
+	// GetAll returns all metrics which labels match filter (every metric if filter is empty), metrics implement Timestamped
+	GetAll(ctx context.Context, filter model.Labels) ([]model.Metric, error)
+	// DeleteNotUpdatedSince removes metrics which were last updated before t, returns number of removed metrics
+	DeleteNotUpdatedSince(ctx context.Context, t time.Time) (int64, error)
+	// WithinTransaction calls fn with repository which changes are applied atomically: all of them if fn returns nil,
+	// none otherwise
+	WithinTransaction(ctx context.Context, fn func(r MetricRepository) error) error
+}
+
+// MetricHistoryRepository is a MetricRepository which also keeps every saved value with its timestamp
+type MetricHistoryRepository interface {
+	MetricRepository
+	// GetGaugeHistory returns gauge samples saved within [from, to] ordered by time
+	GetGaugeHistory(ctx context.Context, name string, labels model.Labels, from time.Time, to time.Time) ([]GaugeSample, error)
+	// GetCounterHistory returns counter samples saved within [from, to] ordered by time
+	GetCounterHistory(ctx context.Context, name string, labels model.Labels, from time.Time, to time.Time) ([]CounterSample, error)
+	// CompactHistory replaces samples saved within [from, to) with one sample per step-long time bucket (aligned to
+	// Unix epoch) timestamped with the bucket start: gauge values are averaged (weighted by GaugeSample.Samples), counter
+	// increments are summed.
+	// Buckets having a single sample are left as is, so compaction can be repeated
+	CompactHistory(ctx context.Context, from time.Time, to time.Time, step time.Duration) error
+	// DeleteHistory removes samples saved before t
+	DeleteHistory(ctx context.Context, t time.Time) error
+}
+
+// BatchRepository records identifiers of applied batches of updates, so that replayed batches are not applied twice
+type BatchRepository interface {
+	// SaveBatchID records batch identifier, false is returned if it was already recorded after since.
+	// Identifiers recorded before since may be forgotten
+	SaveBatchID(ctx context.Context, id string, since time.Time) (bool, error)
 }
 
 type DBManager interface {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/rs/zerolog/log"
+	"github.com/tony-spark/metrico/internal/server/alerting"
 	"github.com/tony-spark/metrico/internal/server/models"
 	"github.com/tony-spark/metrico/internal/server/services"
 	"golang.org/x/sync/errgroup"
@@ -25,7 +26,10 @@ type Server struct {
 	store    models.RepositoryPersistence
 	r        models.MetricRepository
 	pService *services.PersistenceService
+	eService *services.ExpiryService
+	rService *services.RetentionService
 	mService *services.MetricService
+	alerts   *alerting.Engine
 	ctrls    []Controller
 }
 
@@ -50,6 +54,27 @@ func WithPersistence(pservice *services.PersistenceService) Option {
 	}
 }
 
+// WithExpiry configures server to evict stale metrics
+func WithExpiry(eservice *services.ExpiryService) Option {
+	return func(s *Server) {
+		s.eService = eservice
+	}
+}
+
+// WithRetention configures server to enforce history retention policy
+func WithRetention(rservice *services.RetentionService) Option {
+	return func(s *Server) {
+		s.rService = rservice
+	}
+}
+
+// WithAlerting configures server to run alerting engine
+func WithAlerting(e *alerting.Engine) Option {
+	return func(s *Server) {
+		s.alerts = e
+	}
+}
+
 func AddController(c Controller) Option {
 	return func(s *Server) {
 		s.ctrls = append(s.ctrls, c)
@@ -80,6 +105,16 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 	}
 
+	if s.eService != nil {
+		go s.eService.Run(ctx)
+	}
+	if s.rService != nil {
+		go s.rService.Run(ctx)
+	}
+	if s.alerts != nil {
+		go s.alerts.Run(ctx)
+	}
+
 	grp := new(errgroup.Group)
 	for _, ctrl := range s.ctrls {
 		ctrl := ctrl
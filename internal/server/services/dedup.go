@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+// errBatchApplied is returned within transaction to roll back updates of replayed batch
+var errBatchApplied = errors.New("batch is already applied")
+
+// ErrBatchInProgress is returned for batch replayed while it is being applied, so that it is retried later (batch may
+// not be applied in the end)
+var ErrBatchInProgress = errors.New("batch is being applied")
+
+// saveBatchID records id of batch being applied within transaction, errBatchApplied is returned for replayed batch
+func (s MetricService) saveBatchID(ctx context.Context, r models.MetricRepository, id string) error {
+	if len(id) == 0 || s.batches == nil {
+		return nil
+	}
+	batches := s.batches
+	if b, ok := r.(models.BatchRepository); ok {
+		batches = b
+	}
+	fresh, err := batches.SaveBatchID(ctx, id, time.Now().Add(-s.dedup))
+	if errors.Is(err, ErrBatchInProgress) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("could not save batch id: %w", err)
+	}
+	if !fresh {
+		return errBatchApplied
+	}
+	return nil
+}
+
+// inMemoryBatches returns in-memory window if it is used to record batches, i.e. repository does not record them
+// within its transactions
+func (s MetricService) inMemoryBatches() (batchWindow, bool) {
+	if _, ok := s.r.(models.BatchRepository); ok {
+		return batchWindow{}, false
+	}
+	w, ok := s.batches.(batchWindow)
+	return w, ok
+}
+
+// commitBatchID marks batch reserved by saveBatchID as applied once its transaction is committed
+func (s MetricService) commitBatchID(id string) {
+	if w, ok := s.inMemoryBatches(); ok && len(id) > 0 {
+		w.commit(id)
+	}
+}
+
+// forgetBatchID releases batch reserved by saveBatchID which could not be applied, so that batch is applied when
+// retried (batch repositories of databases roll it back with transaction)
+func (s MetricService) forgetBatchID(id string) {
+	if w, ok := s.inMemoryBatches(); ok && len(id) > 0 {
+		w.forget(id)
+	}
+}
+
+// batchWindow is an in-memory models.BatchRepository. Saved batch is reserved until it is committed (see commit) or
+// forgotten (see forget), batch replayed meanwhile gets ErrBatchInProgress
+type batchWindow struct {
+	mu       *sync.Mutex
+	applied  map[string]time.Time
+	reserved map[string]bool
+}
+
+func newBatchWindow() batchWindow {
+	return batchWindow{
+		mu:       new(sync.Mutex),
+		applied:  make(map[string]time.Time),
+		reserved: make(map[string]bool),
+	}
+}
+
+func (w batchWindow) SaveBatchID(_ context.Context, id string, since time.Time) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, t := range w.applied {
+		if t.Before(since) {
+			delete(w.applied, key)
+		}
+	}
+	if _, ok := w.applied[id]; ok {
+		return false, nil
+	}
+	if w.reserved[id] {
+		return false, fmt.Errorf("%w: %s", ErrBatchInProgress, id)
+	}
+	w.reserved[id] = true
+	return true, nil
+}
+
+// commit marks reserved batch as applied
+func (w batchWindow) commit(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.reserved[id] {
+		delete(w.reserved, id)
+		w.applied[id] = time.Now()
+	}
+}
+
+// forget releases reserved batch
+func (w batchWindow) forget(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.reserved, id)
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+// txStub is a repository which calls hook before transaction ends, transaction fails with err
+type txStub struct {
+	models.MetricRepository
+	hook func()
+	err  error
+}
+
+func (r *txStub) WithinTransaction(_ context.Context, fn func(r models.MetricRepository) error) error {
+	if err := fn(r); err != nil {
+		return err
+	}
+	if r.hook != nil {
+		r.hook()
+	}
+	return r.err
+}
+
+func TestUpdateBatchDedup(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("batch replayed while it is applied is retried", func(t *testing.T) {
+		r := &txStub{err: errors.New("failure")}
+		s := NewMetricService(r, nil, WithDedupWindow(time.Minute))
+		r.hook = func() {
+			_, err := s.UpdateBatch(ctx, "b1", nil, nil, nil)
+			assert.ErrorIs(t, err, ErrBatchInProgress)
+		}
+
+		_, err := s.UpdateBatch(ctx, "b1", nil, nil, nil)
+		require.Error(t, err)
+
+		r.hook, r.err = nil, nil
+		applied, err := s.UpdateBatch(ctx, "b1", nil, nil, nil)
+		require.NoError(t, err)
+		assert.True(t, applied, "batch failed before should be applied")
+		applied, err = s.UpdateBatch(ctx, "b1", nil, nil, nil)
+		require.NoError(t, err)
+		assert.False(t, applied)
+	})
+}
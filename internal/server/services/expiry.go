@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+// IsStale returns whether metric was not updated within stale period (always false if period is not configured)
+func (s MetricService) IsStale(m model.Metric) bool {
+	t, ok := m.(models.Timestamped)
+	if !ok {
+		return false
+	}
+	return s.stale(t.LastUpdated())
+}
+
+func (s MetricService) stale(lastUpdated time.Time) bool {
+	if s.staleAfter <= 0 || lastUpdated.IsZero() {
+		return false
+	}
+	return time.Since(lastUpdated) > s.staleAfter
+}
+
+// ExpiryService periodically removes metrics which were not updated for too long
+type ExpiryService struct {
+	r             models.MetricRepository
+	expireAfter   time.Duration
+	checkInterval time.Duration
+}
+
+func NewExpiryService(r models.MetricRepository, expireAfter time.Duration, checkInterval time.Duration) *ExpiryService {
+	return &ExpiryService{
+		r:             r,
+		expireAfter:   expireAfter,
+		checkInterval: checkInterval,
+	}
+}
+
+// Evict removes metrics not updated within expiry period, returns number of removed metrics
+func (s ExpiryService) Evict(ctx context.Context) (int64, error) {
+	return s.r.DeleteNotUpdatedSince(ctx, time.Now().Add(-s.expireAfter))
+}
+
+// Run evicts metrics periodically until context is done
+func (s ExpiryService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n, err := s.Evict(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("could not evict stale metrics")
+				continue
+			}
+			if n > 0 {
+				log.Info().Msgf("evicted %d stale metrics", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+// Aggregations of samples within time bucket
+const (
+	AggregationMin  = "min"  // minimal gauge value
+	AggregationMax  = "max"  // maximal gauge value
+	AggregationAvg  = "avg"  // average gauge value
+	AggregationLast = "last" // last gauge value
+	AggregationSum  = "sum"  // sum of counter increments
+	AggregationRate = "rate" // counter increments per second
+)
+
+// MaxRangePoints is a maximal number of time buckets in range query
+const MaxRangePoints = 11000
+
+var (
+	ErrHistoryNotSupported = errors.New("metrics history is not kept by repository")
+	ErrInvalidRangeQuery   = errors.New("invalid range query")
+)
+
+// RangeQuery describes which metric history to retrieve and how to aggregate it
+type RangeQuery struct {
+	Name        string
+	Type        string
+	Labels      model.Labels
+	From        time.Time
+	To          time.Time
+	Step        time.Duration
+	Aggregation string // if empty, AggregationLast for gauges and AggregationSum for counters is used
+}
+
+// DefaultAggregation returns aggregation used for metric type if none given
+func DefaultAggregation(mType string) string {
+	if mType == model.COUNTER {
+		return AggregationSum
+	}
+	return AggregationLast
+}
+
+// QueryRange returns metric history within [q.From, q.To] aggregated into time buckets of q.Step size.
+//
+// Gauge points are returned only for buckets having samples, counter points are returned for every bucket
+func (s MetricService) QueryRange(ctx context.Context, q RangeQuery) ([]models.Point, error) {
+	if s.h == nil {
+		return nil, ErrHistoryNotSupported
+	}
+	if len(q.Aggregation) == 0 {
+		q.Aggregation = DefaultAggregation(q.Type)
+	}
+	if q.Step <= 0 {
+		return nil, fmt.Errorf("%w: step must be positive", ErrInvalidRangeQuery)
+	}
+	if q.To.Before(q.From) {
+		return nil, fmt.Errorf("%w: end of range is before start", ErrInvalidRangeQuery)
+	}
+	n := int64(q.To.Sub(q.From)/q.Step) + 1
+	if n > MaxRangePoints {
+		return nil, fmt.Errorf("%w: too many points (%d), increase step", ErrInvalidRangeQuery, n)
+	}
+
+	switch q.Type {
+	case model.GAUGE:
+		gs, err := s.h.GetGaugeHistory(ctx, q.Name, q.Labels, q.From, q.To)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve gauge history: %w", err)
+		}
+		return aggregateGauges(gs, q, int(n))
+	case model.COUNTER:
+		cs, err := s.h.GetCounterHistory(ctx, q.Name, q.Labels, q.From, q.To)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve counter history: %w", err)
+		}
+		return aggregateCounters(cs, q, int(n))
+	default:
+		return nil, fmt.Errorf("%w: unknown metric type", ErrInvalidRangeQuery)
+	}
+}
+
+func aggregateGauges(gs []models.GaugeSample, q RangeQuery, n int) ([]models.Point, error) {
+	type bucket struct {
+		min, max, sum, last float64
+		count               int
+	}
+
+	var value func(b bucket) float64
+	switch q.Aggregation {
+	case AggregationMin:
+		value = func(b bucket) float64 { return b.min }
+	case AggregationMax:
+		value = func(b bucket) float64 { return b.max }
+	case AggregationAvg:
+		value = func(b bucket) float64 { return b.sum / float64(b.count) }
+	case AggregationLast:
+		value = func(b bucket) float64 { return b.last }
+	default:
+		return nil, fmt.Errorf("%w: aggregation %s is not supported for gauges", ErrInvalidRangeQuery, q.Aggregation)
+	}
+
+	buckets := make([]bucket, n)
+	for _, g := range gs {
+		b := &buckets[bucketIndex(g.Timestamp, q, n)]
+		if b.count == 0 {
+			b.min = math.Inf(1)
+			b.max = math.Inf(-1)
+		}
+		b.min = math.Min(b.min, g.Value)
+		b.max = math.Max(b.max, g.Value)
+		b.sum += g.Value * float64(g.Weight())
+		b.last = g.Value
+		b.count += g.Weight()
+	}
+
+	ps := make([]models.Point, 0)
+	for i, b := range buckets {
+		if b.count == 0 {
+			continue
+		}
+		ps = append(ps, models.Point{
+			Timestamp: bucketStart(i, q),
+			Value:     value(b),
+		})
+	}
+	return ps, nil
+}
+
+func aggregateCounters(cs []models.CounterSample, q RangeQuery, n int) ([]models.Point, error) {
+	var value func(sum int64) float64
+	switch q.Aggregation {
+	case AggregationSum:
+		value = func(sum int64) float64 { return float64(sum) }
+	case AggregationRate:
+		value = func(sum int64) float64 { return float64(sum) / q.Step.Seconds() }
+	default:
+		return nil, fmt.Errorf("%w: aggregation %s is not supported for counters", ErrInvalidRangeQuery, q.Aggregation)
+	}
+
+	sums := make([]int64, n)
+	for _, c := range cs {
+		sums[bucketIndex(c.Timestamp, q, n)] += c.Delta
+	}
+
+	ps := make([]models.Point, 0, n)
+	for i, sum := range sums {
+		ps = append(ps, models.Point{
+			Timestamp: bucketStart(i, q),
+			Value:     value(sum),
+		})
+	}
+	return ps, nil
+}
+
+// bucketIndex returns index of bucket for timestamp, timestamps on range bounds (within storage precision) go to edge buckets
+func bucketIndex(ts time.Time, q RangeQuery, n int) int {
+	i := int(ts.Sub(q.From) / q.Step)
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+func bucketStart(i int, q RangeQuery) time.Time {
+	return q.From.Add(time.Duration(i) * q.Step)
+}
@@ -2,7 +2,9 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/tony-spark/metrico/internal/model"
 	"github.com/tony-spark/metrico/internal/server/models"
@@ -10,28 +12,75 @@ import (
 
 type MetricService struct {
 	r          models.MetricRepository
+	h          models.MetricHistoryRepository
 	postUpdate func()
+	listeners  []func(m model.Metric)
+	staleAfter time.Duration
+	batches    models.BatchRepository
+	dedup      time.Duration
 }
 
-func NewMetricService(r models.MetricRepository, postUpdate func()) *MetricService {
-	return &MetricService{
+// MetricServiceOption represents option function for metric service configuration
+type MetricServiceOption func(s *MetricService)
+
+// WithStaleAfter configures service to consider metrics not updated for a given duration stale
+func WithStaleAfter(d time.Duration) MetricServiceOption {
+	return func(s *MetricService) {
+		s.staleAfter = d
+	}
+}
+
+// WithDedupWindow configures service to acknowledge batches replayed within a given window without applying them again.
+// Batch identifiers are kept by repository if it implements models.BatchRepository, in memory otherwise
+func WithDedupWindow(d time.Duration) MetricServiceOption {
+	return func(s *MetricService) {
+		s.dedup = d
+	}
+}
+
+func NewMetricService(r models.MetricRepository, postUpdate func(), options ...MetricServiceOption) *MetricService {
+	s := &MetricService{
 		r:          r,
 		postUpdate: postUpdate,
 	}
+	if h, ok := r.(models.MetricHistoryRepository); ok {
+		s.h = h
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+	if s.dedup > 0 {
+		s.batches = newBatchWindow()
+	}
+	return s
+}
+
+// AddListener registers function to be called on every updated metric. Metrics are passed as they were
+// received (e.g. counters carry increment, not a new value)
+func (s *MetricService) AddListener(l func(m model.Metric)) {
+	s.listeners = append(s.listeners, l)
 }
 
 func (s MetricService) UpdateGauge(ctx context.Context, g models.GaugeValue) (gv *models.GaugeValue, err error) {
-	gv, err = s.r.SaveGauge(ctx, g.Name, g.Value)
-	if err == nil && s.postUpdate != nil {
-		s.postUpdate()
+	gv, err = s.r.SaveGauge(ctx, g.Name, g.LabelSet, g.Value)
+	if err == nil {
+		s.updated(g)
 	}
 	return
 }
 
 func (s MetricService) UpdateCounter(ctx context.Context, c models.CounterValue) (cv *models.CounterValue, err error) {
-	cv, err = s.r.AddAndSaveCounter(ctx, c.Name, c.Value)
-	if err == nil && s.postUpdate != nil {
-		s.postUpdate()
+	cv, err = s.r.AddAndSaveCounter(ctx, c.Name, c.LabelSet, c.Value)
+	if err == nil {
+		s.updated(c)
+	}
+	return
+}
+
+func (s MetricService) UpdateHistogram(ctx context.Context, h models.HistogramValue) (hv *models.HistogramValue, err error) {
+	hv, err = s.r.AddAndSaveHistogram(ctx, h.Name, h.LabelSet, h.Value)
+	if err == nil {
+		s.updated(h)
 	}
 	return
 }
@@ -42,35 +91,87 @@ func (s MetricService) UpdateMetric(ctx context.Context, m model.Metric) (model.
 		return s.UpdateGauge(ctx, m)
 	case models.CounterValue:
 		return s.UpdateCounter(ctx, m)
+	case models.HistogramValue:
+		return s.UpdateHistogram(ctx, m)
 	default:
 		return nil, fmt.Errorf("unknown metric type")
 	}
 }
 
-func (s MetricService) UpdateAll(ctx context.Context, gs []models.GaugeValue, cs []models.CounterValue) error {
-	// TODO do we need single db transaction here?
-	if len(gs) > 0 {
-		err := s.r.SaveAllGauges(ctx, gs)
-		if err != nil {
-			return fmt.Errorf("could not save metris: %w", err)
+func (s MetricService) UpdateAll(ctx context.Context, gs []models.GaugeValue, cs []models.CounterValue, hs []models.HistogramValue) error {
+	_, err := s.UpdateBatch(ctx, "", gs, cs, hs)
+	return err
+}
+
+// UpdateBatch applies all updates of batch atomically. If batch with the same (non-empty) id was applied within dedup
+// window, nothing is applied and false is returned. If it is being applied concurrently, ErrBatchInProgress is returned
+func (s MetricService) UpdateBatch(ctx context.Context, id string, gs []models.GaugeValue, cs []models.CounterValue, hs []models.HistogramValue) (bool, error) {
+	err := s.r.WithinTransaction(ctx, func(r models.MetricRepository) error {
+		// batch is checked first, so that nothing is applied (and rolled back) for replayed batch
+		if err := s.saveBatchID(ctx, r, id); err != nil {
+			return err
 		}
-	}
-	if len(cs) > 0 {
-		err := s.r.AddAndSaveAllCounters(ctx, cs)
-		if err != nil {
-			return fmt.Errorf("could not save metris: %w", err)
+		if len(gs) > 0 {
+			if err := r.SaveAllGauges(ctx, gs); err != nil {
+				return err
+			}
+		}
+		if len(cs) > 0 {
+			if err := r.AddAndSaveAllCounters(ctx, cs); err != nil {
+				return err
+			}
+		}
+		if len(hs) > 0 {
+			if err := r.AddAndSaveAllHistograms(ctx, hs); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if errors.Is(err, errBatchApplied) {
+		return false, nil
+	}
+	if errors.Is(err, ErrBatchInProgress) {
+		return false, err
+	}
+	if err != nil {
+		s.forgetBatchID(id)
+		return false, fmt.Errorf("could not save metrics: %w", err)
+	}
+	s.commitBatchID(id)
+	for _, g := range gs {
+		s.notify(g)
+	}
+	for _, c := range cs {
+		s.notify(c)
+	}
+	for _, h := range hs {
+		s.notify(h)
 	}
 	if s.postUpdate != nil {
 		s.postUpdate()
 	}
-	return nil
+	return true, nil
 }
 
-func (s MetricService) Get(ctx context.Context, name string, mType string) (model.Metric, error) {
+// updated notifies listeners and calls post update function
+func (s MetricService) updated(m model.Metric) {
+	s.notify(m)
+	if s.postUpdate != nil {
+		s.postUpdate()
+	}
+}
+
+func (s MetricService) notify(m model.Metric) {
+	for _, l := range s.listeners {
+		l(m)
+	}
+}
+
+func (s MetricService) Get(ctx context.Context, name string, labels model.Labels, mType string) (model.Metric, error) {
 	switch mType {
 	case model.GAUGE:
-		g, err := s.r.GetGaugeByName(ctx, name)
+		g, err := s.r.GetGaugeByName(ctx, name, labels)
 		if err != nil {
 			return nil, fmt.Errorf("could not retrieve gauge value: %w", err)
 		}
@@ -79,7 +180,7 @@ func (s MetricService) Get(ctx context.Context, name string, mType string) (mode
 		}
 		return g, nil
 	case model.COUNTER:
-		c, err := s.r.GetCounterByName(ctx, name)
+		c, err := s.r.GetCounterByName(ctx, name, labels)
 		if err != nil {
 			return nil, fmt.Errorf("could not retrieve counter value: %w", err)
 		}
@@ -87,13 +188,23 @@ func (s MetricService) Get(ctx context.Context, name string, mType string) (mode
 			return nil, nil
 		}
 		return c, nil
+	case model.HISTOGRAM:
+		h, err := s.r.GetHistogramByName(ctx, name, labels)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve histogram value: %w", err)
+		}
+		if h == nil {
+			return nil, nil
+		}
+		return h, nil
 	default:
 		return nil, fmt.Errorf("unknown metric type")
 	}
 }
 
-func (s MetricService) GetAll(ctx context.Context) ([]model.Metric, error) {
-	ms, err := s.r.GetAll(ctx)
+// GetAll returns all metrics having labels from filter (all metrics if filter is empty)
+func (s MetricService) GetAll(ctx context.Context, filter model.Labels) ([]model.Metric, error) {
+	ms, err := s.r.GetAll(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve all metrics: %w", err)
 	}
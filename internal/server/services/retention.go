@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+var ErrInvalidRetentionPolicy = errors.New("invalid retention policy")
+
+// Rollup is a downsampling tier: history older than previous tier is compacted to one sample per Step and kept for Keep
+type Rollup struct {
+	Step time.Duration
+	Keep time.Duration
+}
+
+// RetentionPolicy describes how long metrics history is kept. Raw samples are kept for Raw, then they are compacted
+// by rollups (from finer to coarser) and deleted after the last rollup's Keep (or after Raw if there are no rollups).
+//
+// Text form is "raw=24h,1m=720h,1h=8760h": raw samples for a day, 1-minute rollups for 30 days and 1-hour rollups for a year
+//
+// Rollups of gauges are averages weighted by number of saved values, so that coarser tiers keep the true average.
+// Histograms have no history (only their current values are kept), so that policy does not apply to them
+type RetentionPolicy struct {
+	Raw     time.Duration
+	Rollups []Rollup
+}
+
+// IsZero returns whether policy is not set (history is kept forever)
+func (p RetentionPolicy) IsZero() bool {
+	return p.Raw == 0 && len(p.Rollups) == 0
+}
+
+// Validate checks that every tier keeps data longer than previous one with coarser step
+func (p RetentionPolicy) Validate() error {
+	if p.Raw <= 0 {
+		return fmt.Errorf("%w: raw retention must be positive", ErrInvalidRetentionPolicy)
+	}
+	keep := p.Raw
+	var step time.Duration
+	for _, r := range p.Rollups {
+		if r.Step <= 0 || r.Step <= step || (step > 0 && r.Step%step != 0) {
+			return fmt.Errorf("%w: rollup step %s must be greater than and multiple of previous", ErrInvalidRetentionPolicy, r.Step)
+		}
+		if r.Keep <= keep {
+			return fmt.Errorf("%w: rollup %s must be kept longer than previous tier", ErrInvalidRetentionPolicy, r.Step)
+		}
+		step, keep = r.Step, r.Keep
+	}
+	return nil
+}
+
+func (p RetentionPolicy) String() string {
+	if p.IsZero() {
+		return ""
+	}
+	parts := []string{"raw=" + p.Raw.String()}
+	for _, r := range p.Rollups {
+		parts = append(parts, r.Step.String()+"="+r.Keep.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p RetentionPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *RetentionPolicy) UnmarshalText(text []byte) error {
+	var policy RetentionPolicy
+	if len(text) == 0 {
+		*p = policy
+		return nil
+	}
+	for _, part := range strings.Split(string(text), ",") {
+		tier, keep, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return fmt.Errorf("%w: %s is not in step=duration form", ErrInvalidRetentionPolicy, part)
+		}
+		k, err := time.ParseDuration(keep)
+		if err != nil {
+			return fmt.Errorf("%w: could not parse duration: %v", ErrInvalidRetentionPolicy, err)
+		}
+		if tier == "raw" {
+			policy.Raw = k
+			continue
+		}
+		step, err := time.ParseDuration(tier)
+		if err != nil {
+			return fmt.Errorf("%w: could not parse rollup step: %v", ErrInvalidRetentionPolicy, err)
+		}
+		policy.Rollups = append(policy.Rollups, Rollup{Step: step, Keep: k})
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// RetentionService periodically compacts and deletes old metrics history according to retention policy
+type RetentionService struct {
+	h        models.MetricHistoryRepository
+	policy   RetentionPolicy
+	interval time.Duration
+}
+
+func NewRetentionService(h models.MetricHistoryRepository, policy RetentionPolicy, interval time.Duration) *RetentionService {
+	return &RetentionService{
+		h:        h,
+		policy:   policy,
+		interval: interval,
+	}
+}
+
+// Enforce compacts and deletes history which is old at a given time
+func (s RetentionService) Enforce(ctx context.Context, now time.Time) error {
+	newer := now.Add(-s.policy.Raw)
+	for _, r := range s.policy.Rollups {
+		older := now.Add(-r.Keep)
+		err := s.h.CompactHistory(ctx, rollupStart(older, r.Step), rollupStart(newer, r.Step), r.Step)
+		if err != nil {
+			return fmt.Errorf("could not compact history to %s rollups: %w", r.Step, err)
+		}
+		newer = older
+	}
+	err := s.h.DeleteHistory(ctx, newer)
+	if err != nil {
+		return fmt.Errorf("could not delete history: %w", err)
+	}
+	return nil
+}
+
+// rollupStart returns start of step-long rollup bucket which t belongs to. Buckets are aligned to Unix epoch as rollups
+// of history repositories are (time.Truncate aligns them to zero time, which differs for steps not dividing a day)
+func rollupStart(t time.Time, step time.Duration) time.Time {
+	return t.Add(-time.Duration(t.UnixNano() % int64(step)))
+}
+
+// Run enforces retention policy periodically until context is done
+func (s RetentionService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Enforce(ctx, time.Now())
+			if err != nil {
+				log.Error().Err(err).Msg("could not enforce retention policy")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
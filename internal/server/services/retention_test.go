@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+type compaction struct {
+	from time.Time
+	to   time.Time
+	step time.Duration
+}
+
+type historyStub struct {
+	models.MetricHistoryRepository
+	compactions []compaction
+	deleted     time.Time
+}
+
+func (h *historyStub) CompactHistory(_ context.Context, from time.Time, to time.Time, step time.Duration) error {
+	h.compactions = append(h.compactions, compaction{from: from, to: to, step: step})
+	return nil
+}
+
+func (h *historyStub) DeleteHistory(_ context.Context, t time.Time) error {
+	h.deleted = t
+	return nil
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		var p RetentionPolicy
+		require.NoError(t, p.UnmarshalText([]byte("raw=24h, 1m=720h, 1h=8760h")))
+		assert.Equal(t, RetentionPolicy{
+			Raw: 24 * time.Hour,
+			Rollups: []Rollup{
+				{Step: time.Minute, Keep: 720 * time.Hour},
+				{Step: time.Hour, Keep: 8760 * time.Hour},
+			},
+		}, p)
+		assert.Equal(t, "raw=24h0m0s,1m0s=720h0m0s,1h0m0s=8760h0m0s", p.String())
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{
+			"1m=720h",
+			"raw=24h,1m",
+			"raw=24h,1m=12h",
+			"raw=24h,1h=720h,1m=8760h",
+			"raw=24h,1m=720h,90s=8760h",
+			"raw=1x",
+		} {
+			var p RetentionPolicy
+			assert.ErrorIs(t, p.UnmarshalText([]byte(s)), ErrInvalidRetentionPolicy, s)
+		}
+	})
+}
+
+func TestRetentionService(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 30, 30, 0, time.UTC)
+	t.Run("rollups", func(t *testing.T) {
+		h := &historyStub{}
+		s := NewRetentionService(h, RetentionPolicy{
+			Raw: 24 * time.Hour,
+			Rollups: []Rollup{
+				{Step: time.Minute, Keep: 30 * 24 * time.Hour},
+				{Step: time.Hour, Keep: 365 * 24 * time.Hour},
+			},
+		}, time.Hour)
+		require.NoError(t, s.Enforce(context.Background(), now))
+		assert.Equal(t, []compaction{
+			{from: time.Date(2022, 5, 2, 12, 30, 0, 0, time.UTC), to: time.Date(2022, 5, 31, 12, 30, 0, 0, time.UTC), step: time.Minute},
+			{from: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), to: time.Date(2022, 5, 2, 12, 0, 0, 0, time.UTC), step: time.Hour},
+		}, h.compactions)
+		assert.Equal(t, time.Date(2021, 6, 1, 12, 30, 30, 0, time.UTC), h.deleted)
+	})
+	t.Run("rollup bounds aligned to Unix epoch", func(t *testing.T) {
+		h := &historyStub{}
+		step := 7 * time.Minute
+		s := NewRetentionService(h, RetentionPolicy{
+			Raw:     time.Hour,
+			Rollups: []Rollup{{Step: step, Keep: 24 * time.Hour}},
+		}, time.Hour)
+		require.NoError(t, s.Enforce(context.Background(), now))
+		require.Len(t, h.compactions, 1)
+		assert.Zero(t, h.compactions[0].from.UnixNano()%int64(step))
+		assert.Zero(t, h.compactions[0].to.UnixNano()%int64(step))
+		assert.False(t, h.compactions[0].to.After(now.Add(-time.Hour)))
+		assert.True(t, h.compactions[0].to.After(now.Add(-time.Hour-step)))
+	})
+	t.Run("raw only", func(t *testing.T) {
+		h := &historyStub{}
+		s := NewRetentionService(h, RetentionPolicy{Raw: time.Hour}, time.Hour)
+		require.NoError(t, s.Enforce(context.Background(), now))
+		assert.Empty(t, h.compactions)
+		assert.Equal(t, now.Add(-time.Hour), h.deleted)
+	})
+}
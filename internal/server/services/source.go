@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+// GetSources returns summary of metrics grouped by source label, sorted by source
+func (s MetricService) GetSources(ctx context.Context) ([]models.Source, error) {
+	ms, err := s.r.GetAll(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve all metrics: %w", err)
+	}
+	sources := make(map[string]*models.Source)
+	for _, m := range ms {
+		name := m.Labels()[model.SourceLabel]
+		src, ok := sources[name]
+		if !ok {
+			src = &models.Source{Name: name}
+			sources[name] = src
+		}
+		if t, ok := m.(models.Timestamped); ok && t.LastUpdated().After(src.LastSeen) {
+			src.LastSeen = t.LastUpdated()
+		}
+		switch m.Type() {
+		case model.GAUGE:
+			src.Gauges++
+		case model.COUNTER:
+			src.Counters++
+		case model.HISTOGRAM:
+			src.Histograms++
+		}
+	}
+	result := make([]models.Source, 0, len(sources))
+	for _, src := range sources {
+		src.Stale = s.stale(src.LastSeen)
+		result = append(result, *src)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
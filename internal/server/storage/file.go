@@ -18,8 +18,9 @@ type JSONFilePersistence struct {
 }
 
 type data struct {
-	Gauges   []models.GaugeValue
-	Counters []models.CounterValue
+	Gauges     []models.GaugeValue
+	Counters   []models.CounterValue
+	Histograms []models.HistogramValue `json:",omitempty"`
 }
 
 func (fp JSONFilePersistence) Load(ctx context.Context, r models.MetricRepository) error {
@@ -41,50 +42,67 @@ func (fp JSONFilePersistence) Load(ctx context.Context, r models.MetricRepositor
 		return fmt.Errorf("failed to parse json in persistense file: %w", err)
 	}
 	for _, g := range d.Gauges {
-		log.Debug().Msgf("Loaded gauge %v = %v", g.Name, g.Value)
-		_, err := r.SaveGauge(ctx, g.Name, g.Value)
+		log.Debug().Msgf("Loaded gauge %v%v = %v", g.Name, g.LabelSet, g.Value)
+		_, err := r.SaveGauge(ctx, g.Name, g.LabelSet, g.Value)
 		if err != nil {
 			log.Error().Err(err).Msg("error saving gauge to repository")
 		}
 	}
 	for _, c := range d.Counters {
-		log.Debug().Msgf("Loaded counter %v = %v", c.Name, c.Value)
-		_, err := r.SaveCounter(ctx, c.Name, c.Value)
+		log.Debug().Msgf("Loaded counter %v%v = %v", c.Name, c.LabelSet, c.Value)
+		_, err := r.SaveCounter(ctx, c.Name, c.LabelSet, c.Value)
 		if err != nil {
 			log.Error().Err(err).Msg("error saving counter to repository")
 		}
 	}
+	for _, h := range d.Histograms {
+		log.Debug().Msgf("Loaded histogram %v%v = %v", h.Name, h.LabelSet, h.Value)
+		_, err := r.SaveHistogram(ctx, h.Name, h.LabelSet, h.Value)
+		if err != nil {
+			log.Error().Err(err).Msg("error saving histogram to repository")
+		}
+	}
 	return nil
 }
 
 func (fp JSONFilePersistence) Save(ctx context.Context, r models.MetricRepository) error {
 	// TODO make save operation atomic
 	log.Debug().Msgf("Saving metrics to %v", fp.file.Name())
-	ms, err := r.GetAll(ctx)
+	ms, err := r.GetAll(ctx, nil)
 	if err != nil {
 		return err
 	}
 	gauges := make([]models.GaugeValue, 0)
 	counters := make([]models.CounterValue, 0)
+	histograms := make([]models.HistogramValue, 0)
 
 	for _, m := range ms {
 		switch m.Type() {
 		case model.GAUGE:
 			gauges = append(gauges, models.GaugeValue{
-				Name:  m.ID(),
-				Value: m.Val().(float64),
+				Name:     m.ID(),
+				LabelSet: m.Labels(),
+				Value:    m.Val().(float64),
 			})
 		case model.COUNTER:
 			counters = append(counters, models.CounterValue{
-				Name:  m.ID(),
-				Value: m.Val().(int64),
+				Name:     m.ID(),
+				LabelSet: m.Labels(),
+				Value:    m.Val().(int64),
+			})
+		case model.HISTOGRAM:
+			histograms = append(histograms, models.HistogramValue{
+				Name:     m.ID(),
+				LabelSet: m.Labels(),
+				Value:    m.Val().(model.Histogram),
 			})
 		}
 	}
 
 	d := data{
-		Gauges:   gauges,
-		Counters: counters,
+		Gauges:     gauges,
+		Counters:   counters,
+		Histograms: histograms,
 	}
 	bs, err := json.MarshalIndent(d, "", "  ")
 	if err != nil {
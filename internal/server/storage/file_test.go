@@ -4,9 +4,12 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/tony-spark/metrico/internal/model"
 )
 
 func TestJSONFilePersistence(t *testing.T) {
@@ -22,15 +25,32 @@ func TestJSONFilePersistence(t *testing.T) {
 		jfp, err := NewJSONFilePersistence(tempf.Name())
 		require.Nil(t, err)
 		rBefore := NewSingleValueRepository()
-		_, err = rBefore.SaveGauge(context.Background(), "TestGauge", 1.0)
+		_, err = rBefore.SaveGauge(context.Background(), "TestGauge", nil, 1.0)
+		assert.Nil(t, err)
+		_, err = rBefore.SaveCounter(context.Background(), "TestCounter", nil, 13)
 		assert.Nil(t, err)
-		_, err = rBefore.SaveCounter(context.Background(), "TestCounter", 13)
+		_, err = rBefore.SaveHistogram(context.Background(), "TestHistogram", model.Labels{"host": "a"}, model.Histogram{Bounds: []float64{1}, Counts: []int64{1, 2}, Count: 3, Sum: 5})
 		assert.Nil(t, err)
 		err = jfp.Save(context.Background(), rBefore)
 		assert.Nil(t, err)
 		rAfter := NewSingleValueRepository()
 		err = jfp.Load(context.Background(), rAfter)
 		assert.Nil(t, err)
+		// restored metrics are updated at load time
+		resetUpdatedAt(rBefore)
+		resetUpdatedAt(rAfter)
 		assert.Equal(t, rBefore, rAfter)
 	})
 }
+
+func resetUpdatedAt(r *SingleValueRepository) {
+	for _, g := range r.gauges {
+		g.UpdatedAt = time.Time{}
+	}
+	for _, c := range r.counters {
+		c.UpdatedAt = time.Time{}
+	}
+	for _, h := range r.histograms {
+		h.UpdatedAt = time.Time{}
+	}
+}
@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+// HistoryRepository is an in-memory models.MetricHistoryRepository.
+// Current values are kept in SingleValueRepository, every saved gauge value and counter increment is also appended to history
+type HistoryRepository struct {
+	r        *SingleValueRepository
+	mu       rwLocker
+	gauges   map[string][]models.GaugeSample
+	counters map[string][]models.CounterSample
+	now      func() time.Time
+	appended *appendLog // series appended to within transaction, nil if not bound to transaction
+}
+
+// appendLog keeps lengths of series appended to within transaction as they were before the first append, so that only
+// these series are truncated if transaction fails
+type appendLog struct {
+	gauges   map[string]int
+	counters map[string]int
+}
+
+// rwLocker is implemented by *sync.RWMutex
+type rwLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+// noLock is used by repository within transaction which already holds the lock
+type noLock struct{}
+
+func (noLock) Lock()    {}
+func (noLock) Unlock()  {}
+func (noLock) RLock()   {}
+func (noLock) RUnlock() {}
+
+func NewHistoryRepository() *HistoryRepository {
+	return &HistoryRepository{
+		r:        NewSingleValueRepository(),
+		mu:       new(sync.RWMutex),
+		gauges:   make(map[string][]models.GaugeSample),
+		counters: make(map[string][]models.CounterSample),
+		now:      time.Now,
+	}
+}
+
+func (h HistoryRepository) GetGaugeByName(ctx context.Context, name string, labels model.Labels) (*models.GaugeValue, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	g, err := h.r.GetGaugeByName(ctx, name, labels)
+	if err != nil || g == nil {
+		return nil, err
+	}
+	gv := *g
+	return &gv, nil
+}
+
+func (h HistoryRepository) SaveGauge(ctx context.Context, name string, labels model.Labels, value float64) (*models.GaugeValue, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.saveGauge(ctx, name, labels, value, h.now())
+}
+
+func (h HistoryRepository) SaveAllGauges(ctx context.Context, gs []models.GaugeValue) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	for _, g := range gs {
+		_, err := h.saveGauge(ctx, g.Name, g.LabelSet, g.Value, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h HistoryRepository) GetCounterByName(ctx context.Context, name string, labels model.Labels) (*models.CounterValue, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	c, err := h.r.GetCounterByName(ctx, name, labels)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	cv := *c
+	return &cv, nil
+}
+
+func (h HistoryRepository) AddAndSaveCounter(ctx context.Context, name string, labels model.Labels, value int64) (*models.CounterValue, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.addAndSaveCounter(ctx, name, labels, value, h.now())
+}
+
+func (h HistoryRepository) AddAndSaveAllCounters(ctx context.Context, cs []models.CounterValue) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	for _, c := range cs {
+		_, err := h.addAndSaveCounter(ctx, c.Name, c.LabelSet, c.Value, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveCounter sets counter value without adding it to history (e.g. when restoring from persistence)
+func (h HistoryRepository) SaveCounter(ctx context.Context, name string, labels model.Labels, value int64) (*models.CounterValue, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, err := h.r.SaveCounter(ctx, name, labels, value)
+	if err != nil {
+		return nil, err
+	}
+	cv := *c
+	return &cv, nil
+}
+
+func (h HistoryRepository) GetHistogramByName(ctx context.Context, name string, labels model.Labels) (*models.HistogramValue, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	hg, err := h.r.GetHistogramByName(ctx, name, labels)
+	if err != nil || hg == nil {
+		return nil, err
+	}
+	hv := *hg
+	hv.Value = hg.Value.Copy()
+	return &hv, nil
+}
+
+// AddAndSaveHistogram adds observations to histogram, histograms history is not kept
+func (h HistoryRepository) AddAndSaveHistogram(ctx context.Context, name string, labels model.Labels, value model.Histogram) (*models.HistogramValue, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hg, err := h.r.AddAndSaveHistogram(ctx, name, labels, value)
+	if err != nil {
+		return nil, err
+	}
+	hv := *hg
+	hv.Value = hg.Value.Copy()
+	return &hv, nil
+}
+
+func (h HistoryRepository) AddAndSaveAllHistograms(ctx context.Context, hs []models.HistogramValue) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.r.AddAndSaveAllHistograms(ctx, hs)
+}
+
+func (h HistoryRepository) SaveHistogram(ctx context.Context, name string, labels model.Labels, value model.Histogram) (*models.HistogramValue, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hg, err := h.r.SaveHistogram(ctx, name, labels, value)
+	if err != nil {
+		return nil, err
+	}
+	hv := *hg
+	hv.Value = hg.Value.Copy()
+	return &hv, nil
+}
+
+func (h HistoryRepository) GetAll(ctx context.Context, filter model.Labels) ([]model.Metric, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.r.GetAll(ctx, filter)
+}
+
+// DeleteNotUpdatedSince removes current values of metrics, their history is kept
+func (h HistoryRepository) DeleteNotUpdatedSince(ctx context.Context, t time.Time) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.r.DeleteNotUpdatedSince(ctx, t)
+}
+
+func (h HistoryRepository) GetGaugeHistory(_ context.Context, name string, labels model.Labels, from time.Time, to time.Time) ([]models.GaugeSample, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	samples := h.gauges[seriesKey(name, labels)]
+	i, j := timeRange(len(samples), func(i int) time.Time { return samples[i].Timestamp }, from, to)
+	result := make([]models.GaugeSample, j-i)
+	copy(result, samples[i:j])
+	return result, nil
+}
+
+func (h HistoryRepository) GetCounterHistory(_ context.Context, name string, labels model.Labels, from time.Time, to time.Time) ([]models.CounterSample, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	samples := h.counters[seriesKey(name, labels)]
+	i, j := timeRange(len(samples), func(i int) time.Time { return samples[i].Timestamp }, from, to)
+	result := make([]models.CounterSample, j-i)
+	copy(result, samples[i:j])
+	return result, nil
+}
+
+func (h HistoryRepository) CompactHistory(_ context.Context, from time.Time, to time.Time, step time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, samples := range h.gauges {
+		i, j := halfOpenRange(len(samples), func(i int) time.Time { return samples[i].Timestamp }, from, to)
+		compacted := make([]models.GaugeSample, 0, len(samples))
+		compacted = append(compacted, samples[:i]...)
+		for k := i; k < j; {
+			bucket := bucketStart(samples[k].Timestamp, step)
+			sample := samples[k]
+			sum := 0.0
+			n, weight := 0, 0
+			for ; k < j && bucketStart(samples[k].Timestamp, step).Equal(bucket); k++ {
+				w := samples[k].Weight()
+				sum += samples[k].Value * float64(w)
+				weight += w
+				n++
+			}
+			if n > 1 {
+				sample.Value = sum / float64(weight)
+				sample.Samples = weight
+				sample.Timestamp = bucket
+			}
+			compacted = append(compacted, sample)
+		}
+		h.gauges[key] = append(compacted, samples[j:]...)
+	}
+
+	for key, samples := range h.counters {
+		i, j := halfOpenRange(len(samples), func(i int) time.Time { return samples[i].Timestamp }, from, to)
+		compacted := make([]models.CounterSample, 0, len(samples))
+		compacted = append(compacted, samples[:i]...)
+		for k := i; k < j; {
+			bucket := bucketStart(samples[k].Timestamp, step)
+			sample := samples[k]
+			var delta int64
+			n := 0
+			for ; k < j && bucketStart(samples[k].Timestamp, step).Equal(bucket); k++ {
+				delta += samples[k].Delta
+				sample.Value = samples[k].Value
+				n++
+			}
+			if n > 1 {
+				sample.Delta = delta
+				sample.Timestamp = bucket
+			}
+			compacted = append(compacted, sample)
+		}
+		h.counters[key] = append(compacted, samples[j:]...)
+	}
+	return nil
+}
+
+func (h HistoryRepository) DeleteHistory(_ context.Context, t time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, samples := range h.gauges {
+		i := sort.Search(len(samples), func(i int) bool {
+			return !samples[i].Timestamp.Before(t)
+		})
+		if i == len(samples) {
+			delete(h.gauges, key)
+			continue
+		}
+		h.gauges[key] = append([]models.GaugeSample(nil), samples[i:]...)
+	}
+	for key, samples := range h.counters {
+		i := sort.Search(len(samples), func(i int) bool {
+			return !samples[i].Timestamp.Before(t)
+		})
+		if i == len(samples) {
+			delete(h.counters, key)
+			continue
+		}
+		h.counters[key] = append([]models.CounterSample(nil), samples[i:]...)
+	}
+	return nil
+}
+
+func (h HistoryRepository) saveGauge(ctx context.Context, name string, labels model.Labels, value float64, ts time.Time) (*models.GaugeValue, error) {
+	g, err := h.r.SaveGauge(ctx, name, labels, value)
+	if err != nil {
+		return nil, err
+	}
+	key := seriesKey(name, labels)
+	h.appended.gauge(key, len(h.gauges[key]))
+	h.gauges[key] = append(h.gauges[key], models.GaugeSample{
+		Name:      name,
+		LabelSet:  g.LabelSet,
+		Value:     value,
+		Samples:   1,
+		Timestamp: ts,
+	})
+	gv := *g
+	return &gv, nil
+}
+
+func (h HistoryRepository) addAndSaveCounter(ctx context.Context, name string, labels model.Labels, value int64, ts time.Time) (*models.CounterValue, error) {
+	c, err := h.r.AddAndSaveCounter(ctx, name, labels, value)
+	if err != nil {
+		return nil, err
+	}
+	key := seriesKey(name, labels)
+	h.appended.counter(key, len(h.counters[key]))
+	h.counters[key] = append(h.counters[key], models.CounterSample{
+		Name:      name,
+		LabelSet:  c.LabelSet,
+		Delta:     value,
+		Value:     c.Value,
+		Timestamp: ts,
+	})
+	cv := *c
+	return &cv, nil
+}
+
+// timeRange returns bounds [i, j) of samples (ordered by time) which timestamps are within [from, to]
+func timeRange(n int, ts func(i int) time.Time, from time.Time, to time.Time) (int, int) {
+	i := sort.Search(n, func(k int) bool {
+		return !ts(k).Before(from)
+	})
+	j := sort.Search(n, func(k int) bool {
+		return ts(k).After(to)
+	})
+	if j < i {
+		j = i
+	}
+	return i, j
+}
+
+// halfOpenRange returns bounds [i, j) of samples (ordered by time) which timestamps are within [from, to)
+func halfOpenRange(n int, ts func(i int) time.Time, from time.Time, to time.Time) (int, int) {
+	i := sort.Search(n, func(k int) bool {
+		return !ts(k).Before(from)
+	})
+	j := sort.Search(n, func(k int) bool {
+		return !ts(k).Before(to)
+	})
+	if j < i {
+		j = i
+	}
+	return i, j
+}
+
+// bucketStart returns start of step-long time bucket (aligned to Unix epoch) which ts belongs to
+func bucketStart(ts time.Time, step time.Duration) time.Time {
+	return time.Unix(0, ts.UnixNano()-ts.UnixNano()%int64(step))
+}
+
+// WithinTransaction calls fn with repository bound to the same data, changes of fn are reverted if it fails.
+// Repository is locked until fn returns
+func (h HistoryRepository) WithinTransaction(_ context.Context, fn func(r models.MetricRepository) error) error {
+	if h.appended != nil {
+		// changes of nested transaction are reverted by the outer one
+		return fn(h)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// current values are accessed under repository lock only, so that their lock is not needed
+	return h.r.withinTransaction(func(r *SingleValueRepository) error {
+		tx := h
+		tx.mu = noLock{}
+		tx.r = r
+		tx.appended = &appendLog{
+			gauges:   make(map[string]int),
+			counters: make(map[string]int),
+		}
+		err := fn(tx)
+		if err == nil {
+			return nil
+		}
+		for key, n := range tx.appended.gauges {
+			if n > 0 {
+				h.gauges[key] = h.gauges[key][:n]
+			} else {
+				delete(h.gauges, key)
+			}
+		}
+		for key, n := range tx.appended.counters {
+			if n > 0 {
+				h.counters[key] = h.counters[key][:n]
+			} else {
+				delete(h.counters, key)
+			}
+		}
+		return err
+	})
+}
+
+// gauge records length of gauge series unless it is already recorded, log may be nil
+func (l *appendLog) gauge(key string, n int) {
+	if l == nil {
+		return
+	}
+	if _, ok := l.gauges[key]; !ok {
+		l.gauges[key] = n
+	}
+}
+
+// counter records length of counter series unless it is already recorded, log may be nil
+func (l *appendLog) counter(key string, n int) {
+	if l == nil {
+		return
+	}
+	if _, ok := l.counters[key]; !ok {
+		l.counters[key] = n
+	}
+}
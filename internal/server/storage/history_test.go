@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tony-spark/metrico/internal/server/models"
+)
+
+func TestHistoryRepository(t *testing.T) {
+	r := NewHistoryRepository()
+
+	t.Run("gauge saved and found", func(t *testing.T) {
+		name := "test1"
+		gauge, err := r.SaveGauge(context.Background(), name, nil, float64(3.14))
+		assert.NotNil(t, gauge)
+		assert.Nil(t, err)
+		gauge, err = r.GetGaugeByName(context.Background(), name, nil)
+		assert.NotNil(t, gauge)
+		assert.Nil(t, err)
+	})
+	t.Run("gauge history", func(t *testing.T) {
+		name := "test2"
+		values := []float64{1.5, 2.5, 3.5}
+		from := time.Now()
+		for _, v := range values {
+			_, err := r.SaveGauge(context.Background(), name, nil, v)
+			require.NoError(t, err)
+		}
+		err := r.SaveAllGauges(context.Background(), []models.GaugeValue{{Name: name, Value: 4.5}})
+		require.NoError(t, err)
+		to := time.Now()
+
+		gs, err := r.GetGaugeHistory(context.Background(), name, nil, from, to)
+		require.NoError(t, err)
+		require.Len(t, gs, 4)
+		for i, v := range append(values, 4.5) {
+			assert.Equal(t, v, gs[i].Value)
+			assert.Equal(t, name, gs[i].Name)
+		}
+		for i := 1; i < len(gs); i++ {
+			assert.False(t, gs[i].Timestamp.Before(gs[i-1].Timestamp))
+		}
+	})
+	t.Run("gauge history out of range", func(t *testing.T) {
+		gs, err := r.GetGaugeHistory(context.Background(), "test2", nil, time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))
+		assert.NoError(t, err)
+		assert.Empty(t, gs)
+		gs, err = r.GetGaugeHistory(context.Background(), "absent", nil, time.Time{}, time.Now())
+		assert.NoError(t, err)
+		assert.Empty(t, gs)
+	})
+	t.Run("counter history", func(t *testing.T) {
+		name := "test3"
+		values := []int64{1, 4, 5}
+		sums := []int64{1, 5, 10}
+		from := time.Now()
+		for _, v := range values {
+			_, err := r.AddAndSaveCounter(context.Background(), name, nil, v)
+			require.NoError(t, err)
+		}
+		to := time.Now()
+
+		cs, err := r.GetCounterHistory(context.Background(), name, nil, from, to)
+		require.NoError(t, err)
+		require.Len(t, cs, 3)
+		for i := range values {
+			assert.Equal(t, values[i], cs[i].Delta)
+			assert.Equal(t, sums[i], cs[i].Value)
+		}
+	})
+	t.Run("counter restore not in history", func(t *testing.T) {
+		name := "test4"
+		from := time.Now()
+		_, err := r.SaveCounter(context.Background(), name, nil, 100)
+		require.NoError(t, err)
+		counter, err := r.GetCounterByName(context.Background(), name, nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), counter.Value)
+		cs, err := r.GetCounterHistory(context.Background(), name, nil, from, time.Now())
+		assert.NoError(t, err)
+		assert.Empty(t, cs)
+	})
+	t.Run("transaction rollback", func(t *testing.T) {
+		name := "test5"
+		_, err := r.AddAndSaveCounter(context.Background(), name, nil, 1)
+		require.NoError(t, err)
+		from := time.Now()
+		failure := errors.New("failure")
+		err = r.WithinTransaction(context.Background(), func(tx models.MetricRepository) error {
+			err := tx.AddAndSaveAllCounters(context.Background(), []models.CounterValue{{Name: name, Value: 2}})
+			require.NoError(t, err)
+			_, err = tx.SaveGauge(context.Background(), name, nil, 1)
+			require.NoError(t, err)
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+		counter, err := r.GetCounterByName(context.Background(), name, nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), counter.Value)
+		cs, err := r.GetCounterHistory(context.Background(), name, nil, from, time.Now())
+		assert.NoError(t, err)
+		assert.Empty(t, cs)
+		gauge, err := r.GetGaugeByName(context.Background(), name, nil)
+		assert.NoError(t, err)
+		assert.Nil(t, gauge)
+		gs, err := r.GetGaugeHistory(context.Background(), name, nil, from, time.Now())
+		assert.NoError(t, err)
+		assert.Empty(t, gs)
+	})
+	t.Run("nested transaction rollback", func(t *testing.T) {
+		name := "test6"
+		from := time.Now()
+		failure := errors.New("failure")
+		err := r.WithinTransaction(context.Background(), func(tx models.MetricRepository) error {
+			err := tx.WithinTransaction(context.Background(), func(tx models.MetricRepository) error {
+				_, err := tx.AddAndSaveCounter(context.Background(), name, nil, 2)
+				return err
+			})
+			require.NoError(t, err)
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+		counter, err := r.GetCounterByName(context.Background(), name, nil)
+		require.NoError(t, err)
+		assert.Nil(t, counter)
+		cs, err := r.GetCounterHistory(context.Background(), name, nil, from, time.Now())
+		assert.NoError(t, err)
+		assert.Empty(t, cs)
+	})
+}
+
+func TestHistoryRetention(t *testing.T) {
+	r := NewHistoryRepository()
+	start := time.Unix(1654041600, 0) // 2022-06-01 00:00:00 UTC
+	now := start
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// two samples a minute for 3 minutes
+	for i := 0; i < 6; i++ {
+		now = start.Add(time.Duration(i) * 30 * time.Second)
+		_, err := r.SaveGauge(ctx, "g", nil, float64(i))
+		require.NoError(t, err)
+		_, err = r.AddAndSaveCounter(ctx, "c", nil, int64(i))
+		require.NoError(t, err)
+	}
+	all := func() ([]models.GaugeSample, []models.CounterSample) {
+		gs, err := r.GetGaugeHistory(ctx, "g", nil, time.Time{}, now)
+		require.NoError(t, err)
+		cs, err := r.GetCounterHistory(ctx, "c", nil, time.Time{}, now)
+		require.NoError(t, err)
+		return gs, cs
+	}
+
+	t.Run("compact", func(t *testing.T) {
+		// the last minute is not compacted
+		require.NoError(t, r.CompactHistory(ctx, start, start.Add(2*time.Minute), time.Minute))
+		gs, cs := all()
+		require.Len(t, gs, 4)
+		assert.Equal(t, models.GaugeSample{Name: "g", Value: 0.5, Samples: 2, Timestamp: start}, gs[0])
+		assert.Equal(t, models.GaugeSample{Name: "g", Value: 2.5, Samples: 2, Timestamp: start.Add(time.Minute)}, gs[1])
+		assert.Equal(t, 4.0, gs[2].Value)
+		require.Len(t, cs, 4)
+		assert.Equal(t, models.CounterSample{Name: "c", Delta: 1, Value: 1, Timestamp: start}, cs[0])
+		assert.Equal(t, models.CounterSample{Name: "c", Delta: 5, Value: 6, Timestamp: start.Add(time.Minute)}, cs[1])
+		assert.Equal(t, int64(4), cs[2].Delta)
+	})
+	t.Run("compaction is repeatable", func(t *testing.T) {
+		gsBefore, csBefore := all()
+		require.NoError(t, r.CompactHistory(ctx, start, start.Add(2*time.Minute), time.Minute))
+		gs, cs := all()
+		assert.Equal(t, gsBefore, gs)
+		assert.Equal(t, csBefore, cs)
+	})
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, r.DeleteHistory(ctx, start.Add(2*time.Minute)))
+		gs, cs := all()
+		assert.Len(t, gs, 2)
+		assert.Len(t, cs, 2)
+		require.NoError(t, r.DeleteHistory(ctx, now.Add(time.Second)))
+		gs, cs = all()
+		assert.Empty(t, gs)
+		assert.Empty(t, cs)
+		counter, err := r.GetCounterByName(ctx, "c", nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(15), counter.Value, "current values are kept")
+	})
+	t.Run("coarser rollup is weighted", func(t *testing.T) {
+		r := NewHistoryRepository()
+		// two values in the first minute and one value in the second one
+		for i, v := range []float64{1, 2, 6} {
+			now = start.Add(time.Duration(i) * 40 * time.Second)
+			r.now = func() time.Time { return now }
+			_, err := r.SaveGauge(ctx, "g", nil, v)
+			require.NoError(t, err)
+		}
+		require.NoError(t, r.CompactHistory(ctx, start, start.Add(2*time.Minute), time.Minute))
+		require.NoError(t, r.CompactHistory(ctx, start, start.Add(2*time.Minute), 2*time.Minute))
+		gs, err := r.GetGaugeHistory(ctx, "g", nil, time.Time{}, now)
+		require.NoError(t, err)
+		assert.Equal(t, []models.GaugeSample{{Name: "g", Value: 3, Samples: 3, Timestamp: start}}, gs)
+	})
+}
@@ -3,8 +3,11 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -17,13 +20,107 @@ import (
 	"github.com/tony-spark/metrico/internal/server/models"
 )
 
+// saved values are also added to history (see db migrations)
+const (
+	saveGaugeQuery = `WITH g AS (
+				INSERT INTO gauges(name, labels, value) VALUES ($1, $2, $3)
+				ON CONFLICT (name, labels) DO UPDATE
+				SET value = excluded.value, updated_at = now()
+				RETURNING name, labels, value
+			)
+			INSERT INTO gauge_history(name, labels, value)
+			SELECT name, labels, value FROM g
+			RETURNING ts`
+	addAndSaveCounterQuery = `WITH c AS (
+				INSERT INTO counters(name, labels, value) VALUES ($1, $2, $3)
+				ON CONFLICT (name, labels) DO UPDATE
+				SET value = counters.value + excluded.value, updated_at = now()
+				RETURNING counters.name, counters.labels, counters.value
+			)
+			INSERT INTO counter_history(name, labels, delta, value)
+			SELECT name, labels, $3, value FROM c
+			RETURNING name, labels, value, ts`
+)
+
+// applied batches are kept until they are pruned, batch applied before $2 is saved again, see SaveBatchID
+const (
+	deleteBatchesQuery = `DELETE FROM batches WHERE applied_at < $1`
+	saveBatchQuery     = `INSERT INTO batches(id) VALUES ($1)
+		ON CONFLICT (id) DO UPDATE SET applied_at = now() WHERE batches.applied_at < $2`
+	// batchesPruneInterval is a minimal interval between deletions of old batches
+	batchesPruneInterval = time.Minute
+)
+
+// history compaction queries: $1 and $2 are bounds of compacted range, $3 is a bucket size in seconds
+const (
+	compactGaugeHistoryQuery = `WITH buckets AS (
+				SELECT name, labels, to_timestamp(floor(extract(epoch FROM ts)::double precision / $3::double precision) * $3::double precision) AS bucket
+				FROM gauge_history
+				WHERE ts >= $1 AND ts < $2
+				GROUP BY 1, 2, 3
+				HAVING count(*) > 1
+			), compacted AS (
+				DELETE FROM gauge_history h
+				USING buckets b
+				WHERE h.name = b.name AND h.labels = b.labels AND h.ts >= b.bucket AND h.ts < b.bucket + $3::double precision * interval '1 second'
+				RETURNING h.name, h.labels, h.value, h.samples, b.bucket
+			)
+			INSERT INTO gauge_history(name, labels, value, samples, ts)
+			SELECT name, labels, sum(value * samples) / sum(samples), sum(samples), bucket FROM compacted
+			GROUP BY name, labels, bucket`
+	compactCounterHistoryQuery = `WITH buckets AS (
+				SELECT name, labels, to_timestamp(floor(extract(epoch FROM ts)::double precision / $3::double precision) * $3::double precision) AS bucket
+				FROM counter_history
+				WHERE ts >= $1 AND ts < $2
+				GROUP BY 1, 2, 3
+				HAVING count(*) > 1
+			), compacted AS (
+				DELETE FROM counter_history h
+				USING buckets b
+				WHERE h.name = b.name AND h.labels = b.labels AND h.ts >= b.bucket AND h.ts < b.bucket + $3::double precision * interval '1 second'
+				RETURNING h.name, h.labels, h.delta, h.value, h.ts, b.bucket
+			)
+			INSERT INTO counter_history(name, labels, delta, value, ts)
+			SELECT name, labels, sum(delta), (array_agg(value ORDER BY ts DESC))[1], bucket FROM compacted
+			GROUP BY name, labels, bucket`
+)
+
 type PgDatabaseManager struct {
 	db  *sql.DB
 	mdb MetricDВ
 }
 
 type MetricDВ struct {
-	db *sql.DB
+	db       *sql.DB
+	tx       *sql.Tx // transaction repository is bound to (see WithinTransaction), nil if not bound
+	prunedAt *int64  // Unix time (in nanoseconds) old batches were deleted at, see SaveBatchID
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+type transaction interface {
+	querier
+	Commit() error
+	Rollback() error
+}
+
+// nestedTx is a part of outer transaction, which is committed or rolled back by its owner
+type nestedTx struct {
+	*sql.Tx
+}
+
+func (tx nestedTx) Commit() error {
+	return nil
+}
+
+func (tx nestedTx) Rollback() error {
+	return nil
 }
 
 func NewPgManager(dsn string) (*PgDatabaseManager, error) {
@@ -49,7 +146,7 @@ func NewPgManager(dsn string) (*PgDatabaseManager, error) {
 
 	return &PgDatabaseManager{
 		db:  db,
-		mdb: MetricDВ{db: db},
+		mdb: MetricDВ{db: db, prunedAt: new(int64)},
 	}, nil
 }
 
@@ -74,11 +171,50 @@ func (pgm PgDatabaseManager) Close() error {
 	return nil
 }
 
-func (db MetricDВ) GetGaugeByName(ctx context.Context, name string) (*models.GaugeValue, error) {
-	row := db.db.QueryRowContext(ctx, "SELECT name, value FROM gauges WHERE name = $1", name)
+// q returns transaction repository is bound to or database otherwise
+func (db MetricDВ) q() querier {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.db
+}
+
+// begin starts a new transaction, or returns nested one if repository is bound to transaction
+func (db MetricDВ) begin(ctx context.Context) (transaction, error) {
+	if db.tx != nil {
+		return nestedTx{db.tx}, nil
+	}
+	return db.db.BeginTx(ctx, nil)
+}
+
+// WithinTransaction calls fn with repository bound to a single database transaction, which is committed if fn
+// succeeds and rolled back otherwise
+func (db MetricDВ) WithinTransaction(ctx context.Context, fn func(r models.MetricRepository) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = fn(MetricDВ{db: db.db, tx: tx, prunedAt: db.prunedAt}); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (db MetricDВ) GetGaugeByName(ctx context.Context, name string, labels model.Labels) (*models.GaugeValue, error) {
+	row := db.q().QueryRowContext(ctx, "SELECT name, labels, value, updated_at FROM gauges WHERE name = $1 AND labels = $2", name, pgLabels(labels))
 	var g models.GaugeValue
 
-	err := row.Scan(&g.Name, &g.Value)
+	err := row.Scan(&g.Name, (*pgLabels)(&g.LabelSet), &g.Value, &g.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -88,47 +224,40 @@ func (db MetricDВ) GetGaugeByName(ctx context.Context, name string) (*models.Ga
 	return &g, nil
 }
 
-func (db MetricDВ) SaveGauge(ctx context.Context, name string, value float64) (*models.GaugeValue, error) {
+func (db MetricDВ) SaveGauge(ctx context.Context, name string, labels model.Labels, value float64) (*models.GaugeValue, error) {
 	g := models.GaugeValue{
-		Name:  name,
-		Value: value,
+		Name:     name,
+		LabelSet: labels.Copy(),
+		Value:    value,
 	}
 
-	result, err := db.db.ExecContext(ctx,
-		`INSERT INTO gauges(name, value) VALUES ($1, $2)
-				ON CONFLICT (name) DO UPDATE 
-				SET value = excluded.value`,
-		name, value)
+	row := db.q().QueryRowContext(ctx,
+		saveGaugeQuery,
+		name, pgLabels(labels), value)
 
-	if err != nil {
+	if err := row.Scan(&g.UpdatedAt); err != nil {
 		return nil, fmt.Errorf("failed to save gauge: %w", err)
 	}
 
-	if err = checkOneAffected(result); err != nil {
-		return nil, err
-	}
-
 	return &g, nil
 }
 
 func (db MetricDВ) SaveAllGauges(ctx context.Context, gs []models.GaugeValue) error {
-	tx, err := db.db.Begin()
+	tx, err := db.begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to save gauges in batch: %w", err)
 	}
 	defer tx.Rollback()
 
 	stmt, err := tx.PrepareContext(ctx,
-		`INSERT INTO gauges(name, value) VALUES ($1, $2)
-				ON CONFLICT (name) DO UPDATE 
-				SET value = excluded.value`)
+		saveGaugeQuery)
 	if err != nil {
 		return fmt.Errorf("failed to save gauges in batch: %w", err)
 	}
 	defer stmt.Close()
 
 	for _, g := range gs {
-		if _, err = stmt.ExecContext(ctx, g.Name, g.Value); err != nil {
+		if _, err = stmt.ExecContext(ctx, g.Name, pgLabels(g.LabelSet), g.Value); err != nil {
 			return fmt.Errorf("failed to save gauges in batch: %w", err)
 		}
 	}
@@ -140,10 +269,10 @@ func (db MetricDВ) SaveAllGauges(ctx context.Context, gs []models.GaugeValue) e
 	return nil
 }
 
-func (db MetricDВ) getAllGauges(ctx context.Context) ([]models.GaugeValue, error) {
+func (db MetricDВ) getAllGauges(ctx context.Context, filter model.Labels) ([]models.GaugeValue, error) {
 	gs := make([]models.GaugeValue, 0)
 
-	rows, err := db.db.QueryContext(ctx, `SELECT name, value FROM gauges`)
+	rows, err := db.q().QueryContext(ctx, `SELECT name, labels, value, updated_at FROM gauges WHERE labels @> $1`, pgLabels(filter))
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve gauges: %w", err)
 	}
@@ -151,7 +280,7 @@ func (db MetricDВ) getAllGauges(ctx context.Context) ([]models.GaugeValue, erro
 
 	for rows.Next() {
 		var g models.GaugeValue
-		err = rows.Scan(&g.Name, &g.Value)
+		err = rows.Scan(&g.Name, (*pgLabels)(&g.LabelSet), &g.Value, &g.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to retrieve gauges: %w", err)
 		}
@@ -166,11 +295,11 @@ func (db MetricDВ) getAllGauges(ctx context.Context) ([]models.GaugeValue, erro
 	return gs, nil
 }
 
-func (db MetricDВ) GetCounterByName(ctx context.Context, name string) (*models.CounterValue, error) {
-	row := db.db.QueryRowContext(ctx, "SELECT name, value FROM counters WHERE name = $1", name)
+func (db MetricDВ) GetCounterByName(ctx context.Context, name string, labels model.Labels) (*models.CounterValue, error) {
+	row := db.q().QueryRowContext(ctx, "SELECT name, labels, value, updated_at FROM counters WHERE name = $1 AND labels = $2", name, pgLabels(labels))
 	var g models.CounterValue
 
-	err := row.Scan(&g.Name, &g.Value)
+	err := row.Scan(&g.Name, (*pgLabels)(&g.LabelSet), &g.Value, &g.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -180,17 +309,14 @@ func (db MetricDВ) GetCounterByName(ctx context.Context, name string) (*models.
 	return &g, nil
 }
 
-func (db MetricDВ) AddAndSaveCounter(ctx context.Context, name string, value int64) (*models.CounterValue, error) {
-	row := db.db.QueryRowContext(ctx,
-		`INSERT INTO counters(name, value) VALUES ($1, $2)
-				ON CONFLICT (name) DO UPDATE 
-				SET value = counters.value + excluded.value
-				RETURNING counters.name, counters.value`,
-		name, value)
+func (db MetricDВ) AddAndSaveCounter(ctx context.Context, name string, labels model.Labels, value int64) (*models.CounterValue, error) {
+	row := db.q().QueryRowContext(ctx,
+		addAndSaveCounterQuery,
+		name, pgLabels(labels), value)
 
 	var c models.CounterValue
 
-	err := row.Scan(&c.Name, &c.Value)
+	err := row.Scan(&c.Name, (*pgLabels)(&c.LabelSet), &c.Value, &c.UpdatedAt)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to save counter: %w", err)
@@ -200,23 +326,21 @@ func (db MetricDВ) AddAndSaveCounter(ctx context.Context, name string, value in
 }
 
 func (db MetricDВ) AddAndSaveAllCounters(ctx context.Context, cs []models.CounterValue) error {
-	tx, err := db.db.Begin()
+	tx, err := db.begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to save all counters: %w", err)
 	}
 	defer tx.Rollback()
 
 	stmt, err := tx.PrepareContext(ctx,
-		`INSERT INTO counters(name, value) VALUES ($1, $2)
-				ON CONFLICT (name) DO UPDATE 
-				SET value = counters.value + excluded.value`)
+		addAndSaveCounterQuery)
 	if err != nil {
 		return fmt.Errorf("failed to save all counters: %w", err)
 	}
 	defer stmt.Close()
 
 	for _, c := range cs {
-		if _, err = stmt.ExecContext(ctx, c.Name, c.Value); err != nil {
+		if _, err = stmt.ExecContext(ctx, c.Name, pgLabels(c.LabelSet), c.Value); err != nil {
 			return fmt.Errorf("failed to save all counters: %w", err)
 		}
 	}
@@ -228,31 +352,160 @@ func (db MetricDВ) AddAndSaveAllCounters(ctx context.Context, cs []models.Count
 	return nil
 }
 
-func (db MetricDВ) SaveCounter(ctx context.Context, name string, value int64) (*models.CounterValue, error) {
+func (db MetricDВ) SaveCounter(ctx context.Context, name string, labels model.Labels, value int64) (*models.CounterValue, error) {
 	c := models.CounterValue{
-		Name:  name,
-		Value: value,
+		Name:     name,
+		LabelSet: labels.Copy(),
+		Value:    value,
 	}
 
-	result, err := db.db.ExecContext(ctx,
-		`INSERT INTO counters(name, value) VALUES ($1, $2)
-				ON CONFLICT (name) DO UPDATE 
-				SET value = excluded.value`,
-		name, value)
+	row := db.q().QueryRowContext(ctx,
+		`INSERT INTO counters(name, labels, value) VALUES ($1, $2, $3)
+				ON CONFLICT (name, labels) DO UPDATE 
+				SET value = excluded.value, updated_at = now()
+				RETURNING updated_at`,
+		name, pgLabels(labels), value)
 
-	if err != nil {
+	if err := row.Scan(&c.UpdatedAt); err != nil {
 		return nil, fmt.Errorf("failed to save counter to DB: %w", err)
 	}
 
-	if err = checkOneAffected(result); err != nil {
+	return &c, nil
+}
+
+func (db MetricDВ) GetHistogramByName(ctx context.Context, name string, labels model.Labels) (*models.HistogramValue, error) {
+	row := db.q().QueryRowContext(ctx, "SELECT name, labels, value, updated_at FROM histograms WHERE name = $1 AND labels = $2", name, pgLabels(labels))
+	var h models.HistogramValue
+
+	err := row.Scan(&h.Name, (*pgLabels)(&h.LabelSet), (*pgHistogram)(&h.Value), &h.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve histogram: %w", err)
+	}
+	return &h, nil
+}
+
+func (db MetricDВ) AddAndSaveHistogram(ctx context.Context, name string, labels model.Labels, value model.Histogram) (*models.HistogramValue, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save histogram: %w", err)
+	}
+	defer tx.Rollback()
+
+	h, err := addAndSaveHistogram(ctx, tx, name, labels, value)
+	if err != nil {
 		return nil, err
 	}
 
-	return &c, nil
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save histogram: %w", err)
+	}
+	return h, nil
+}
+
+func (db MetricDВ) AddAndSaveAllHistograms(ctx context.Context, hs []models.HistogramValue) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to save all histograms: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, h := range hs {
+		if _, err = addAndSaveHistogram(ctx, tx, h.Name, h.LabelSet, h.Value); err != nil {
+			return fmt.Errorf("failed to save all histograms: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to save all histograms: %w", err)
+	}
+	return nil
+}
+
+// addAndSaveHistogram merges observations into stored histogram, row is locked until the end of transaction
+func addAndSaveHistogram(ctx context.Context, tx querier, name string, labels model.Labels, value model.Histogram) (*models.HistogramValue, error) {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO histograms(name, labels, value) VALUES ($1, $2, $3)
+				ON CONFLICT (name, labels) DO NOTHING`,
+		name, pgLabels(labels), pgHistogram(model.NewHistogram(value.Bounds)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save histogram: %w", err)
+	}
+
+	h := models.HistogramValue{
+		Name:     name,
+		LabelSet: labels.Copy(),
+	}
+	row := tx.QueryRowContext(ctx,
+		"SELECT value FROM histograms WHERE name = $1 AND labels = $2 FOR UPDATE",
+		name, pgLabels(labels))
+	if err = row.Scan((*pgHistogram)(&h.Value)); err != nil {
+		return nil, fmt.Errorf("failed to save histogram: %w", err)
+	}
+
+	h.Value = h.Value.Add(value)
+	row = tx.QueryRowContext(ctx,
+		"UPDATE histograms SET value = $3, updated_at = now() WHERE name = $1 AND labels = $2 RETURNING updated_at",
+		name, pgLabels(labels), pgHistogram(h.Value))
+	if err = row.Scan(&h.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to save histogram: %w", err)
+	}
+	return &h, nil
+}
+
+func (db MetricDВ) SaveHistogram(ctx context.Context, name string, labels model.Labels, value model.Histogram) (*models.HistogramValue, error) {
+	h := models.HistogramValue{
+		Name:     name,
+		LabelSet: labels.Copy(),
+		Value:    value.Copy(),
+	}
+
+	row := db.q().QueryRowContext(ctx,
+		`INSERT INTO histograms(name, labels, value) VALUES ($1, $2, $3)
+				ON CONFLICT (name, labels) DO UPDATE
+				SET value = excluded.value, updated_at = now()
+				RETURNING updated_at`,
+		name, pgLabels(labels), pgHistogram(value))
+
+	if err := row.Scan(&h.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to save histogram to DB: %w", err)
+	}
+
+	return &h, nil
+}
+
+func (db MetricDВ) getAllHistograms(ctx context.Context, filter model.Labels) ([]models.HistogramValue, error) {
+	hs := make([]models.HistogramValue, 0)
+
+	rows, err := db.q().QueryContext(ctx, `SELECT name, labels, value, updated_at FROM histograms WHERE labels @> $1`, pgLabels(filter))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve histograms: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h models.HistogramValue
+		err = rows.Scan(&h.Name, (*pgLabels)(&h.LabelSet), (*pgHistogram)(&h.Value), &h.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve histograms: %w", err)
+		}
+
+		hs = append(hs, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve histograms: %w", err)
+	}
+
+	return hs, nil
 }
 
 func (db MetricDВ) deleteMetric(ctx context.Context, table string, name string) error {
-	_, err := db.db.ExecContext(ctx,
+	_, err := db.q().ExecContext(ctx,
 		`DELETE FROM `+table+
 			` WHERE name = $1`,
 		name)
@@ -264,10 +517,10 @@ func (db MetricDВ) deleteMetric(ctx context.Context, table string, name string)
 	return nil
 }
 
-func (db MetricDВ) getAllCounters(ctx context.Context) ([]models.CounterValue, error) {
+func (db MetricDВ) getAllCounters(ctx context.Context, filter model.Labels) ([]models.CounterValue, error) {
 	cs := make([]models.CounterValue, 0)
 
-	rows, err := db.db.QueryContext(ctx, `SELECT name, value FROM counters`)
+	rows, err := db.q().QueryContext(ctx, `SELECT name, labels, value, updated_at FROM counters WHERE labels @> $1`, pgLabels(filter))
 	if err != nil {
 		return nil, fmt.Errorf("error while reading counters from DB: %w", err)
 	}
@@ -275,7 +528,7 @@ func (db MetricDВ) getAllCounters(ctx context.Context) ([]models.CounterValue,
 
 	for rows.Next() {
 		var g models.CounterValue
-		err = rows.Scan(&g.Name, &g.Value)
+		err = rows.Scan(&g.Name, (*pgLabels)(&g.LabelSet), &g.Value, &g.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("error while reading counters from DB: %w", err)
 		}
@@ -290,15 +543,20 @@ func (db MetricDВ) getAllCounters(ctx context.Context) ([]models.CounterValue,
 	return cs, nil
 }
 
-func (db MetricDВ) GetAll(ctx context.Context) ([]model.Metric, error) {
+func (db MetricDВ) GetAll(ctx context.Context, filter model.Labels) ([]model.Metric, error) {
 	ms := make([]model.Metric, 0)
 
-	gs, err := db.getAllGauges(ctx)
+	gs, err := db.getAllGauges(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
 
-	cs, err := db.getAllCounters(ctx)
+	cs, err := db.getAllCounters(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	hs, err := db.getAllHistograms(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -309,19 +567,230 @@ func (db MetricDВ) GetAll(ctx context.Context) ([]model.Metric, error) {
 	for _, c := range cs {
 		ms = append(ms, c)
 	}
+	for _, h := range hs {
+		ms = append(ms, h)
+	}
 
 	return ms, nil
 }
 
-func checkOneAffected(r sql.Result) error {
-	rows, err := r.RowsAffected()
+func (db MetricDВ) DeleteNotUpdatedSince(ctx context.Context, t time.Time) (int64, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete stale metrics: %w", err)
+	}
+	defer tx.Rollback()
+
+	var n int64
+	for _, table := range []string{"gauges", "counters", "histograms"} {
+		result, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE updated_at < $1`, t)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete stale metrics: %w", err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete stale metrics: %w", err)
+		}
+		n += affected
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete stale metrics: %w", err)
+	}
+	return n, nil
+}
+
+func (db MetricDВ) CompactHistory(ctx context.Context, from time.Time, to time.Time, step time.Duration) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{compactGaugeHistoryQuery, compactCounterHistoryQuery} {
+		if _, err = tx.ExecContext(ctx, query, from, to, step.Seconds()); err != nil {
+			return fmt.Errorf("failed to compact history: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+	return nil
+}
+
+func (db MetricDВ) DeleteHistory(ctx context.Context, t time.Time) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete history: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"gauge_history", "counter_history"} {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE ts < $1`, t); err != nil {
+			return fmt.Errorf("failed to delete history: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to delete history: %w", err)
+	}
+	return nil
+}
+
+func (db MetricDВ) GetGaugeHistory(ctx context.Context, name string, labels model.Labels, from time.Time, to time.Time) ([]models.GaugeSample, error) {
+	gs := make([]models.GaugeSample, 0)
+
+	rows, err := db.q().QueryContext(ctx,
+		`SELECT name, labels, value, samples, ts FROM gauge_history
+				WHERE name = $1 AND labels = $2 AND ts BETWEEN $3 AND $4
+				ORDER BY ts`,
+		name, pgLabels(labels), from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve gauge history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.GaugeSample
+		err = rows.Scan(&g.Name, (*pgLabels)(&g.LabelSet), &g.Value, &g.Samples, &g.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve gauge history: %w", err)
+		}
+
+		gs = append(gs, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve gauge history: %w", err)
+	}
+
+	return gs, nil
+}
+
+func (db MetricDВ) GetCounterHistory(ctx context.Context, name string, labels model.Labels, from time.Time, to time.Time) ([]models.CounterSample, error) {
+	cs := make([]models.CounterSample, 0)
 
+	rows, err := db.q().QueryContext(ctx,
+		`SELECT name, labels, delta, value, ts FROM counter_history
+				WHERE name = $1 AND labels = $2 AND ts BETWEEN $3 AND $4
+				ORDER BY ts`,
+		name, pgLabels(labels), from, to)
 	if err != nil {
-		return fmt.Errorf("failed to check affected rows: %w", err)
+		return nil, fmt.Errorf("failed to retrieve counter history: %w", err)
 	}
-	if rows != 1 {
-		return fmt.Errorf("expected to affect 1 row, affected %d", rows)
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.CounterSample
+		err = rows.Scan(&c.Name, (*pgLabels)(&c.LabelSet), &c.Delta, &c.Value, &c.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve counter history: %w", err)
+		}
+
+		cs = append(cs, c)
 	}
 
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve counter history: %w", err)
+	}
+
+	return cs, nil
+}
+
+// pgLabels is model.Labels stored in JSONB column
+type pgLabels model.Labels
+
+func (l pgLabels) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "{}", nil
+	}
+	bs, err := json.Marshal(map[string]string(l))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal labels: %w", err)
+	}
+	return string(bs), nil
+}
+
+func (l *pgLabels) Scan(src interface{}) error {
+	var bs []byte
+	switch src := src.(type) {
+	case []byte:
+		bs = src
+	case string:
+		bs = []byte(src)
+	default:
+		return fmt.Errorf("failed to scan labels from %T", src)
+	}
+	var ls map[string]string
+	if err := json.Unmarshal(bs, &ls); err != nil {
+		return fmt.Errorf("failed to unmarshal labels: %w", err)
+	}
+	*l = pgLabels(model.Labels(ls).Copy())
+	return nil
+}
+
+// pgHistogram is model.Histogram stored in JSONB column
+type pgHistogram model.Histogram
+
+func (h pgHistogram) Value() (driver.Value, error) {
+	bs, err := json.Marshal(model.Histogram(h))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal histogram: %w", err)
+	}
+	return string(bs), nil
+}
+
+func (h *pgHistogram) Scan(src interface{}) error {
+	var bs []byte
+	switch src := src.(type) {
+	case []byte:
+		bs = src
+	case string:
+		bs = []byte(src)
+	default:
+		return fmt.Errorf("failed to scan histogram from %T", src)
+	}
+	if err := json.Unmarshal(bs, (*model.Histogram)(h)); err != nil {
+		return fmt.Errorf("failed to unmarshal histogram: %w", err)
+	}
+	return nil
+}
+
+// SaveBatchID records batch identifier. Batches applied before since are deleted at most once per
+// batchesPruneInterval, so that saving identifiers of metrics streamed one by one does not scan the table every time
+func (db MetricDВ) SaveBatchID(ctx context.Context, id string, since time.Time) (bool, error) {
+	if err := db.pruneBatches(ctx, since); err != nil {
+		return false, err
+	}
+	res, err := db.q().ExecContext(ctx, saveBatchQuery, id, since)
+	if err != nil {
+		return false, fmt.Errorf("could not save batch id: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not save batch id: %w", err)
+	}
+	return n == 1, nil
+}
+
+// pruneBatches deletes batches applied before since unless they were deleted recently
+func (db MetricDВ) pruneBatches(ctx context.Context, since time.Time) error {
+	if db.prunedAt == nil {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	prunedAt := atomic.LoadInt64(db.prunedAt)
+	if now-prunedAt < int64(batchesPruneInterval) || !atomic.CompareAndSwapInt64(db.prunedAt, prunedAt, now) {
+		return nil
+	}
+	_, err := db.db.ExecContext(ctx, deleteBatchesQuery, since)
+	if err != nil {
+		atomic.StoreInt64(db.prunedAt, prunedAt)
+		return fmt.Errorf("could not delete old batches: %w", err)
+	}
 	return nil
 }
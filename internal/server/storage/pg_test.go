@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -9,6 +10,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+
+	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/models"
 )
 
 type PgTestSuite struct {
@@ -17,6 +21,7 @@ type PgTestSuite struct {
 	pgm *PgDatabaseManager
 	gs  []string
 	cs  []string
+	hs  []string
 }
 
 func (suite *PgTestSuite) SetupSuite() {
@@ -41,7 +46,7 @@ func (suite *PgTestSuite) TestPgStorage() {
 	suite.Run("gauge not found", func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		gauge, err := r.GetGaugeByName(ctx, "test")
+		gauge, err := r.GetGaugeByName(ctx, "test", nil)
 		assert.Nil(suite.T(), gauge)
 		assert.Nil(suite.T(), err)
 	})
@@ -50,10 +55,10 @@ func (suite *PgTestSuite) TestPgStorage() {
 		defer cancel()
 		name := "test1"
 		suite.gs = append(suite.gs, name)
-		gauge, err := r.SaveGauge(ctx, name, float64(3.14))
+		gauge, err := r.SaveGauge(ctx, name, nil, float64(3.14))
 		assert.NotNil(suite.T(), gauge)
 		assert.Nil(suite.T(), err)
-		gauge, err = r.GetGaugeByName(ctx, name)
+		gauge, err = r.GetGaugeByName(ctx, name, nil)
 		assert.NotNil(suite.T(), gauge)
 		assert.Nil(suite.T(), err)
 	})
@@ -63,10 +68,10 @@ func (suite *PgTestSuite) TestPgStorage() {
 		name := "test2"
 		suite.gs = append(suite.gs, name)
 		value := float64(3.15)
-		gauge1, err := r.SaveGauge(ctx, name, value)
+		gauge1, err := r.SaveGauge(ctx, name, nil, value)
 		assert.NotNil(suite.T(), gauge1)
 		assert.NoError(suite.T(), err)
-		gauge2, err := r.GetGaugeByName(ctx, name)
+		gauge2, err := r.GetGaugeByName(ctx, name, nil)
 		assert.NotNil(suite.T(), gauge2)
 		assert.NoError(suite.T(), err)
 		assert.Equal(suite.T(), gauge2.Value, value)
@@ -78,17 +83,17 @@ func (suite *PgTestSuite) TestPgStorage() {
 			name := "test" + fmt.Sprint(i)
 			suite.gs = append(suite.gs, name)
 			value := 2.71
-			_, err := r.SaveGauge(ctx, name, value)
+			_, err := r.SaveGauge(ctx, name, nil, value)
 			assert.NoError(suite.T(), err)
 		}
-		gs, err := r.getAllGauges(ctx)
+		gs, err := r.getAllGauges(ctx, nil)
 		assert.NoError(suite.T(), err)
 		assert.True(suite.T(), len(gs) >= 3)
 	})
 	suite.Run("counter not found", func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		counter, err := r.GetCounterByName(ctx, "test")
+		counter, err := r.GetCounterByName(ctx, "test", nil)
 		assert.Nil(suite.T(), counter)
 		assert.Nil(suite.T(), err)
 	})
@@ -97,10 +102,10 @@ func (suite *PgTestSuite) TestPgStorage() {
 		defer cancel()
 		name := "test1"
 		suite.cs = append(suite.cs, name)
-		counter, err := r.AddAndSaveCounter(ctx, name, int64(314))
+		counter, err := r.AddAndSaveCounter(ctx, name, nil, int64(314))
 		assert.NotNil(suite.T(), counter)
 		assert.Nil(suite.T(), err)
-		counter, err = r.GetCounterByName(ctx, name)
+		counter, err = r.GetCounterByName(ctx, name, nil)
 		assert.NotNil(suite.T(), counter)
 		assert.Nil(suite.T(), err)
 	})
@@ -112,7 +117,7 @@ func (suite *PgTestSuite) TestPgStorage() {
 		values := []int64{1, 4, 5}
 		sums := []int64{1, 5, 10}
 		for i := 0; i < len(values); i++ {
-			counter, err := r.AddAndSaveCounter(ctx, name, values[i])
+			counter, err := r.AddAndSaveCounter(ctx, name, nil, values[i])
 			assert.NotNil(suite.T(), counter)
 			assert.Nil(suite.T(), err)
 			assert.Equal(suite.T(), counter.Value, sums[i])
@@ -125,10 +130,10 @@ func (suite *PgTestSuite) TestPgStorage() {
 			name := "test" + fmt.Sprint(i)
 			suite.cs = append(suite.cs, name)
 			value := int64(33)
-			_, err := r.SaveCounter(ctx, name, value)
+			_, err := r.SaveCounter(ctx, name, nil, value)
 			assert.NoError(suite.T(), err)
 		}
-		gs, err := r.getAllCounters(ctx)
+		gs, err := r.getAllCounters(ctx, nil)
 		assert.NoError(suite.T(), err)
 		assert.True(suite.T(), len(gs) >= 3)
 	})
@@ -139,20 +144,188 @@ func (suite *PgTestSuite) TestPgStorage() {
 			name := "test" + fmt.Sprint(i)
 			suite.gs = append(suite.gs, name)
 			value := 2.71
-			_, err := r.SaveGauge(ctx, name, value)
+			_, err := r.SaveGauge(ctx, name, nil, value)
 			assert.NoError(suite.T(), err)
 		}
 		for i := 6; i < 9; i++ {
 			name := "test" + fmt.Sprint(i)
 			suite.cs = append(suite.cs, name)
 			value := int64(33)
-			_, err := r.SaveCounter(ctx, name, value)
+			_, err := r.SaveCounter(ctx, name, nil, value)
 			assert.NoError(suite.T(), err)
 		}
-		ms, err := r.GetAll(ctx)
+		ms, err := r.GetAll(ctx, nil)
 		assert.NoError(suite.T(), err)
 		assert.True(suite.T(), len(ms) >= 6)
 	})
+	suite.Run("labeled series", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		name := "test10"
+		suite.cs = append(suite.cs, name)
+		labels := model.Labels{"host": "a"}
+		_, err := r.AddAndSaveCounter(ctx, name, nil, 1)
+		assert.NoError(suite.T(), err)
+		_, err = r.AddAndSaveCounter(ctx, name, labels, 5)
+		assert.NoError(suite.T(), err)
+		counter, err := r.GetCounterByName(ctx, name, model.Labels{"host": "a"})
+		assert.NoError(suite.T(), err)
+		if assert.NotNil(suite.T(), counter) {
+			assert.Equal(suite.T(), int64(5), counter.Value)
+			assert.Equal(suite.T(), labels, counter.LabelSet)
+		}
+		ms, err := r.GetAll(ctx, labels)
+		assert.NoError(suite.T(), err)
+		for _, m := range ms {
+			assert.Equal(suite.T(), "a", m.Labels()["host"])
+		}
+	})
+	suite.Run("histogram observations added", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		name := "test11"
+		suite.hs = append(suite.hs, name)
+		h := model.Histogram{Bounds: []float64{1}, Counts: []int64{1, 2}, Count: 3, Sum: 5}
+		_, err := r.AddAndSaveHistogram(ctx, name, nil, h)
+		assert.NoError(suite.T(), err)
+		err = r.AddAndSaveAllHistograms(ctx, []models.HistogramValue{{Name: name, Value: h}})
+		assert.NoError(suite.T(), err)
+		histogram, err := r.GetHistogramByName(ctx, name, nil)
+		assert.NoError(suite.T(), err)
+		if assert.NotNil(suite.T(), histogram) {
+			assert.Equal(suite.T(), []int64{2, 4}, histogram.Value.Counts)
+			assert.Equal(suite.T(), int64(6), histogram.Value.Count)
+			assert.Equal(suite.T(), float64(10), histogram.Value.Sum)
+		}
+	})
+	suite.Run("gauge history", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		name := "test9"
+		suite.gs = append(suite.gs, name)
+		values := []float64{1.5, 2.5}
+		from := time.Now().Add(-time.Second)
+		_, err := r.SaveGauge(ctx, name, nil, values[0])
+		assert.NoError(suite.T(), err)
+		err = r.SaveAllGauges(ctx, []models.GaugeValue{{Name: name, Value: values[1]}})
+		assert.NoError(suite.T(), err)
+		gs, err := r.GetGaugeHistory(ctx, name, nil, from, time.Now().Add(time.Second))
+		assert.NoError(suite.T(), err)
+		if assert.Len(suite.T(), gs, 2) {
+			assert.Equal(suite.T(), values[0], gs[0].Value)
+			assert.Equal(suite.T(), values[1], gs[1].Value)
+		}
+	})
+	suite.Run("counter history", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		name := "test9"
+		suite.cs = append(suite.cs, name)
+		from := time.Now().Add(-time.Second)
+		_, err := r.AddAndSaveCounter(ctx, name, nil, 3)
+		assert.NoError(suite.T(), err)
+		err = r.AddAndSaveAllCounters(ctx, []models.CounterValue{{Name: name, Value: 4}})
+		assert.NoError(suite.T(), err)
+		cs, err := r.GetCounterHistory(ctx, name, nil, from, time.Now().Add(time.Second))
+		assert.NoError(suite.T(), err)
+		if assert.Len(suite.T(), cs, 2) {
+			assert.Equal(suite.T(), int64(3), cs[0].Delta)
+			assert.Equal(suite.T(), int64(3), cs[0].Value)
+			assert.Equal(suite.T(), int64(4), cs[1].Delta)
+			assert.Equal(suite.T(), int64(7), cs[1].Value)
+		}
+	})
+	suite.Run("updated at", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		name := "test10"
+		suite.gs = append(suite.gs, name)
+		gauge, err := r.SaveGauge(ctx, name, nil, 1)
+		assert.NoError(suite.T(), err)
+		assert.False(suite.T(), gauge.UpdatedAt.IsZero())
+		stored, err := r.GetGaugeByName(ctx, name, nil)
+		assert.NoError(suite.T(), err)
+		assert.True(suite.T(), gauge.UpdatedAt.Equal(stored.UpdatedAt))
+		n, err := r.DeleteNotUpdatedSince(ctx, gauge.UpdatedAt)
+		assert.NoError(suite.T(), err)
+		assert.Zero(suite.T(), n)
+	})
+	suite.Run("history compaction", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		name := "test11"
+		suite.gs = append(suite.gs, name)
+		suite.cs = append(suite.cs, name)
+		start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 4; i++ {
+			ts := start.Add(time.Duration(i) * 30 * time.Second)
+			_, err := r.db.ExecContext(ctx, "INSERT INTO gauge_history(name, labels, value, ts) VALUES ($1, '{}', $2, $3)", name, float64(i), ts)
+			assert.NoError(suite.T(), err)
+			_, err = r.db.ExecContext(ctx, "INSERT INTO counter_history(name, labels, delta, value, ts) VALUES ($1, '{}', $2, $3, $4)", name, int64(i), int64(i*(i+1)/2), ts)
+			assert.NoError(suite.T(), err)
+		}
+		end := start.Add(2 * time.Minute)
+		err := r.CompactHistory(ctx, start, end, time.Minute)
+		assert.NoError(suite.T(), err)
+		gs, err := r.GetGaugeHistory(ctx, name, nil, start, end)
+		assert.NoError(suite.T(), err)
+		if assert.Len(suite.T(), gs, 2) {
+			assert.Equal(suite.T(), 0.5, gs[0].Value)
+			assert.True(suite.T(), start.Equal(gs[0].Timestamp))
+			assert.Equal(suite.T(), 2, gs[0].Samples)
+			assert.Equal(suite.T(), 2.5, gs[1].Value)
+		}
+		cs, err := r.GetCounterHistory(ctx, name, nil, start, end)
+		assert.NoError(suite.T(), err)
+		if assert.Len(suite.T(), cs, 2) {
+			assert.Equal(suite.T(), int64(1), cs[0].Delta)
+			assert.Equal(suite.T(), int64(1), cs[0].Value)
+			assert.Equal(suite.T(), int64(5), cs[1].Delta)
+			assert.Equal(suite.T(), int64(6), cs[1].Value)
+		}
+		err = r.DeleteHistory(ctx, end)
+		assert.NoError(suite.T(), err)
+		gs, err = r.GetGaugeHistory(ctx, name, nil, start, end)
+		assert.NoError(suite.T(), err)
+		assert.Empty(suite.T(), gs)
+	})
+	suite.Run("transaction rollback", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		name := "test12"
+		suite.gs = append(suite.gs, name)
+		suite.cs = append(suite.cs, name)
+		failure := errors.New("failure")
+		err := r.WithinTransaction(ctx, func(tx models.MetricRepository) error {
+			err := tx.SaveAllGauges(ctx, []models.GaugeValue{{Name: name, Value: 1}})
+			assert.NoError(suite.T(), err)
+			err = tx.AddAndSaveAllCounters(ctx, []models.CounterValue{{Name: name, Value: 1}})
+			assert.NoError(suite.T(), err)
+			return failure
+		})
+		assert.ErrorIs(suite.T(), err, failure)
+		g, err := r.GetGaugeByName(ctx, name, nil)
+		assert.NoError(suite.T(), err)
+		assert.Nil(suite.T(), g)
+		c, err := r.GetCounterByName(ctx, name, nil)
+		assert.NoError(suite.T(), err)
+		assert.Nil(suite.T(), c)
+	})
+	suite.Run("batch id", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		id := fmt.Sprintf("batch%d", time.Now().UnixNano())
+		since := time.Now().Add(-time.Minute)
+		fresh, err := r.SaveBatchID(ctx, id, since)
+		assert.NoError(suite.T(), err)
+		assert.True(suite.T(), fresh)
+		fresh, err = r.SaveBatchID(ctx, id, since)
+		assert.NoError(suite.T(), err)
+		assert.False(suite.T(), fresh)
+		fresh, err = r.SaveBatchID(ctx, id, time.Now().Add(time.Minute))
+		assert.NoError(suite.T(), err)
+		assert.True(suite.T(), fresh, "batch is forgotten after window")
+	})
 }
 
 func (suite *PgTestSuite) TearDownSuite() {
@@ -166,11 +339,19 @@ func (suite *PgTestSuite) TearDownSuite() {
 	}
 	deleteGauge := deleteHelper("gauges")
 	deleteCounter := deleteHelper("counters")
+	deleteGaugeHistory := deleteHelper("gauge_history")
+	deleteCounterHistory := deleteHelper("counter_history")
+	deleteHistogram := deleteHelper("histograms")
 	for _, gauge := range suite.gs {
 		deleteGauge(gauge)
+		deleteGaugeHistory(gauge)
 	}
 	for _, counter := range suite.cs {
 		deleteCounter(counter)
+		deleteCounterHistory(counter)
+	}
+	for _, histogram := range suite.hs {
+		deleteHistogram(histogram)
 	}
 	err := suite.pgm.Close()
 	suite.Require().NoError(err)
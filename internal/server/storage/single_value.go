@@ -2,90 +2,370 @@ package storage
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/tony-spark/metrico/internal/model"
 	"github.com/tony-spark/metrico/internal/server/models"
 )
 
+// SingleValueRepository is an in-memory models.MetricRepository keeping current values only, safe for concurrent use
 type SingleValueRepository struct {
-	gauges   map[string]*models.GaugeValue
-	counters map[string]*models.CounterValue
+	mu         rwLocker
+	gauges     map[string]*models.GaugeValue
+	counters   map[string]*models.CounterValue
+	histograms map[string]*models.HistogramValue
+	undo       *undoLog // previous values of series changed within transaction, nil if not bound to transaction
+}
+
+// undoLog keeps values of series changed within transaction as they were before the first change (nil if series did
+// not exist), so that only these series are restored if transaction fails
+type undoLog struct {
+	gauges     map[string]*models.GaugeValue
+	counters   map[string]*models.CounterValue
+	histograms map[string]*models.HistogramValue
 }
 
 func NewSingleValueRepository() *SingleValueRepository {
 	return &SingleValueRepository{
-		gauges:   make(map[string]*models.GaugeValue),
-		counters: make(map[string]*models.CounterValue),
+		mu:         new(sync.RWMutex),
+		gauges:     make(map[string]*models.GaugeValue),
+		counters:   make(map[string]*models.CounterValue),
+		histograms: make(map[string]*models.HistogramValue),
 	}
 }
 
-func (r SingleValueRepository) GetGaugeByName(_ context.Context, name string) (*models.GaugeValue, error) {
-	return r.gauges[name], nil
-}
+func (r SingleValueRepository) GetGaugeByName(_ context.Context, name string, labels model.Labels) (*models.GaugeValue, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-func (r SingleValueRepository) SaveGauge(_ context.Context, name string, value float64) (*models.GaugeValue, error) {
-	gauge, ok := r.gauges[name]
+	g, ok := r.gauges[seriesKey(name, labels)]
 	if !ok {
-		gauge = &models.GaugeValue{Name: name}
-		r.gauges[name] = gauge
+		return nil, nil
 	}
-	gauge.Value = value
-	return gauge, nil
+	gv := *g
+	return &gv, nil
+}
+
+func (r SingleValueRepository) SaveGauge(_ context.Context, name string, labels model.Labels, value float64) (*models.GaugeValue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	gv := *r.saveGauge(name, labels, value)
+	return &gv, nil
 }
 
-func (r SingleValueRepository) SaveAllGauges(ctx context.Context, gs []models.GaugeValue) error {
+func (r SingleValueRepository) SaveAllGauges(_ context.Context, gs []models.GaugeValue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	for _, g := range gs {
-		_, err := r.SaveGauge(ctx, g.Name, g.Value)
-		if err != nil {
-			return err
-		}
+		r.saveGauge(g.Name, g.LabelSet, g.Value)
 	}
 	return nil
 }
 
-func (r SingleValueRepository) GetCounterByName(_ context.Context, name string) (*models.CounterValue, error) {
-	return r.counters[name], nil
+func (r SingleValueRepository) saveGauge(name string, labels model.Labels, value float64) *models.GaugeValue {
+	key := seriesKey(name, labels)
+	gauge, ok := r.gauges[key]
+	r.undo.gauge(key, gauge)
+	if !ok {
+		gauge = &models.GaugeValue{Name: name, LabelSet: labels.Copy()}
+		r.gauges[key] = gauge
+	}
+	gauge.Value = value
+	gauge.UpdatedAt = time.Now()
+	return gauge
 }
 
-func (r SingleValueRepository) AddAndSaveCounter(_ context.Context, name string, value int64) (*models.CounterValue, error) {
-	counter, ok := r.counters[name]
+func (r SingleValueRepository) GetCounterByName(_ context.Context, name string, labels model.Labels) (*models.CounterValue, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.counters[seriesKey(name, labels)]
+	if !ok {
+		return nil, nil
+	}
+	cv := *c
+	return &cv, nil
+}
+
+func (r SingleValueRepository) AddAndSaveCounter(_ context.Context, name string, labels model.Labels, value int64) (*models.CounterValue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cv := *r.addCounter(name, labels, value)
+	return &cv, nil
+}
+
+func (r SingleValueRepository) AddAndSaveAllCounters(_ context.Context, cs []models.CounterValue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range cs {
+		r.addCounter(c.Name, c.LabelSet, c.Value)
+	}
+	return nil
+}
+
+func (r SingleValueRepository) addCounter(name string, labels model.Labels, value int64) *models.CounterValue {
+	key := seriesKey(name, labels)
+	counter, ok := r.counters[key]
+	r.undo.counter(key, counter)
 	if !ok {
 		counter = &models.CounterValue{
-			Name:  name,
-			Value: 0,
+			Name:     name,
+			LabelSet: labels.Copy(),
+			Value:    0,
 		}
-		r.counters[name] = counter
+		r.counters[key] = counter
 	}
 	counter.Value += value
-	return counter, nil
+	counter.UpdatedAt = time.Now()
+	return counter
 }
 
-func (r SingleValueRepository) AddAndSaveAllCounters(ctx context.Context, cs []models.CounterValue) error {
-	for _, c := range cs {
-		_, err := r.AddAndSaveCounter(ctx, c.Name, c.Value)
-		if err != nil {
-			return err
-		}
+func (r SingleValueRepository) SaveCounter(_ context.Context, name string, labels model.Labels, value int64) (*models.CounterValue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := seriesKey(name, labels)
+	r.undo.counter(key, r.counters[key])
+	counter := &models.CounterValue{
+		Name:      name,
+		LabelSet:  labels.Copy(),
+		Value:     value,
+		UpdatedAt: time.Now(),
+	}
+	r.counters[key] = counter
+	cv := *counter
+	return &cv, nil
+}
+
+func (r SingleValueRepository) GetHistogramByName(_ context.Context, name string, labels model.Labels) (*models.HistogramValue, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h, ok := r.histograms[seriesKey(name, labels)]
+	if !ok {
+		return nil, nil
+	}
+	hv := *h
+	return &hv, nil
+}
+
+func (r SingleValueRepository) AddAndSaveHistogram(_ context.Context, name string, labels model.Labels, value model.Histogram) (*models.HistogramValue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hv := *r.addHistogram(name, labels, value)
+	return &hv, nil
+}
+
+func (r SingleValueRepository) AddAndSaveAllHistograms(_ context.Context, hs []models.HistogramValue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, h := range hs {
+		r.addHistogram(h.Name, h.LabelSet, h.Value)
 	}
 	return nil
 }
 
-func (r SingleValueRepository) SaveCounter(_ context.Context, name string, value int64) (*models.CounterValue, error) {
-	counter := &models.CounterValue{
-		Name:  name,
-		Value: value,
+// addHistogram adds observations to histogram, stored value is replaced rather than modified (see model.Histogram.Add),
+// so that values returned before are not changed
+func (r SingleValueRepository) addHistogram(name string, labels model.Labels, value model.Histogram) *models.HistogramValue {
+	key := seriesKey(name, labels)
+	histogram, ok := r.histograms[key]
+	r.undo.histogram(key, histogram)
+	if !ok {
+		histogram = &models.HistogramValue{
+			Name:     name,
+			LabelSet: labels.Copy(),
+			Value:    model.NewHistogram(value.Bounds),
+		}
+		r.histograms[key] = histogram
+	}
+	histogram.Value = histogram.Value.Add(value)
+	histogram.UpdatedAt = time.Now()
+	return histogram
+}
+
+func (r SingleValueRepository) SaveHistogram(_ context.Context, name string, labels model.Labels, value model.Histogram) (*models.HistogramValue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := seriesKey(name, labels)
+	r.undo.histogram(key, r.histograms[key])
+	histogram := &models.HistogramValue{
+		Name:      name,
+		LabelSet:  labels.Copy(),
+		Value:     value.Copy(),
+		UpdatedAt: time.Now(),
 	}
-	r.counters[name] = counter
-	return counter, nil
+	r.histograms[key] = histogram
+	hv := *histogram
+	return &hv, nil
 }
 
-func (r SingleValueRepository) GetAll(_ context.Context) ([]model.Metric, error) {
-	ms := make([]model.Metric, 0, len(r.counters)+len(r.gauges))
+func (r SingleValueRepository) GetAll(_ context.Context, filter model.Labels) ([]model.Metric, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ms := make([]model.Metric, 0, len(r.counters)+len(r.gauges)+len(r.histograms))
 	for _, c := range r.counters {
-		ms = append(ms, *c)
+		if c.LabelSet.Matches(filter) {
+			ms = append(ms, *c)
+		}
 	}
 	for _, g := range r.gauges {
-		ms = append(ms, *g)
+		if g.LabelSet.Matches(filter) {
+			ms = append(ms, *g)
+		}
+	}
+	for _, h := range r.histograms {
+		if h.LabelSet.Matches(filter) {
+			ms = append(ms, *h)
+		}
 	}
 	return ms, nil
 }
+
+func (r SingleValueRepository) DeleteNotUpdatedSince(_ context.Context, t time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for key, g := range r.gauges {
+		if g.UpdatedAt.Before(t) {
+			r.undo.gauge(key, g)
+			delete(r.gauges, key)
+			n++
+		}
+	}
+	for key, c := range r.counters {
+		if c.UpdatedAt.Before(t) {
+			r.undo.counter(key, c)
+			delete(r.counters, key)
+			n++
+		}
+	}
+	for key, h := range r.histograms {
+		if h.UpdatedAt.Before(t) {
+			r.undo.histogram(key, h)
+			delete(r.histograms, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// WithinTransaction calls fn with repository bound to the same data, changes of fn are reverted if it fails.
+// Repository is locked until fn returns
+func (r SingleValueRepository) WithinTransaction(_ context.Context, fn func(r models.MetricRepository) error) error {
+	if r.undo != nil {
+		// changes of nested transaction are reverted by the outer one
+		return fn(&r)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.withinTransaction(func(tx *SingleValueRepository) error {
+		return fn(tx)
+	})
+}
+
+// withinTransaction calls fn with repository which records changes, the ones made by fn are reverted if it fails.
+// Caller must hold the lock
+func (r SingleValueRepository) withinTransaction(fn func(tx *SingleValueRepository) error) error {
+	tx := r
+	tx.mu = noLock{}
+	tx.undo = &undoLog{
+		gauges:     make(map[string]*models.GaugeValue),
+		counters:   make(map[string]*models.CounterValue),
+		histograms: make(map[string]*models.HistogramValue),
+	}
+	if err := fn(&tx); err != nil {
+		r.rollback(tx.undo)
+		return err
+	}
+	return nil
+}
+
+// rollback restores series recorded in undo log
+func (r SingleValueRepository) rollback(undo *undoLog) {
+	for key, g := range undo.gauges {
+		if g == nil {
+			delete(r.gauges, key)
+		} else {
+			r.gauges[key] = g
+		}
+	}
+	for key, c := range undo.counters {
+		if c == nil {
+			delete(r.counters, key)
+		} else {
+			r.counters[key] = c
+		}
+	}
+	for key, h := range undo.histograms {
+		if h == nil {
+			delete(r.histograms, key)
+		} else {
+			r.histograms[key] = h
+		}
+	}
+}
+
+// gauge records gauge value (nil if gauge does not exist) unless it is already recorded, log may be nil
+func (l *undoLog) gauge(key string, g *models.GaugeValue) {
+	if l == nil {
+		return
+	}
+	if _, ok := l.gauges[key]; ok {
+		return
+	}
+	if g == nil {
+		l.gauges[key] = nil
+		return
+	}
+	gv := *g
+	l.gauges[key] = &gv
+}
+
+// counter records counter value (nil if counter does not exist) unless it is already recorded, log may be nil
+func (l *undoLog) counter(key string, c *models.CounterValue) {
+	if l == nil {
+		return
+	}
+	if _, ok := l.counters[key]; ok {
+		return
+	}
+	if c == nil {
+		l.counters[key] = nil
+		return
+	}
+	cv := *c
+	l.counters[key] = &cv
+}
+
+// histogram records histogram value (nil if histogram does not exist) unless it is already recorded, log may be nil
+func (l *undoLog) histogram(key string, h *models.HistogramValue) {
+	if l == nil {
+		return
+	}
+	if _, ok := l.histograms[key]; ok {
+		return
+	}
+	if h == nil {
+		l.histograms[key] = nil
+		return
+	}
+	hv := *h
+	l.histograms[key] = &hv
+}
+
+// seriesKey returns key identifying metric of a given type by its name and labels
+func seriesKey(name string, labels model.Labels) string {
+	return name + labels.String()
+}
@@ -2,50 +2,56 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/tony-spark/metrico/internal/model"
+	"github.com/tony-spark/metrico/internal/server/models"
 )
 
 func TestSingleValueRepository(t *testing.T) {
 	r := NewSingleValueRepository()
 
 	t.Run("gauge not found", func(t *testing.T) {
-		gauge, err := r.GetGaugeByName(context.Background(), "test")
+		gauge, err := r.GetGaugeByName(context.Background(), "test", nil)
 		assert.Nil(t, gauge)
 		assert.Nil(t, err)
 	})
 	t.Run("gauge saved and found", func(t *testing.T) {
 		name := "test1"
-		gauge, err := r.SaveGauge(context.Background(), name, float64(3.14))
+		gauge, err := r.SaveGauge(context.Background(), name, nil, float64(3.14))
 		assert.NotNil(t, gauge)
 		assert.Nil(t, err)
-		gauge, err = r.GetGaugeByName(context.Background(), name)
+		gauge, err = r.GetGaugeByName(context.Background(), name, nil)
 		assert.NotNil(t, gauge)
 		assert.Nil(t, err)
 	})
 	t.Run("gauge value", func(t *testing.T) {
 		name := "test2"
 		value := float64(3.15)
-		gauge1, err := r.SaveGauge(context.Background(), name, value)
+		gauge1, err := r.SaveGauge(context.Background(), name, nil, value)
 		assert.NotNil(t, gauge1)
 		assert.Nil(t, err)
-		gauge2, err := r.GetGaugeByName(context.Background(), name)
+		gauge2, err := r.GetGaugeByName(context.Background(), name, nil)
 		assert.NotNil(t, gauge2)
 		assert.Nil(t, err)
 		assert.Equal(t, gauge2.Value, value)
 	})
 	t.Run("counter not found", func(t *testing.T) {
-		counter, err := r.GetCounterByName(context.Background(), "test")
+		counter, err := r.GetCounterByName(context.Background(), "test", nil)
 		assert.Nil(t, counter)
 		assert.Nil(t, err)
 	})
 	t.Run("counter saved and found", func(t *testing.T) {
 		name := "test1"
-		counter, err := r.AddAndSaveCounter(context.Background(), name, int64(314))
+		counter, err := r.AddAndSaveCounter(context.Background(), name, nil, int64(314))
 		assert.NotNil(t, counter)
 		assert.Nil(t, err)
-		counter, err = r.GetCounterByName(context.Background(), name)
+		counter, err = r.GetCounterByName(context.Background(), name, nil)
 		assert.NotNil(t, counter)
 		assert.Nil(t, err)
 	})
@@ -54,10 +60,145 @@ func TestSingleValueRepository(t *testing.T) {
 		values := []int64{1, 4, 5}
 		sums := []int64{1, 5, 10}
 		for i := 0; i < len(values); i++ {
-			counter, err := r.AddAndSaveCounter(context.Background(), name, values[i])
+			counter, err := r.AddAndSaveCounter(context.Background(), name, nil, values[i])
 			assert.NotNil(t, counter)
 			assert.Nil(t, err)
 			assert.Equal(t, counter.Value, sums[i])
 		}
 	})
+	t.Run("labeled series are separate", func(t *testing.T) {
+		name := "test3"
+		labels := model.Labels{"host": "a"}
+		_, err := r.AddAndSaveCounter(context.Background(), name, nil, 1)
+		assert.Nil(t, err)
+		_, err = r.AddAndSaveCounter(context.Background(), name, labels, 5)
+		assert.Nil(t, err)
+		counter, err := r.GetCounterByName(context.Background(), name, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), counter.Value)
+		counter, err = r.GetCounterByName(context.Background(), name, model.Labels{"host": "a"})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(5), counter.Value)
+		assert.Equal(t, labels, counter.Labels())
+		counter, err = r.GetCounterByName(context.Background(), name, model.Labels{"host": "b"})
+		assert.Nil(t, counter)
+		assert.Nil(t, err)
+	})
+	t.Run("histogram observations added", func(t *testing.T) {
+		name := "test5"
+		h := model.Histogram{Bounds: []float64{1}, Counts: []int64{1, 2}, Count: 3, Sum: 5}
+		_, err := r.AddAndSaveHistogram(context.Background(), name, nil, h)
+		assert.Nil(t, err)
+		histogram, err := r.AddAndSaveHistogram(context.Background(), name, nil, h)
+		assert.Nil(t, err)
+		assert.Equal(t, []int64{2, 4}, histogram.Value.Counts)
+		assert.Equal(t, int64(6), histogram.Value.Count)
+		assert.Equal(t, []int64{1, 2}, h.Counts)
+		histogram, err = r.GetHistogramByName(context.Background(), name, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, float64(10), histogram.Value.Sum)
+	})
+	t.Run("get all filtered by labels", func(t *testing.T) {
+		_, err := r.SaveGauge(context.Background(), "test4", model.Labels{"host": "a", "dc": "x"}, 1)
+		assert.Nil(t, err)
+		_, err = r.SaveGauge(context.Background(), "test4", model.Labels{"host": "b"}, 2)
+		assert.Nil(t, err)
+		ms, err := r.GetAll(context.Background(), model.Labels{"host": "a"})
+		assert.Nil(t, err)
+		assert.Len(t, ms, 2)
+		for _, m := range ms {
+			assert.Equal(t, "a", m.Labels()["host"])
+		}
+		ms, err = r.GetAll(context.Background(), nil)
+		assert.Nil(t, err)
+		assert.Len(t, ms, 9)
+	})
+	t.Run("delete not updated since", func(t *testing.T) {
+		r := NewSingleValueRepository()
+		_, err := r.SaveGauge(context.Background(), "old", nil, 1)
+		assert.Nil(t, err)
+		_, err = r.AddAndSaveCounter(context.Background(), "old", nil, 1)
+		assert.Nil(t, err)
+		since := time.Now()
+		g, err := r.SaveGauge(context.Background(), "new", nil, 1)
+		assert.Nil(t, err)
+		assert.False(t, g.UpdatedAt.Before(since))
+		n, err := r.DeleteNotUpdatedSince(context.Background(), since)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), n)
+		ms, err := r.GetAll(context.Background(), nil)
+		assert.Nil(t, err)
+		if assert.Len(t, ms, 1) {
+			assert.Equal(t, "new", ms[0].ID())
+		}
+	})
+	t.Run("transaction rollback", func(t *testing.T) {
+		r := NewSingleValueRepository()
+		_, err := r.SaveGauge(context.Background(), "g", nil, 1)
+		assert.Nil(t, err)
+		failure := errors.New("failure")
+		err = r.WithinTransaction(context.Background(), func(tx models.MetricRepository) error {
+			_, err := tx.SaveGauge(context.Background(), "g", nil, 2)
+			assert.Nil(t, err)
+			_, err = tx.AddAndSaveCounter(context.Background(), "c", nil, 1)
+			assert.Nil(t, err)
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+		g, err := r.GetGaugeByName(context.Background(), "g", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 1.0, g.Value)
+		c, err := r.GetCounterByName(context.Background(), "c", nil)
+		assert.Nil(t, err)
+		assert.Nil(t, c)
+	})
+	t.Run("transaction commit", func(t *testing.T) {
+		r := NewSingleValueRepository()
+		err := r.WithinTransaction(context.Background(), func(tx models.MetricRepository) error {
+			_, err := tx.AddAndSaveCounter(context.Background(), "c", nil, 1)
+			return err
+		})
+		assert.Nil(t, err)
+		c, err := r.GetCounterByName(context.Background(), "c", nil)
+		assert.Nil(t, err)
+		if assert.NotNil(t, c) {
+			assert.Equal(t, int64(1), c.Value)
+		}
+	})
+	t.Run("failed transaction keeps concurrent updates", func(t *testing.T) {
+		r := NewSingleValueRepository()
+		failure := errors.New("failure")
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				_, err := r.AddAndSaveCounter(context.Background(), "other", nil, 1)
+				assert.Nil(t, err)
+			}()
+			go func() {
+				defer wg.Done()
+				err := r.WithinTransaction(context.Background(), func(tx models.MetricRepository) error {
+					_, err := tx.AddAndSaveCounter(context.Background(), "c", nil, 1)
+					assert.Nil(t, err)
+					return failure
+				})
+				assert.ErrorIs(t, err, failure)
+			}()
+			go func() {
+				defer wg.Done()
+				_, err := r.DeleteNotUpdatedSince(context.Background(), time.Now().Add(-time.Hour))
+				assert.Nil(t, err)
+			}()
+		}
+		wg.Wait()
+		c, err := r.GetCounterByName(context.Background(), "other", nil)
+		assert.Nil(t, err)
+		if assert.NotNil(t, c) {
+			assert.Equal(t, int64(50), c.Value)
+		}
+		c, err = r.GetCounterByName(context.Background(), "c", nil)
+		assert.Nil(t, err)
+		assert.Nil(t, c)
+	})
 }
@@ -16,6 +16,198 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/api/v1/alerts": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get active and recently resolved alerts",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/alerting.Alert"
+                            }
+                        }
+                    },
+                    "501": {
+                        "description": "Alerting is not configured",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/query_range": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get metric history aggregated into time buckets",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Metric name",
+                        "name": "name",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "gauge",
+                            "counter"
+                        ],
+                        "type": "string",
+                        "description": "Metric type",
+                        "name": "type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Metric label in key=value form",
+                        "name": "label",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Agent identifier (same as label=source=...)",
+                        "name": "source",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of range (RFC3339 or unix time), an hour before end by default",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of range (RFC3339 or unix time), current time by default",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "1m",
+                        "description": "Bucket size",
+                        "name": "step",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "min",
+                            "max",
+                            "avg",
+                            "last",
+                            "sum",
+                            "rate"
+                        ],
+                        "type": "string",
+                        "description": "Aggregation within bucket (last for gauges and sum for counters by default)",
+                        "name": "agg",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.RangeResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid query",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "501": {
+                        "description": "Metrics history is not kept",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/sources": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get summary of metrics grouped by source (agent identifier)",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/dto.Source"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "produces": [
+                    "text/plain"
+                ],
+                "summary": "Get server liveness (regardless of database connection, see /ping)",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "description": "Stale metrics are not exported, so Prometheus marks them stale as well",
+                "produces": [
+                    "text/plain"
+                ],
+                "summary": "Get all metrics in Prometheus text exposition format",
+                "parameters": [
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Export only metrics having label in key=value form",
+                        "name": "label",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Export only metrics of agent (same as label=source=...)",
+                        "name": "source",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Metrics",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/ping": {
             "get": {
                 "summary": "Get database connection status",
@@ -40,6 +232,7 @@ const docTemplate = `{
         },
         "/update": {
             "post": {
+                "description": "Protobuf body is a Batch message with a single metric (see proto/metrico.proto)",
                 "produces": [
                     "application/json"
                 ],
@@ -53,6 +246,27 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/dto.Metric"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Agent identifier, recorded as source label",
+                        "name": "X-Agent-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Whether body is encrypted with server public key (required if server has private key)",
+                        "name": "X-Encrypted",
+                        "in": "header"
+                    },
+                    {
+                        "enum": [
+                            "gzip"
+                        ],
+                        "type": "string",
+                        "description": "Body compression",
+                        "name": "Content-Encoding",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -82,6 +296,22 @@ const docTemplate = `{
                         "name": "metric_value",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Metric label in key=value form",
+                        "name": "label",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Agent identifier, recorded as source label",
+                        "name": "X-Agent-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {}
@@ -104,6 +334,22 @@ const docTemplate = `{
                         "name": "metric_value",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Metric label in key=value form",
+                        "name": "label",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Agent identifier, recorded as source label",
+                        "name": "X-Agent-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {}
@@ -111,22 +357,41 @@ const docTemplate = `{
         },
         "/updates": {
             "post": {
+                "description": "Body is either a batch or an array of metrics (batch without ID).\nBatch which ID was already applied recently is acknowledged without being applied again.\nProtobuf body is a Batch message (see proto/metrico.proto)",
                 "produces": [
                     "application/json"
                 ],
                 "summary": "Update metric value of multiple metrics",
                 "parameters": [
                     {
-                        "description": "Metric's data",
+                        "description": "Batch of metrics",
                         "name": "metric_data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/dto.Metric"
-                            }
+                            "$ref": "#/definitions/dto.Batch"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Agent identifier, recorded as source label",
+                        "name": "X-Agent-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Whether body is encrypted with server public key (required if server has private key)",
+                        "name": "X-Encrypted",
+                        "in": "header"
+                    },
+                    {
+                        "enum": [
+                            "gzip"
+                        ],
+                        "type": "string",
+                        "description": "Body compression",
+                        "name": "Content-Encoding",
+                        "in": "header"
                     }
                 ],
                 "responses": {}
@@ -176,6 +441,22 @@ const docTemplate = `{
                         "name": "metric_name",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Metric label in key=value form",
+                        "name": "label",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Agent identifier (same as label=source=...)",
+                        "name": "source",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -190,6 +471,67 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "alerting.Alert": {
+            "type": "object",
+            "properties": {
+                "active_since": {
+                    "description": "when condition started to hold",
+                    "type": "string"
+                },
+                "condition": {
+                    "type": "string"
+                },
+                "fired_at": {
+                    "description": "when alert fired",
+                    "type": "string"
+                },
+                "labels": {
+                    "$ref": "#/definitions/model.Labels"
+                },
+                "metric": {
+                    "type": "string"
+                },
+                "resolved_at": {
+                    "description": "when alert was resolved",
+                    "type": "string"
+                },
+                "rule": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "firing",
+                        "resolved"
+                    ]
+                },
+                "type": {
+                    "type": "string"
+                },
+                "value": {
+                    "description": "value of rule function at last evaluation",
+                    "type": "number"
+                }
+            }
+        },
+        "dto.Batch": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "description": "batch identifier, unique per sending attempt",
+                    "type": "string",
+                    "example": "5f1d7a3c9e0b4d2a8c6e1f3b7a9d0c2e"
+                },
+                "metrics": {
+                    "description": "metrics of batch",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dto.Metric"
+                    }
+                }
+            }
+        },
         "dto.Metric": {
             "type": "object",
             "properties": {
@@ -202,23 +544,172 @@ const docTemplate = `{
                     "type": "string",
                     "format": "HEX"
                 },
+                "histogram": {
+                    "description": "observations of histogram metric",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.Histogram"
+                        }
+                    ]
+                },
                 "id": {
                     "description": "metric's ID",
                     "type": "string"
                 },
+                "labels": {
+                    "description": "metric's labels",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.Labels"
+                        }
+                    ]
+                },
+                "stale": {
+                    "description": "whether metric was not updated for too long (server responses only)",
+                    "type": "boolean"
+                },
                 "type": {
-                    "description": "type of metric (\"gauge\" or \"counter)",
+                    "description": "type of metric (\"gauge\", \"counter\" or \"histogram\")",
                     "type": "string",
                     "enum": [
                         "gauge",
-                        "counter"
+                        "counter",
+                        "histogram"
                     ]
                 },
+                "updated_at": {
+                    "description": "when metric was last updated (server responses only)",
+                    "type": "string"
+                },
                 "value": {
                     "description": "value of gauge metric",
                     "type": "number"
                 }
             }
+        },
+        "dto.Point": {
+            "type": "object",
+            "properties": {
+                "ts": {
+                    "description": "start of time bucket",
+                    "type": "string"
+                },
+                "value": {
+                    "description": "aggregated value",
+                    "type": "number"
+                }
+            }
+        },
+        "dto.RangeResult": {
+            "type": "object",
+            "properties": {
+                "aggregation": {
+                    "description": "aggregation of values within bucket",
+                    "type": "string",
+                    "enum": [
+                        "min",
+                        "max",
+                        "avg",
+                        "last",
+                        "sum",
+                        "rate"
+                    ]
+                },
+                "id": {
+                    "description": "metric's ID",
+                    "type": "string"
+                },
+                "labels": {
+                    "description": "metric's labels",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.Labels"
+                        }
+                    ]
+                },
+                "points": {
+                    "description": "aggregated values",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dto.Point"
+                    }
+                },
+                "step": {
+                    "description": "bucket size",
+                    "type": "string",
+                    "example": "1m0s"
+                },
+                "type": {
+                    "description": "type of metric",
+                    "type": "string",
+                    "enum": [
+                        "gauge",
+                        "counter"
+                    ]
+                }
+            }
+        },
+        "dto.Source": {
+            "type": "object",
+            "properties": {
+                "counters": {
+                    "description": "number of counters",
+                    "type": "integer"
+                },
+                "gauges": {
+                    "description": "number of gauges",
+                    "type": "integer"
+                },
+                "histograms": {
+                    "description": "number of histograms",
+                    "type": "integer"
+                },
+                "last_seen": {
+                    "description": "when any metric of source was last updated",
+                    "type": "string"
+                },
+                "source": {
+                    "description": "agent identifier (empty for metrics reported without it)",
+                    "type": "string"
+                },
+                "stale": {
+                    "description": "whether every metric of source is stale (agent is down)",
+                    "type": "boolean"
+                }
+            }
+        },
+        "model.Histogram": {
+            "type": "object",
+            "properties": {
+                "bounds": {
+                    "description": "ascending upper bounds of buckets (inclusive), the last bucket (+Inf) is implicit",
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "count": {
+                    "description": "total number of observations",
+                    "type": "integer"
+                },
+                "counts": {
+                    "description": "number of observations in each bucket, one more than bounds",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "sum": {
+                    "description": "sum of observations",
+                    "type": "number"
+                }
+            }
+        },
+        "model.Labels": {
+            "type": "object",
+            "additionalProperties": {
+                "type": "string"
+            }
         }
     }
 }`